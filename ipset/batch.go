@@ -0,0 +1,166 @@
+package ipset
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// EntryResult is the result of an entry of AddMany or DelMany.
+type EntryResult struct {
+	Entry string
+	// Line is the line of the entry in the restore input, which
+	// is the index of the entry plus one.
+	Line int
+	// Err is the reason if the entry failed.
+	Err error
+}
+
+func (s set) AddMany(entries []string, options ...Option) ([]EntryResult, error) {
+	return s.AddManyContext(context.Background(), entries, options...)
+}
+
+func (s set) AddManyContext(ctx context.Context, entries []string, options ...Option) ([]EntryResult, error) {
+	return s.doMany(ctx, _add, entries, options...)
+}
+
+func (s set) DelMany(entries []string, options ...Option) ([]EntryResult, error) {
+	return s.DelManyContext(context.Background(), entries, options...)
+}
+
+func (s set) DelManyContext(ctx context.Context, entries []string, options ...Option) ([]EntryResult, error) {
+	return s.doMany(ctx, _del, entries, options...)
+}
+
+// doMany streams the entries as restore lines through one process.
+func (s set) doMany(ctx context.Context, action string, entries []string, options ...Option) ([]EntryResult, error) {
+	c := getCmd(action, s.name, s.setType)
+	defer putCmd(c)
+	c.header = s.header
+	// -exist is given to restore instead of every line
+	tail := c.appendArgs(nil, append(options[:len(options):len(options)], Exist(false))...)
+
+	o := acquireOptions().apply(options...)
+	exist := o.exist
+	var err error
+	if o.strict {
+		_, err = c.validate(o)
+	}
+	releaseOptions(o)
+
+	results := make([]EntryResult, len(entries))
+	lines := make([]string, len(entries))
+	for i, entry := range entries {
+		results[i].Entry = entry
+		if err == nil {
+			lines[i], err = joinLine(append([]string{action, s.name, entry}, tail...))
+		}
+	}
+	if err != nil {
+		return results, fmt.Errorf("ipset: can't %s entries of %s: %w", action, s.name, err)
+	}
+	err = s.batch(ctx, action, results, exist, func(i int) string {
+		return lines[i]
+	})
+	return results, err
+}
+
+// batch streams the lines made by line for the results through one
+// restore process. The ipset utility stops at the first failed line,
+// then the rest lines are fed to another process, so that every line
+// is tried.
+func (s set) batch(ctx context.Context, action string, results []EntryResult, exist bool, line func(i int) string) error {
+	args := []string{_restore}
+	if exist {
+		args = append(args, _exist)
+	}
+	for i := range results {
+		results[i].Line = i + 1
+	}
+
+	failed := 0
+	for start := 0; start < len(results); {
+		lines := &lineReader{
+			next: func(i int) (string, bool) {
+				if start+i >= len(results) {
+					return "", false
+				}
+				return line(start + i), true
+			},
+		}
+		out, err := s.client.Backend().Run(ctx, args, lines)
+		if err == nil {
+			break
+		}
+
+		n, msg := parseLineError(out)
+		if ctx.Err() != nil || n < 1 || start+n > len(results) {
+			// the process failed as a whole, the rest are unknown
+			err = failure(ctx, _restore, s.name, "", out)
+			for i := start; i < len(results); i++ {
+				results[i].Err = err
+			}
+			return fmt.Errorf("ipset: can't %s entries of %s: %w", action, s.name, err)
+		}
+		// the error tells the command of the line, e.g. add or del
+		l := line(start + n - 1)
+		if i := strings.IndexByte(l, ' '); i > 0 {
+			l = l[:i]
+		}
+		results[start+n-1].Err = newError(l, s.name, results[start+n-1].Entry, msg)
+		failed++
+		start += n
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("ipset: can't %s %d of %d entries of %s", action, failed, len(results), s.name)
+	}
+	return nil
+}
+
+var lineErrorFlag = []byte("Error in line ")
+
+// parseLineError parses the line number and the reason from the
+// "Error in line N: reason" output of restore, n is 0 if it's not
+// such an output.
+func parseLineError(out []byte) (n int, msg string) {
+	i := bytes.Index(out, lineErrorFlag)
+	if i == -1 {
+		return 0, ""
+	}
+	rest := out[i+len(lineErrorFlag):]
+	j := bytes.IndexByte(rest, ':')
+	if j == -1 {
+		return 0, ""
+	}
+	n, err := strconv.Atoi(string(rest[:j]))
+	if err != nil {
+		return 0, ""
+	}
+	return n, string(bytes.TrimSpace(rest[j+1:]))
+}
+
+// lineReader reads the lines returned by next one by one, so the
+// lines are never joined in memory.
+type lineReader struct {
+	next func(i int) (string, bool)
+	n    int
+	cur  string
+}
+
+func (r *lineReader) Read(p []byte) (int, error) {
+	for r.cur == "" {
+		line, ok := r.next(r.n)
+		if !ok {
+			return 0, io.EOF
+		}
+		r.cur = line + "\n"
+		r.n++
+	}
+	n := copy(p, r.cur)
+	r.cur = r.cur[n:]
+	return n, nil
+}
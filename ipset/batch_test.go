@@ -0,0 +1,158 @@
+package ipset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Set_AddMany(t *testing.T) {
+	t.Parallel()
+
+	c := NewClient(NewEmulator())
+	s, err := asSet(c.New("foo", HashIp, Timeout(time.Hour)))
+	require.Nil(t, err)
+	require.Nil(t, s.Add("1.1.0.2"))
+
+	entries := make([]string, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		entries = append(entries, fmt.Sprintf("1.1.%d.%d", i/256, i%256))
+	}
+	entries = append(entries, "x")
+
+	results, err := s.AddMany(entries, Timeout(time.Minute))
+	require.Error(t, err)
+	assert.Equal(t, "ipset: can't add 2 of 1001 entries of foo", err.Error())
+	require.Len(t, results, 1001)
+	for i, r := range results {
+		assert.Equal(t, entries[i], r.Entry)
+		assert.Equal(t, i+1, r.Line)
+		if i != 2 && i != 1000 {
+			assert.Nil(t, r.Err, r.Entry)
+		}
+	}
+	require.Error(t, results[2].Err)
+	assert.Equal(t, "Element cannot be added to the set: it's already added", results[2].Err.Error())
+	require.Error(t, results[1000].Err)
+
+	info, err := s.List()
+	require.Nil(t, err)
+	assert.Equal(t, 1000, info.NumEntries)
+	assert.Equal(t, time.Minute, info.Members[999].Timeout)
+
+	// Exist ignores the added entries
+	results, err = s.AddMany(entries[:10], Exist(true))
+	require.Nil(t, err)
+	for _, r := range results {
+		assert.Nil(t, r.Err)
+	}
+
+	results, err = s.AddMany(nil)
+	require.Nil(t, err)
+	assert.Len(t, results, 0)
+}
+
+func Test_Set_DelMany(t *testing.T) {
+	t.Parallel()
+
+	c := NewClient(NewEmulator())
+	s, err := asSet(c.New("foo", HashNet))
+	require.Nil(t, err)
+	_, err = s.AddMany([]string{"10.0.0.0/8", "192.168.0.0/16"})
+	require.Nil(t, err)
+
+	results, err := s.DelMany([]string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"})
+	require.Error(t, err)
+	assert.Nil(t, results[0].Err)
+	assert.Equal(t, "Element cannot be deleted from the set: it's not added", results[1].Err.Error())
+	assert.Nil(t, results[2].Err)
+
+	_, err = s.DelMany([]string{"10.0.0.0/8"}, Exist(true))
+	assert.Nil(t, err)
+
+	info, err := s.List()
+	require.Nil(t, err)
+	assert.Equal(t, 0, info.NumEntries)
+}
+
+func Test_Set_AddMany_Lines(t *testing.T) {
+	t.Parallel()
+
+	r := &recorder{}
+	s := &set{name: "foo", setType: HashIp, client: NewClient(r)}
+	_, err := s.AddMany([]string{"1.1.1.1", "1.1.1.2"},
+		Timeout(time.Minute), CommentContent("a b"), Exist(true))
+	require.Nil(t, err)
+	assert.Equal(t, []string{"restore -exist"}, r.lines)
+	assert.Equal(t, []string{
+		"add foo 1.1.1.1 timeout 60 comment \"a b\"\n" +
+			"add foo 1.1.1.2 timeout 60 comment \"a b\"\n",
+	}, r.stdin)
+
+	r.out, r.err = "ipset v7.1: fake error", errors.New("exit status 1")
+	results, err := s.DelMany([]string{"1.1.1.1", "1.1.1.2"})
+	require.Error(t, err)
+	assert.Equal(t, "ipset: can't del entries of foo: ipset v7.1: fake error", err.Error())
+	assert.Equal(t, "ipset v7.1: fake error", results[0].Err.Error())
+	assert.Equal(t, "ipset v7.1: fake error", results[1].Err.Error())
+}
+
+func Test_Set_AddMany_Injection(t *testing.T) {
+	t.Parallel()
+
+	r := &recorder{}
+	s := &set{name: "foo", setType: HashIp, client: NewClient(r)}
+	for _, entry := range []string{"1.1.1.1\ndestroy", "1.1.1.1\rdestroy", "1.1.1.1 \"\ndestroy"} {
+		results, err := s.AddMany([]string{"1.1.1.2", entry})
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrInvalidEntry), entry)
+		assert.Len(t, results, 2)
+	}
+	_, err := s.AddMany([]string{"1.1.1.1"}, CommentContent("a\ndestroy"))
+	assert.True(t, errors.Is(err, ErrInvalidEntry))
+	assert.Len(t, r.lines, 0)
+}
+
+func Test_Set_AddManyContext(t *testing.T) {
+	t.Parallel()
+
+	s, err := asSet(NewClient(NewEmulator()).New("foo", HashIp))
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, err := s.AddManyContext(ctx, []string{"1.1.1.1"})
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, errors.Is(results[0].Err, context.Canceled))
+
+	_, err = s.DelManyContext(ctx, []string{"1.1.1.1"})
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func Test_ParseLineError(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		out string
+		n   int
+		msg string
+	}{
+		{"Error in line 3: Element cannot be added", 3, "Element cannot be added"},
+		{"ipset v7.1: Error in line 12: Syntax error\n", 12, "Syntax error"},
+		{"ipset v7.1: Error in line x: Syntax error", 0, ""},
+		{"Error in line 3", 0, ""},
+		{"fake error", 0, ""},
+	}
+
+	for _, tc := range tt {
+		n, msg := parseLineError([]byte(tc.out))
+		assert.Equal(t, tc.n, n, tc.out)
+		assert.Equal(t, tc.msg, msg, tc.out)
+	}
+}
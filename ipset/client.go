@@ -0,0 +1,447 @@
+package ipset
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// Backend carries out ipset commands. The ipset utility, netlink
+// protocol, dry-run, remote or in-memory implementations can be
+// plugged into a Client.
+type Backend interface {
+	// Run runs an ipset command with args, e.g. [add foo 1.1.1.1],
+	// and stdin is fed to the command if it's not nil. It returns
+	// the combined output, which is the error message if an error
+	// is returned. The command should be given up once ctx is done.
+	Run(ctx context.Context, args []string, stdin io.Reader) ([]byte, error)
+}
+
+// BackendFunc is an adapter to allow the use of ordinary functions
+// as Backend.
+type BackendFunc func(ctx context.Context, args []string, stdin io.Reader) ([]byte, error)
+
+// Run calls f(ctx, args, stdin).
+func (f BackendFunc) Run(ctx context.Context, args []string, stdin io.Reader) ([]byte, error) {
+	return f(ctx, args, stdin)
+}
+
+// ExecBackend forks the ipset utility for every command.
+type ExecBackend struct {
+	// Path of the ipset utility. The one found by Check is used if
+	// it's empty.
+	Path string
+}
+
+// Run runs the ipset utility, which is killed once ctx is done.
+func (b ExecBackend) Run(ctx context.Context, args []string, stdin io.Reader) ([]byte, error) {
+	path := b.Path
+	if path == "" {
+		path = utilityPath()
+	}
+	c := execCommand(ctx, path, args...)
+	c.Stdin = stdin
+	return c.CombinedOutput()
+}
+
+// Streamer is implemented by backends which can feed the output of
+// a command to a reader while the command is running, so a large
+// output is never held in memory at once.
+type Streamer interface {
+	// Stream runs an ipset command with args and calls fn with its
+	// output. The error message is returned if the command fails,
+	// otherwise the error returned by fn is returned.
+	Stream(ctx context.Context, args []string, fn func(stdout io.Reader) error) ([]byte, error)
+}
+
+// Stream runs the ipset utility and reads its output through a pipe.
+func (b ExecBackend) Stream(ctx context.Context, args []string, fn func(stdout io.Reader) error) ([]byte, error) {
+	path := b.Path
+	if path == "" {
+		path = utilityPath()
+	}
+	// the utility is killed by cancel if fn stops early
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	c := execCommand(cctx, path, args...)
+	c.Stderr = &stderr
+	stdout, err := c.StdoutPipe()
+	if err != nil {
+		return []byte(err.Error()), err
+	}
+	if err = c.Start(); err != nil {
+		return []byte(err.Error()), err
+	}
+
+	ferr := fn(stdout)
+	if ferr != nil && ctx.Err() == nil {
+		// the rest is not read, the error message of the utility
+		// is kept if it failed by itself
+		cancel()
+		if err = c.Wait(); err != nil && stderr.Len() > 0 {
+			return stderr.Bytes(), err
+		}
+		return nil, ferr
+	}
+	// the rest is drained so that the utility can exit
+	_, _ = io.Copy(ioutil.Discard, stdout)
+	if err = c.Wait(); err != nil {
+		if stderr.Len() > 0 {
+			return stderr.Bytes(), err
+		}
+		return []byte(err.Error()), err
+	}
+	return nil, ferr
+}
+
+// Client carries out ipset commands with a Backend, clients with
+// different backends can be used in one process.
+type Client struct {
+	backendMu sync.RWMutex // guards backend
+	backend   Backend
+
+	mu sync.Mutex
+	// version is detected once an option needs it
+	version *VersionInfo
+}
+
+var defaultClient = NewClient(ExecBackend{})
+
+// NewClient creates a client with the backend.
+func NewClient(backend Backend) *Client {
+	return &Client{backend: backend}
+}
+
+// Backend returns the backend of the client.
+func (c *Client) Backend() Backend {
+	c.backendMu.RLock()
+	defer c.backendMu.RUnlock()
+	return c.backend
+}
+
+// utilityPath returns the ipset utility found by Check.
+func utilityPath() string {
+	pathMu.RLock()
+	defer pathMu.RUnlock()
+	return ipsetPath
+}
+
+// New create a set identified with setname and specified type.
+// The type may require type specific options. If the Exist
+// option is specified, ipset ignores the error when the same set
+// (setname and create parameters are identical) already exists.
+func (c *Client) New(name string, setType SetType, options ...Option) (IPSet, error) {
+	return c.NewContext(context.Background(), name, setType, options...)
+}
+
+// NewContext is like New but the command is given up once ctx is
+// done.
+func (c *Client) NewContext(ctx context.Context, name string, setType SetType, options ...Option) (IPSet, error) {
+	cmd := getCmd(_create, name, setType, string(setType))
+	defer putCmd(cmd)
+	if err := cmd.exec(ctx, c, options...); err != nil {
+		return nil, err
+	}
+
+	o := acquireOptions().apply(options...)
+	defer releaseOptions(o)
+	return &set{name: name, setType: setType, header: newHeader(o), client: c}, nil
+}
+
+// Open returns the existing set identified with setname, its type
+// and header are discovered by listing the set. An error wrapping
+// ErrSetNotFound is returned if the set doesn't exist.
+func (c *Client) Open(name string) (IPSet, error) {
+	return c.OpenContext(context.Background(), name)
+}
+
+// OpenContext is like Open but the command is given up once ctx is
+// done.
+func (c *Client) OpenContext(ctx context.Context, name string) (IPSet, error) {
+	out, err := c.Backend().Run(ctx, []string{_list, name, _terse}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ipset: can't open %s: %w", name, failure(ctx, _list, name, "", out))
+	}
+
+	info, err := parseInfo(out)
+	if err != nil {
+		return nil, fmt.Errorf("ipset: can't open %s: %s", name, err)
+	}
+	if info.SetType == "" {
+		return nil, fmt.Errorf("ipset: can't open %s: no type is listed", name)
+	}
+	return &set{name: name, setType: info.SetType, header: &info.Header, client: c}, nil
+}
+
+// ListAll dumps header data and the entries of all sets. The
+// Resolve option can be used to force action lookups(which may be
+// slow).
+func (c *Client) ListAll(options ...Option) ([]*Info, error) {
+	return c.ListAllContext(context.Background(), options...)
+}
+
+// ListAllContext is like ListAll but the command is given up once
+// ctx is done.
+func (c *Client) ListAllContext(ctx context.Context, options ...Option) ([]*Info, error) {
+	args := []string{_list}
+	o := acquireOptions().apply(options...)
+	if o.resolve {
+		args = append(args, _resolve)
+	}
+	if o.sorted {
+		args = append(args, _sorted)
+	}
+	if o.terse {
+		args = append(args, _terse)
+	}
+	releaseOptions(o)
+
+	var infos []*Info
+	err := c.listSets(ctx, args, func(info *Info) error {
+		if err := info.parseMembers(); err != nil {
+			return err
+		}
+		infos = append(infos, info)
+		return nil
+	})
+	return infos, err
+}
+
+// ListTerse dumps header data of all sets without their entries.
+func (c *Client) ListTerse() ([]*Info, error) {
+	return c.ListTerseContext(context.Background())
+}
+
+// ListTerseContext is like ListTerse but the command is given up
+// once ctx is done.
+func (c *Client) ListTerseContext(ctx context.Context) ([]*Info, error) {
+	var infos []*Info
+	err := c.listSets(ctx, []string{_list, _terse}, func(info *Info) error {
+		infos = append(infos, info)
+		return nil
+	})
+	return infos, err
+}
+
+// listSets streams the list output of sets to fn.
+func (c *Client) listSets(ctx context.Context, args []string, fn func(*Info) error) error {
+	out, err := c.stream(ctx, args, func(r io.Reader) error {
+		return readInfos(r, fn)
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("ipset: can't list all sets: %w", ctx.Err())
+		}
+		if len(out) > 0 {
+			return fmt.Errorf("ipset: can't list all sets: %w", newError(_list, "", "", string(out)))
+		}
+		return fmt.Errorf("ipset: can't list all sets: %s", err)
+	}
+	return nil
+}
+
+// Names returns the names of all sets.
+func (c *Client) Names() ([]string, error) {
+	return c.NamesContext(context.Background())
+}
+
+// NamesContext is like Names but the command is given up once ctx
+// is done.
+func (c *Client) NamesContext(ctx context.Context) ([]string, error) {
+	var names []string
+	out, err := c.stream(ctx, []string{_list, _names}, func(r io.Reader) error {
+		s := bufio.NewScanner(r)
+		for s.Scan() {
+			if name := strings.TrimSpace(s.Text()); name != "" {
+				names = append(names, name)
+			}
+		}
+		return s.Err()
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("ipset: can't list set names: %w", ctx.Err())
+		}
+		if len(out) > 0 {
+			return nil, fmt.Errorf("ipset: can't list set names: %w", newError(_list, "", "", string(out)))
+		}
+		return nil, fmt.Errorf("ipset: can't list set names: %s", err)
+	}
+	return names, nil
+}
+
+// stream runs the command and calls fn with its output, which is
+// read while it's produced if the backend is a Streamer. Like
+// Backend.Run, the error message is returned on failure.
+func (c *Client) stream(ctx context.Context, args []string, fn func(io.Reader) error) ([]byte, error) {
+	if s, ok := c.Backend().(Streamer); ok {
+		return s.Stream(ctx, args, fn)
+	}
+	out, err := c.Backend().Run(ctx, args, nil)
+	if err != nil {
+		return out, err
+	}
+	return nil, fn(bytes.NewReader(out))
+}
+
+// Flush all entries from the specified set or flush all sets if none
+// is given.
+func (c *Client) Flush(names ...string) error {
+	return c.FlushContext(context.Background(), names...)
+}
+
+// FlushContext is like Flush but the commands are given up once ctx
+// is done.
+func (c *Client) FlushContext(ctx context.Context, names ...string) error {
+	if len(names) == 0 {
+		return c.flushAll(ctx)
+	}
+	for _, name := range names {
+		if err := c.flush(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush flushes specific set
+func (c *Client) flush(ctx context.Context, name string) error {
+	if out, err := c.Backend().Run(ctx, []string{_flush, name}, nil); err != nil {
+		return fmt.Errorf("ipset: can't flush set %s: %w", name, failure(ctx, _flush, name, "", out))
+	}
+	return nil
+}
+
+// flushAll flushes all set
+func (c *Client) flushAll(ctx context.Context) error {
+	if out, err := c.Backend().Run(ctx, []string{_flush}, nil); err != nil {
+		return fmt.Errorf("ipset: can't flush all set: %w", failure(ctx, _flush, "", "", out))
+	}
+	return nil
+}
+
+// Destroy removes the specified set or all the sets if none is given.
+// If the set has got reference(s), nothing is done and no set destroyed.
+func (c *Client) Destroy(names ...string) error {
+	return c.DestroyContext(context.Background(), names...)
+}
+
+// DestroyContext is like Destroy but the commands are given up once
+// ctx is done.
+func (c *Client) DestroyContext(ctx context.Context, names ...string) error {
+	if len(names) == 0 {
+		return c.destroyAll(ctx)
+	}
+	for _, name := range names {
+		if err := c.destroy(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// destroy removes specific set
+func (c *Client) destroy(ctx context.Context, name string) error {
+	if out, err := c.Backend().Run(ctx, []string{_destroy, name}, nil); err != nil {
+		return fmt.Errorf("ipset: can't destroy set %s: %w", name, failure(ctx, _destroy, name, "", out))
+	}
+	return nil
+}
+
+// destroyAll removes all set
+func (c *Client) destroyAll(ctx context.Context) error {
+	if out, err := c.Backend().Run(ctx, []string{_destroy}, nil); err != nil {
+		return fmt.Errorf("ipset: can't destroy all set: %w", failure(ctx, _destroy, "", "", out))
+	}
+	return nil
+}
+
+// Swap swaps the content of two sets, or in another words,
+// exchange the action of two sets. The referred sets must
+// exist and compatible type of sets can be swapped only.
+func (c *Client) Swap(from, to string) error {
+	return c.SwapContext(context.Background(), from, to)
+}
+
+// SwapContext is like Swap but the command is given up once ctx is
+// done.
+func (c *Client) SwapContext(ctx context.Context, from, to string) error {
+	if out, err := c.Backend().Run(ctx, []string{_swap, from, to}, nil); err != nil {
+		return fmt.Errorf("ipset: can't swap from %s to %s: %w", from, to, failure(ctx, _swap, from, to, out))
+	}
+	return nil
+}
+
+// RestoreFile restores the sets saved in the file, which is read by
+// ipset itself instead of being fed to it. It requires ipset v6.24.
+func (c *Client) RestoreFile(filename string, exist ...bool) error {
+	return c.RestoreFileContext(context.Background(), filename, exist...)
+}
+
+// RestoreFileContext is like RestoreFile but the command is given up
+// once ctx is done.
+func (c *Client) RestoreFileContext(ctx context.Context, filename string, exist ...bool) error {
+	if err := c.require(ctx, FeatureFile); err != nil {
+		return fmt.Errorf("ipset: can't restore from %s: %w", filename, err)
+	}
+	args := []string{_restore, _file, filename}
+	if len(exist) > 0 && exist[0] {
+		args = append(args, _exist)
+	}
+	if out, err := c.Backend().Run(ctx, args, nil); err != nil {
+		return fmt.Errorf("ipset: can't restore from %s: %w", filename, failure(ctx, _restore, "", "", out))
+	}
+	return nil
+}
+
+// Check checks whether the backend works and its version is legal.
+// For ExecBackend without path, it looks for an ipset command in
+// the system first.
+func (c *Client) Check() error {
+	return c.CheckContext(context.Background())
+}
+
+// CheckContext is like Check but the command is given up once ctx
+// is done.
+func (c *Client) CheckContext(ctx context.Context) error {
+	backend := c.Backend()
+	if b, ok := backend.(ExecBackend); ok && b.Path == "" {
+		pathMu.Lock()
+		ready := ipsetPath != ""
+		if !ready {
+			path, err := execLookPath("ipset")
+			if err != nil {
+				pathMu.Unlock()
+				return ErrNotFound
+			}
+			ipsetPath = path
+		}
+		pathMu.Unlock()
+		if ready {
+			return nil
+		}
+	}
+
+	out, err := backend.Run(ctx, []string{_version}, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("ipset: can't check version : %w", ctx.Err())
+		}
+		return fmt.Errorf("ipset: can't check version : %s", err)
+	}
+	c.mu.Lock()
+	c.setVersion(out)
+	major := c.version.Major
+	c.mu.Unlock()
+	if major < minMajorVersion {
+		return ErrVersionNotSupported
+	}
+	return nil
+}
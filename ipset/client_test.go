@@ -0,0 +1,213 @@
+package ipset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder is a dry-run backend which records command lines.
+type recorder struct {
+	lines []string
+	stdin []string
+	out   string
+	err   error
+}
+
+func (r *recorder) Run(_ context.Context, args []string, stdin io.Reader) ([]byte, error) {
+	r.lines = append(r.lines, strings.Join(args, " "))
+	if stdin != nil {
+		b, _ := ioutil.ReadAll(stdin)
+		r.stdin = append(r.stdin, string(b))
+	}
+	return []byte(r.out), r.err
+}
+
+func Test_Client_New(t *testing.T) {
+	r := &recorder{}
+	c := NewClient(r)
+	assert.Equal(t, r, c.Backend())
+
+	s, err := c.New("foo", HashIp, Timeout(60e9), Exist(true))
+	require.Nil(t, err)
+	require.Nil(t, s.Add("1.1.1.1", Comment(true)))
+	require.Nil(t, s.Flush())
+	require.Nil(t, s.Restore(strings.NewReader("add foo 1.1.1.2\n")))
+
+	assert.Equal(t, []string{
+		"create foo hash:ip timeout 60 -exist",
+		"add foo 1.1.1.1",
+		"flush foo",
+		"restore",
+	}, r.lines)
+	assert.Equal(t, []string{"add foo 1.1.1.2\n"}, r.stdin)
+}
+
+func Test_Client_Backends(t *testing.T) {
+	r1, r2 := &recorder{}, &recorder{}
+	c1, c2 := NewClient(r1), NewClient(r2)
+
+	require.Nil(t, c1.Flush("a", "b"))
+	require.Nil(t, c2.Destroy())
+	require.Nil(t, c2.Swap("a", "b"))
+
+	assert.Equal(t, []string{"flush a", "flush b"}, r1.lines)
+	assert.Equal(t, []string{"destroy", "swap a b"}, r2.lines)
+}
+
+func Test_Client_Error(t *testing.T) {
+	c := NewClient(BackendFunc(func(_ context.Context, args []string, stdin io.Reader) ([]byte, error) {
+		return []byte("The set with the given name does not exist"), errors.New("exit status 1")
+	}))
+
+	_, err := c.New("foo", HashIp)
+	require.Error(t, err)
+	assert.Equal(t,
+		"ipset: can't create foo hash:ip: The set with the given name does not exist",
+		err.Error())
+
+	err = c.Destroy("foo")
+	require.Error(t, err)
+	assert.Equal(t,
+		"ipset: can't destroy set foo: The set with the given name does not exist",
+		err.Error())
+}
+
+func Test_Client_Open(t *testing.T) {
+	t.Parallel()
+
+	c := NewClient(NewEmulator())
+	_, err := c.New("foo", HashNetPort, Timeout(time.Minute))
+	require.Nil(t, err)
+
+	s, err := c.Open("foo")
+	require.Nil(t, err)
+	assert.Equal(t, HashNetPort, s.(*set).setType)
+	assert.True(t, s.(*set).header.HasTimeout)
+	require.Nil(t, s.Add("10.0.0.0/8,80"))
+
+	_, err = c.Open("bar")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrSetNotFound))
+	assert.Equal(t, "ipset: can't open bar: The set with the given name does not exist", err.Error())
+
+	_, err = NewClient(&recorder{out: "Name: foo\n"}).Open("foo")
+	assert.Error(t, err)
+}
+
+func Test_Client_ListAll(t *testing.T) {
+	t.Parallel()
+
+	c := NewClient(NewEmulator())
+	for i := 0; i < 1000; i++ {
+		s, err := c.New(fmt.Sprintf("set%d", i), HashIp)
+		require.Nil(t, err)
+		require.Nil(t, s.Add(fmt.Sprintf("10.0.%d.%d", i/256, i%256)))
+	}
+
+	infos, err := c.ListAll()
+	require.Nil(t, err)
+	require.Len(t, infos, 1000)
+	assert.Equal(t, "set999", infos[999].Name)
+	assert.Equal(t, "10.0.3.231", infos[999].Members[0].IP.String())
+
+	infos, err = c.ListTerse()
+	require.Nil(t, err)
+	require.Len(t, infos, 1000)
+	assert.Equal(t, 1, infos[1].NumEntries)
+	assert.Nil(t, infos[1].Entries)
+
+	names, err := c.Names()
+	require.Nil(t, err)
+	require.Len(t, names, 1000)
+	assert.Equal(t, "set0", names[0])
+
+	_, err = NewClient(&recorder{out: "Name: foo\nHeader: hashsize x\n"}).ListAll()
+	assert.Error(t, err)
+	_, err = NewClient(&recorder{out: "fake error", err: errors.New("exit status 1")}).ListTerse()
+	require.Error(t, err)
+	assert.Equal(t, "ipset: can't list all sets: fake error", err.Error())
+}
+
+func Test_Client_Check(t *testing.T) {
+	t.Run("supported", func(t *testing.T) {
+		c := NewClient(&recorder{out: validVersion})
+		assert.Nil(t, c.Check())
+	})
+
+	t.Run("non supported", func(t *testing.T) {
+		c := NewClient(&recorder{out: invalidVersion})
+		assert.Equal(t, ErrVersionNotSupported, c.Check())
+	})
+
+	t.Run("error", func(t *testing.T) {
+		c := NewClient(&recorder{err: errors.New("fake error")})
+		require.Error(t, c.Check())
+	})
+
+	t.Run("exec backend with path", func(t *testing.T) {
+		setupCmd()
+		defer teardownCmd()
+
+		c := NewClient(ExecBackend{Path: "/usr/sbin/ipset"})
+		assert.Nil(t, c.Check())
+		assert.Equal(t, "", ipsetPath)
+	})
+}
+
+func Test_Client_Context(t *testing.T) {
+	t.Parallel()
+
+	c := NewClient(NewEmulator())
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := c.NewContext(ctx, "foo", HashIp)
+	require.Nil(t, err)
+	cancel()
+
+	_, err = c.NewContext(ctx, "bar", HashIp)
+	assert.True(t, errors.Is(err, context.Canceled))
+	_, err = c.OpenContext(ctx, "foo")
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.False(t, errors.Is(err, ErrSetNotFound))
+	_, err = c.ListAllContext(ctx)
+	assert.True(t, errors.Is(err, context.Canceled))
+	_, err = c.ListTerseContext(ctx)
+	assert.True(t, errors.Is(err, context.Canceled))
+	_, err = c.NamesContext(ctx)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, errors.Is(c.FlushContext(ctx), context.Canceled))
+	assert.True(t, errors.Is(c.FlushContext(ctx, "foo"), context.Canceled))
+	assert.True(t, errors.Is(c.DestroyContext(ctx), context.Canceled))
+	assert.True(t, errors.Is(c.SwapContext(ctx, "foo", "bar"), context.Canceled))
+	assert.True(t, errors.Is(c.CheckContext(ctx), context.Canceled))
+
+	err = c.SwapContext(context.Background(), "foo", "bar")
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, context.Canceled))
+
+	names, err := c.Names()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"foo"}, names)
+}
+
+func Test_Client_Context_Exec(t *testing.T) {
+	setupHangCmd()
+	defer teardownCmd()
+
+	c := NewClient(ExecBackend{Path: "ipset"})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err := c.ListTerseContext(ctx)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, "ipset: can't list all sets: context deadline exceeded", err.Error())
+}
@@ -1,9 +1,10 @@
 package ipset
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"strconv"
-	"strings"
 	"sync"
 )
 
@@ -27,6 +28,11 @@ const (
 const (
 	_exist    = "-exist"
 	_resolve  = "-resolve"
+	_terse    = "-terse"
+	_names    = "-name"
+	_sorted   = "-sorted"
+	_output   = "-output"
+	_file     = "-file"
 	_timeout  = "timeout"
 	_counters = "counters"
 	_packets  = "packets"
@@ -45,6 +51,12 @@ const (
 	_markmask = "markmask"
 	_size     = "size"
 	_range    = "range"
+	_before   = "before"
+	_after    = "after"
+
+	_bucketsize = "bucketsize"
+	_initval    = "initval"
+	_wildcard   = "wildcard"
 )
 
 type cmd struct {
@@ -52,7 +64,10 @@ type cmd struct {
 	name    string
 	entry   string
 	setType SetType
-	out     []byte
+	// header is the header of the set if it's known, which is
+	// checked by strict commands.
+	header *Header
+	out    []byte
 }
 
 func (c *cmd) buildArgs(opts ...Option) (args []string) {
@@ -68,7 +83,7 @@ func (c *cmd) appendArgs(args []string, opts ...Option) []string {
 	o := acquireOptions().apply(opts...)
 	defer releaseOptions(o)
 
-	if o.timeout > 0 && c.needTimeout() {
+	if (o.timeout > 0 || o.hasTimeout && c.action == _create) && c.needTimeout() {
 		args = append(args, _timeout, i2str(uint64(o.timeout.Seconds())))
 	}
 
@@ -120,7 +135,7 @@ func (c *cmd) appendArgs(args []string, opts ...Option) []string {
 		args = append(args, _nomatch)
 	}
 
-	if o.forceadd && c.onlyCreate() {
+	if o.forceadd && c.needForceadd() {
 		args = append(args, _forceadd)
 	}
 
@@ -156,19 +171,69 @@ func (c *cmd) appendArgs(args []string, opts ...Option) []string {
 		args = append(args, _range, o.portRange)
 	}
 
+	if o.bucketsize != 0 && c.needHash() {
+		args = append(args, _bucketsize, i2str(uint64(o.bucketsize)))
+	}
+
+	if o.initval != 0 && c.needHash() {
+		args = append(args, _initval, fmt.Sprintf("0x%08x", o.initval))
+	}
+
+	if o.wildcard && c.needWildcard() {
+		args = append(args, _wildcard)
+	}
+
+	if o.sorted && c.needSorted() {
+		args = append(args, _sorted)
+	}
+
+	if o.output != "" && c.needOutput() {
+		args = append(args, _output, o.output)
+	}
+
+	if o.file != "" && c.onlySave() {
+		args = append(args, _file, o.file)
+	}
+
+	if o.terse && c.onlyList() {
+		args = append(args, _terse)
+	}
+
 	return args
 }
 
-func (c *cmd) exec(opts ...Option) error {
-	out, err := execCommand(ipsetPath, c.buildArgs(opts...)...).
-		CombinedOutput()
+// features returns the features needed by the options, which are
+// left out by appendArgs likewise.
+func (c *cmd) features(o *options) (fs []Feature) {
+	add := func(set bool, f Feature) {
+		if set {
+			fs = append(fs, f)
+		}
+	}
+	add(o.counters && c.needCounters(), FeatureCounters)
+	add(o.comment && c.onlyCreate(), FeatureComment)
+	add(o.skbinfo && c.onlyCreate(), FeatureSkbinfo)
+	add(o.forceadd && c.needForceadd(), FeatureForceadd)
+	add(o.bucketsize != 0 && c.needHash(), FeatureBucketsize)
+	add(o.initval != 0 && c.needHash(), FeatureInitval)
+	add(o.wildcard && c.needWildcard(), FeatureWildcard)
+	add(o.file != "" && c.onlySave(), FeatureFile)
+	return fs
+}
+
+func (c *cmd) exec(ctx context.Context, client *Client, opts ...Option) error {
+	if err := c.check(ctx, client, opts...); err != nil {
+		return c.fail(err)
+	}
+
+	out, err := client.Backend().Run(ctx, c.buildArgs(opts...), nil)
 
 	if err != nil {
 		if c.isTwoArgs() {
-			return fmt.Errorf("ipset: can't %s %s: %s", c.action, c.name, out)
+			return c.fail(failure(ctx, c.action, c.name, "", out))
 		}
 
-		return fmt.Errorf("ipset: can't %s %s %s: %s", c.action, c.name, c.entry, out)
+		return c.fail(failure(ctx, c.action, c.name, c.entry, out))
 	}
 
 	if c.needResolve() {
@@ -178,6 +243,51 @@ func (c *cmd) exec(opts ...Option) error {
 	return nil
 }
 
+// stream is like exec but the output is fed to fn while it's
+// produced, see Client.stream. The error of fn is returned as is.
+func (c *cmd) stream(ctx context.Context, client *Client, fn func(io.Reader) error, opts ...Option) error {
+	if err := c.check(ctx, client, opts...); err != nil {
+		return c.fail(err)
+	}
+
+	var ferr error
+	out, err := client.stream(ctx, c.buildArgs(opts...), func(r io.Reader) error {
+		ferr = fn(r)
+		return ferr
+	})
+	switch {
+	case err == nil:
+		return nil
+	case ferr != nil && err == ferr:
+		return ferr
+	}
+	return c.fail(failure(ctx, c.action, c.name, "", out))
+}
+
+// check validates the options if they're strict and checks that
+// the detected version supports them.
+func (c *cmd) check(ctx context.Context, client *Client, opts ...Option) error {
+	o := acquireOptions().apply(opts...)
+	defer releaseOptions(o)
+	if o.strict {
+		if _, err := c.validate(o); err != nil {
+			return err
+		}
+	}
+	var setType SetType
+	if c.action == _create {
+		setType = c.setType
+	}
+	return client.requireVersion(ctx, setType, c.features(o)...)
+}
+
+func (c *cmd) fail(err error) error {
+	if c.isTwoArgs() {
+		return fmt.Errorf("ipset: can't %s %s: %w", c.action, c.name, err)
+	}
+	return fmt.Errorf("ipset: can't %s %s %s: %w", c.action, c.name, c.entry, err)
+}
+
 func (c *cmd) isTwoArgs() bool {
 	return c.action == _list || c.action == _save ||
 		c.action == _destroy || c.action == _flush
@@ -203,45 +313,68 @@ func (c *cmd) onlyAdd() bool {
 	return c.action == _add
 }
 
+func (c *cmd) onlyList() bool {
+	return c.action == _list
+}
+
+func (c *cmd) onlySave() bool {
+	return c.action == _save
+}
+
+func (c *cmd) needOutput() bool {
+	return c.action == _list || c.action == _save
+}
+
+func (c *cmd) needSorted() bool {
+	return c.action == _list || c.action == _save
+}
+
+func (c *cmd) needWildcard() bool {
+	return c.action == _add && typeInfo(c.setType).SupportsAdd(_wildcard)
+}
+
 func (c *cmd) onlyCreate() bool {
 	return c.action == _create
 }
 
 func (c *cmd) needNomatch() bool {
-	return c.action == _add &&
-		(c.setType == HashNet || c.setType == HashNetNet ||
-			c.setType == HashNetPort || c.setType == HashIpPortNet ||
-			c.setType == HashNetPortNet || c.setType == HashNetIface)
+	return c.action == _add && typeInfo(c.setType).SupportsAdd(_nomatch)
+}
+
+func (c *cmd) needForceadd() bool {
+	return c.createOption(_forceadd)
 }
 
 func (c *cmd) needFamily() bool {
-	return c.action == _create && c.setType != HashMac && strings.HasPrefix(string(c.setType), "hash")
+	return c.createOption(_family)
 }
 
 func (c *cmd) needHash() bool {
-	return c.action == _create && strings.HasPrefix(string(c.setType), "hash")
+	return c.createOption(_hashsize)
 }
 
 func (c *cmd) needNetmask() bool {
-	return c.action == _create &&
-		(c.setType == BitmapIp || c.setType == HashIp)
+	return c.createOption(_netmask)
 }
 
 func (c *cmd) needMarkmask() bool {
-	return c.action == _create && c.setType == HashIpMark
+	return c.createOption(_markmask)
 }
 
 func (c *cmd) needListSize() bool {
-	return c.action == _create && c.setType == ListSet
+	return c.createOption(_size)
 }
 
 func (c *cmd) needIpRange() bool {
-	return c.action == _create &&
-		(c.setType == BitmapIp || c.setType == BitmapIpMac)
+	return c.createOption(_range) && typeInfo(c.setType).Dimensions[0] != KindPort
 }
 
 func (c *cmd) needPortRange() bool {
-	return c.action == _create && c.setType == BitmapPort
+	return c.createOption(_range) && typeInfo(c.setType).Dimensions[0] == KindPort
+}
+
+func (c *cmd) createOption(option string) bool {
+	return c.action == _create && typeInfo(c.setType).SupportsCreate(option)
 }
 
 var cmdPool = sync.Pool{
@@ -262,6 +395,8 @@ func getCmd(action, name string, setType SetType, entry ...string) *cmd {
 }
 
 func putCmd(c *cmd) {
+	c.entry = ""
+	c.header = nil
 	c.out = nil
 	cmdPool.Put(c)
 }
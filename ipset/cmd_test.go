@@ -603,6 +603,37 @@ func Test_Options_ListSize(t *testing.T) {
 	}
 }
 
+func Test_Options_New(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		action  string
+		setType SetType
+		option  Option
+		args    []string
+	}{
+		{_create, HashIp, Bucketsize(4), []string{_bucketsize, "4"}},
+		{_create, HashIp, Initval(0xff), []string{_initval, "0x000000ff"}},
+		{_create, BitmapIp, Bucketsize(4), nil},
+		{_add, HashNetIface, Wildcard(true), []string{_wildcard}},
+		{_add, HashNet, Wildcard(true), nil},
+		{_list, HashIp, Sorted(true), []string{_sorted}},
+		{_save, HashIp, Sorted(true), []string{_sorted}},
+		{_add, HashIp, Sorted(true), nil},
+		{_save, HashIp, Output(OutputXML), []string{_output, OutputXML}},
+		{_list, HashIp, Output(OutputXML), []string{_output, OutputXML}},
+		{_add, HashIp, Output(OutputXML), nil},
+		{_save, HashIp, File("foo.save"), []string{_file, "foo.save"}},
+		{_list, HashIp, Terse(true), []string{_terse}},
+		{_save, HashIp, Terse(true), nil},
+	}
+
+	for _, tc := range tt {
+		c := getFakeCmd(tc.action, tc.setType)
+		assert.Equal(t, tc.args, c.appendArgs(nil, tc.option), tc.args)
+	}
+}
+
 func getFakeCmd(action string, setType ...SetType) *cmd {
 	st := HashIp
 	if len(setType) > 0 {
@@ -0,0 +1,188 @@
+package ipset
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Command is a line of save output or restore input, e.g. "create
+// foo hash:ip family inet" or "add foo 1.1.1.1 timeout 60".
+type Command struct {
+	// Action is one of create, add, del, test, flush, destroy,
+	// rename and swap.
+	Action string
+	// Set is the set name, which is empty if flush or destroy
+	// applies to all the sets.
+	Set string
+	// Type is the set type of create. It's also filled for add, del
+	// and test if the set is created earlier in the input.
+	Type SetType
+	// Header holds the create options.
+	Header Header
+	// Entry is the entry of add, del and test.
+	Entry *Entry
+	// Before and After are the positions of entries of list:set.
+	Before string
+	After  string
+	// To is the second set name of rename and swap.
+	To    string
+	Exist bool
+	// Line is the line number in the input, it's 0 if the command is
+	// built by hand.
+	Line int
+}
+
+// String formats the command canonically like ipset save does, the
+// element of an entry is built from its dimensions if it's empty.
+func (c *Command) String() string {
+	words := []string{c.Action}
+	switch c.Action {
+	case _create:
+		words = append(words, c.Set, string(c.Type))
+		if h := formatHeader(c.Type, c.Header.args()); h != "" {
+			words = append(words, h)
+		}
+	case _add, _del, _test:
+		words = append(words, c.Set)
+		if c.Entry != nil {
+			e := *c.Entry
+			if e.Elem == "" && c.Type != "" {
+				e.Elem, _ = entryKey(c.Type, c.Header.Family, &e)
+			}
+			words = append(words, e.String())
+		}
+		if c.Before != "" {
+			words = append(words, _before, c.Before)
+		}
+		if c.After != "" {
+			words = append(words, _after, c.After)
+		}
+	case _rename, _swap:
+		words = append(words, c.Set, c.To)
+	default:
+		if c.Set != "" {
+			words = append(words, c.Set)
+		}
+	}
+	if c.Exist {
+		words = append(words, _exist)
+	}
+	return strings.Join(words, " ")
+}
+
+// CommandReader reads commands from save output or restore input.
+// Empty lines, comments and COMMIT are skipped.
+type CommandReader struct {
+	s    *bufio.Scanner
+	line int
+	// sets are the sets created so far
+	sets map[string]*Command
+}
+
+// NewCommandReader returns a reader of commands from r.
+func NewCommandReader(r io.Reader) *CommandReader {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 4096), 1<<20)
+	return &CommandReader{s: s, sets: make(map[string]*Command)}
+}
+
+// Read returns the next command, or io.EOF at the end of input.
+// Elements of sets created earlier are parsed into entries and
+// written as ipset lists them, e.g. 10.1.2.3/8 of hash:net is
+// 10.0.0.0/8.
+func (r *CommandReader) Read() (*Command, error) {
+	for r.s.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.s.Text())
+		if line == "" || line[0] == '#' || line == "COMMIT" {
+			continue
+		}
+		c, err := r.parse(line)
+		if err != nil {
+			return nil, fmt.Errorf("ipset: can't parse line %d: %s", r.line, err)
+		}
+		return c, nil
+	}
+	if err := r.s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (r *CommandReader) parse(line string) (*Command, error) {
+	words, err := splitLine(line)
+	if err != nil {
+		return nil, err
+	}
+	req, err := parseRequest(words)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Command{Action: req.action, Set: req.name, Exist: req.exist, Line: r.line}
+	switch req.action {
+	case _create:
+		c.Type = SetType(req.arg)
+		c.Header = req.create.header()
+		r.sets[c.Set] = c
+	case _add, _del, _test:
+		var family NetFamily
+		if set, ok := r.sets[c.Set]; ok {
+			c.Type, family = set.Type, set.Header.Family
+		}
+		if c.Entry, err = entryOf(c.Type, family, req.arg, &req.entry); err != nil {
+			return nil, err
+		}
+		if c.Type != "" {
+			if c.Entry.Elem, err = entryKey(c.Type, family, c.Entry); err != nil {
+				return nil, err
+			}
+		}
+		c.Before, c.After = req.entry.before, req.entry.after
+	case _rename, _swap:
+		c.To = req.arg
+		if set, ok := r.sets[c.Set]; ok && req.action == _rename {
+			delete(r.sets, c.Set)
+			r.sets[c.To] = set
+		}
+	case _flush, _destroy:
+		if req.action == _destroy {
+			delete(r.sets, c.Set)
+		}
+	default:
+		return nil, fmt.Errorf("%s command is not supported in restore mode", req.action)
+	}
+	return c, nil
+}
+
+// ParseCommands reads all the commands from r.
+func ParseCommands(r io.Reader) ([]*Command, error) {
+	var (
+		cr   = NewCommandReader(r)
+		cmds []*Command
+	)
+	for {
+		c, err := cr.Read()
+		if err == io.EOF {
+			return cmds, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, c)
+	}
+}
+
+// WriteCommands writes the commands line by line, which can be read
+// by restore command.
+func WriteCommands(w io.Writer, cmds []*Command) error {
+	bw := bufio.NewWriter(w)
+	for _, c := range cmds {
+		if _, err := bw.WriteString(c.String() + "\n"); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
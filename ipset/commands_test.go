@@ -0,0 +1,126 @@
+package ipset
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseCommands(t *testing.T) {
+	t.Parallel()
+
+	in := `# generated
+create foo hash:net family inet hashsize 1024 maxelem 65536 bucketsize 12 initval 0x5f263b39 timeout 3600 comment
+add foo 10.1.2.3/8 timeout 60 comment "a b"
+add foo 192.168.0.0/16 timeout 0 -exist
+
+create l list:set size 8
+add l foo before bar
+del bar 1.1.1.1
+rename foo baz
+add baz 172.16.1.1/12 timeout 10
+swap baz l
+flush
+destroy l
+COMMIT
+`
+	cmds, err := ParseCommands(strings.NewReader(in))
+	require.Nil(t, err)
+	require.Len(t, cmds, 11)
+
+	c := cmds[0]
+	assert.Equal(t, _create, c.Action)
+	assert.Equal(t, "foo", c.Set)
+	assert.Equal(t, HashNet, c.Type)
+	assert.Equal(t, uint8(12), c.Header.Bucketsize)
+	assert.Equal(t, uint32(0x5f263b39), c.Header.Initval)
+	assert.Equal(t, time.Hour, c.Header.Timeout)
+	assert.Equal(t, 2, c.Line)
+
+	c = cmds[1]
+	assert.Equal(t, HashNet, c.Type)
+	assert.Equal(t, "10.0.0.0/8", c.Entry.Elem)
+	assert.Equal(t, uint8(8), c.Entry.CIDR)
+	assert.Equal(t, time.Minute, c.Entry.Timeout)
+	assert.Equal(t, "a b", c.Entry.Comment)
+	assert.True(t, cmds[2].Exist)
+
+	assert.Equal(t, "bar", cmds[4].Before)
+	assert.Equal(t, SetType(""), cmds[5].Type)
+	assert.Equal(t, "1.1.1.1", cmds[5].Entry.Elem)
+	assert.Equal(t, "baz", cmds[6].To)
+	// the renamed set keeps its type
+	assert.Equal(t, "172.16.0.0/12", cmds[7].Entry.Elem)
+	assert.Equal(t, "", cmds[9].Set)
+
+	var b bytes.Buffer
+	require.Nil(t, WriteCommands(&b, cmds))
+	assert.Equal(t, `create foo hash:net family inet hashsize 1024 maxelem 65536 bucketsize 12 initval 0x5f263b39 timeout 3600 comment
+add foo 10.0.0.0/8 timeout 60 comment "a b"
+add foo 192.168.0.0/16 timeout 0 -exist
+create l list:set size 8
+add l foo before bar
+del bar 1.1.1.1
+rename foo baz
+add baz 172.16.0.0/12 timeout 10
+swap baz l
+flush
+destroy l
+`, b.String())
+
+	// the output is read back as is
+	again, err := ParseCommands(&b)
+	require.Nil(t, err)
+	assert.Equal(t, len(cmds), len(again))
+}
+
+func Test_ParseCommands_Error(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		in  string
+		err string
+	}{
+		{"create foo hash:ip\nadd foo 1.1.1.1 timeout x\n", "ipset: can't parse line 2: Syntax error: invalid value x of timeout"},
+		{"create foo hash:ip foo 1\n", "ipset: can't parse line 1: Unknown argument: `foo'"},
+		{"create foo hash:ip\nadd foo 1.1.1.1,80\n", "ipset: can't parse line 2: Syntax error: hash:ip requires 1 dimension(s) in element 1.1.1.1,80"},
+		{"list foo\n", "ipset: can't parse line 1: list command is not supported in restore mode"},
+		{"add foo \"1.1.1.1\n", "ipset: can't parse line 1: Syntax error: missing close quote in line \"add foo \\\"1.1.1.1\""},
+	} {
+		_, err := ParseCommands(strings.NewReader(tc.in))
+		require.Error(t, err, tc.in)
+		assert.Equal(t, tc.err, err.Error())
+	}
+
+	r := NewCommandReader(strings.NewReader("add foo 1.1.1.1\n"))
+	_, err := r.Read()
+	require.Nil(t, err)
+	_, err = r.Read()
+	assert.Equal(t, io.EOF, err)
+}
+
+func Test_Command_String(t *testing.T) {
+	t.Parallel()
+
+	c := &Command{
+		Action: _add,
+		Set:    "foo",
+		Type:   HashIpPort,
+		Entry:  &Entry{IP: []byte{1, 1, 1, 1}, Proto: "udp", Port: 53, Comment: "dns"},
+	}
+	assert.Equal(t, `add foo 1.1.1.1,udp:53 comment "dns"`, c.String())
+
+	c = &Command{Action: _create, Set: "foo", Type: BitmapPort, Header: Header{Range: "0-1024"}, Exist: true}
+	assert.Equal(t, "create foo bitmap:port range 0-1024 -exist", c.String())
+
+	// the commands are restored by the emulator
+	e := NewEmulator()
+	_, err := e.Run(context.Background(), []string{_restore}, strings.NewReader(c.String()+"\nadd foo 80\n"))
+	require.Nil(t, err)
+}
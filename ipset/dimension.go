@@ -0,0 +1,228 @@
+package ipset
+
+import (
+	"fmt"
+	"net"
+)
+
+// Dimension is a typed part of an entry, which is built by ElemIP,
+// ElemNet, ElemPort and so on and joined by BuildEntry.
+type Dimension struct {
+	kind    Kind
+	ip      net.IP
+	ipTo    net.IP
+	cidr    uint8
+	proto   string
+	port    uint16
+	portTo  uint16
+	icmp    bool
+	mac     net.HardwareAddr
+	iface   string
+	physdev bool
+	mark    uint32
+	name    string
+}
+
+// ElemIP is an ip address of an ip or net dimension.
+func ElemIP(ip net.IP) Dimension {
+	return Dimension{kind: KindIp, ip: ip}
+}
+
+// ElemIPRange is an inclusive ip range of an ip or net dimension.
+func ElemIPRange(from, to net.IP) Dimension {
+	return Dimension{kind: KindIp, ip: from, ipTo: to}
+}
+
+// ElemNet is a network of an ip or net dimension.
+func ElemNet(n *net.IPNet) Dimension {
+	d := Dimension{kind: KindNet}
+	if n != nil {
+		ones, _ := n.Mask.Size()
+		d.ip, d.cidr = n.IP.Mask(n.Mask), uint8(ones)
+	}
+	return d
+}
+
+// ElemPort is a port of the protocol, which is tcp if it's empty.
+// The protocol must be empty for bitmap:port.
+func ElemPort(proto string, port uint16) Dimension {
+	return Dimension{kind: KindPort, proto: proto, port: port}
+}
+
+// ElemPortRange is an inclusive port range of the protocol.
+func ElemPortRange(proto string, from, to uint16) Dimension {
+	return Dimension{kind: KindPort, proto: proto, port: from, portTo: to}
+}
+
+// ElemICMP is an icmp type and code of a port dimension, icmpv6 is
+// used for sets of family inet6.
+func ElemICMP(typ, code uint8) Dimension {
+	return Dimension{kind: KindPort, icmp: true, port: uint16(typ)<<8 | uint16(code)}
+}
+
+// ElemMAC is a mac address.
+func ElemMAC(mac net.HardwareAddr) Dimension {
+	return Dimension{kind: KindMac, mac: mac}
+}
+
+// ElemIface is an interface name.
+func ElemIface(name string) Dimension {
+	return Dimension{kind: KindIface, iface: name}
+}
+
+// ElemPhysdev is a bridge port name, i.e. physdev:name.
+func ElemPhysdev(name string) Dimension {
+	return Dimension{kind: KindIface, iface: name, physdev: true}
+}
+
+// ElemMark is a packet mark.
+func ElemMark(mark uint32) Dimension {
+	return Dimension{kind: KindMark, mark: mark}
+}
+
+// ElemSet is the name of a member set of list:set.
+func ElemSet(name string) Dimension {
+	return Dimension{kind: KindSet, name: name}
+}
+
+// BuildEntry joins the dimensions into an entry of the set type, e.g.
+// 10.0.0.0/8,tcp:80,192.168.1.0/24 of hash:net,port,net. An error
+// wrapping ErrInvalidEntry is returned if the number, kinds or
+// families of the dimensions don't fit the set type, family is inet
+// if it's empty.
+func BuildEntry(setType SetType, family NetFamily, dims ...Dimension) (string, error) {
+	el, err := buildElement(setType, family, dims)
+	if err != nil {
+		return "", fmt.Errorf("ipset: can't build entry of %s: %w", setType, err)
+	}
+	return el.format(setType), nil
+}
+
+func buildElement(setType SetType, family NetFamily, dims []Dimension) (*element, error) {
+	info := typeInfo(setType)
+	if family == "" {
+		family = Inet
+	}
+	if family == Inet6 && !info.IPv6 {
+		return nil, invalidEntry("%s doesn't support %s", setType, family)
+	}
+
+	kinds := info.Dimensions
+	if len(dims) != len(kinds) &&
+		// bitmap:ip,mac allows to leave out the mac part
+		!(setType == BitmapIpMac && len(dims) == 1) {
+		return nil, invalidEntry("%s requires %d dimension(s), got %d", setType, len(kinds), len(dims))
+	}
+
+	e := &element{}
+	ips := 0
+	for i, d := range dims {
+		want := kinds[i]
+		if want == KindNet {
+			want = KindIp
+		}
+		got := d.kind
+		if got == KindNet {
+			got = KindIp
+		}
+		if got != want {
+			return nil, invalidEntry("dimension %d of %s must be %s, got %s", i+1, setType, kinds[i], d.kind)
+		}
+
+		switch d.kind {
+		case KindIp, KindNet:
+			ip, err := familyIP(d.ip, family)
+			if err != nil {
+				return nil, err
+			}
+			var to net.IP
+			if d.ipTo != nil {
+				if to, err = familyIP(d.ipTo, family); err != nil {
+					return nil, err
+				}
+			}
+			if ips == 0 {
+				e.ip, e.ipTo, e.cidr = ip, to, d.cidr
+			} else {
+				e.ip2, e.ip2To, e.cidr2 = ip, to, d.cidr
+			}
+			ips++
+		case KindPort:
+			if err := d.buildPort(e, setType, family); err != nil {
+				return nil, err
+			}
+		case KindMac:
+			if len(d.mac) != 6 {
+				return nil, invalidEntry("invalid mac address %s", d.mac)
+			}
+			e.mac = d.mac
+		case KindIface:
+			if d.iface == "" || len(d.iface) >= 16 {
+				return nil, invalidEntry("invalid interface name %q", d.iface)
+			}
+			e.iface, e.physdev = d.iface, d.physdev
+		case KindMark:
+			e.mark = d.mark
+		case KindSet:
+			if d.name == "" || len(d.name) > maxNameLen {
+				return nil, invalidEntry("invalid set name %q", d.name)
+			}
+			e.name = d.name
+		}
+	}
+	return e, nil
+}
+
+func (d *Dimension) buildPort(e *element, setType SetType, family NetFamily) error {
+	if d.portTo != 0 && d.portTo < d.port {
+		return invalidEntry("invalid port range %d-%d", d.port, d.portTo)
+	}
+	e.port, e.portTo = d.port, d.portTo
+	if setType == BitmapPort {
+		if d.proto != "" || d.icmp {
+			return invalidEntry("%s doesn't support protocols", setType)
+		}
+		return nil
+	}
+
+	switch {
+	case d.icmp && family == Inet6:
+		e.proto = protoICMPv6
+	case d.icmp:
+		e.proto = protoICMP
+	case d.proto == "":
+		e.proto = protoTCP
+	default:
+		proto, err := parseProto(d.proto)
+		if err != nil {
+			return invalidEntry("%s", err)
+		}
+		if proto == protoICMP || proto == protoICMPv6 {
+			return invalidEntry("%s ports must be built by ElemICMP", d.proto)
+		}
+		e.proto = proto
+	}
+	return nil
+}
+
+// familyIP returns the address of the family in its shortest form.
+func familyIP(ip net.IP, family NetFamily) (net.IP, error) {
+	if ip == nil {
+		return nil, invalidEntry("missing ip address")
+	}
+	v4 := ip.To4()
+	if family == Inet6 {
+		if v4 != nil {
+			return nil, invalidEntry("%s is not an address of %s", ip, family)
+		}
+		return ip.To16(), nil
+	}
+	if v4 == nil {
+		return nil, invalidEntry("%s is not an address of %s", ip, family)
+	}
+	return v4, nil
+}
+
+func invalidEntry(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidEntry, fmt.Sprintf(format, a...))
+}
@@ -0,0 +1,85 @@
+package ipset
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_BuildEntry(t *testing.T) {
+	t.Parallel()
+
+	_, n8, _ := net.ParseCIDR("10.1.0.0/8")
+	_, n24, _ := net.ParseCIDR("192.168.1.0/24")
+	_, n64, _ := net.ParseCIDR("2001:db8::/64")
+	mac, _ := net.ParseMAC("00:11:22:33:44:55")
+	ip := net.ParseIP("1.1.1.1")
+
+	tt := []struct {
+		setType SetType
+		family  NetFamily
+		dims    []Dimension
+		entry   string
+	}{
+		{HashIp, "", []Dimension{ElemIP(ip)}, "1.1.1.1"},
+		{HashIp, Inet6, []Dimension{ElemIP(net.ParseIP("2001:db8::1"))}, "2001:db8::1"},
+		{BitmapIp, "", []Dimension{ElemIPRange(ip, net.ParseIP("1.1.1.9"))}, "1.1.1.1-1.1.1.9"},
+		{HashNet, Inet6, []Dimension{ElemNet(n64)}, "2001:db8::/64"},
+		{HashNetPortNet, "", []Dimension{ElemNet(n8), ElemPort("", 80), ElemNet(n24)}, "10.0.0.0/8,tcp:80,192.168.1.0/24"},
+		{HashIpPort, "", []Dimension{ElemIP(ip), ElemPortRange("udp", 53, 54)}, "1.1.1.1,udp:53-54"},
+		{HashIpPort, "", []Dimension{ElemIP(ip), ElemICMP(8, 0)}, "1.1.1.1,icmp:8/0"},
+		{HashIpPort, Inet6, []Dimension{ElemIP(net.ParseIP("::1")), ElemICMP(128, 0)}, "::1,icmpv6:128/0"},
+		{BitmapPort, "", []Dimension{ElemPort("", 22)}, "22"},
+		{BitmapIpMac, "", []Dimension{ElemIP(ip)}, "1.1.1.1"},
+		{HashIpMac, "", []Dimension{ElemIP(ip), ElemMAC(mac)}, "1.1.1.1,00:11:22:33:44:55"},
+		{HashMac, "", []Dimension{ElemMAC(mac)}, "00:11:22:33:44:55"},
+		{HashIpMark, "", []Dimension{ElemIP(ip), ElemMark(255)}, "1.1.1.1,0x000000ff"},
+		{HashNetIface, "", []Dimension{ElemNet(n24), ElemIface("eth0")}, "192.168.1.0/24,eth0"},
+		{HashNetIface, "", []Dimension{ElemIP(ip), ElemPhysdev("eth1")}, "1.1.1.1,physdev:eth1"},
+		{ListSet, "", []Dimension{ElemSet("foo")}, "foo"},
+	}
+
+	for _, tc := range tt {
+		entry, err := BuildEntry(tc.setType, tc.family, tc.dims...)
+		require.Nil(t, err, tc.entry)
+		assert.Equal(t, tc.entry, entry)
+	}
+}
+
+func Test_BuildEntry_Error(t *testing.T) {
+	t.Parallel()
+
+	_, n64, _ := net.ParseCIDR("2001:db8::/64")
+	ip := net.ParseIP("1.1.1.1")
+
+	tt := []struct {
+		setType SetType
+		family  NetFamily
+		dims    []Dimension
+		err     string
+	}{
+		{HashIpPort, "", []Dimension{ElemIP(ip)}, "hash:ip,port requires 2 dimension(s), got 1"},
+		{HashIp, "", []Dimension{ElemPort("", 80)}, "dimension 1 of hash:ip must be ip, got port"},
+		{HashNet, "", []Dimension{ElemNet(n64)}, "2001:db8:: is not an address of inet"},
+		{HashIp, Inet6, []Dimension{ElemIP(ip)}, "1.1.1.1 is not an address of inet6"},
+		{BitmapIp, Inet6, []Dimension{ElemIP(ip)}, "bitmap:ip doesn't support inet6"},
+		{HashIp, "", []Dimension{ElemIP(nil)}, "missing ip address"},
+		{BitmapPort, "", []Dimension{ElemPort("udp", 53)}, "bitmap:port doesn't support protocols"},
+		{HashIpPort, "", []Dimension{ElemIP(ip), ElemPortRange("", 80, 22)}, "invalid port range 80-22"},
+		{HashIpPort, "", []Dimension{ElemIP(ip), ElemPort("foo", 80)}, "unknown protocol foo"},
+		{HashIpPort, "", []Dimension{ElemIP(ip), ElemPort("icmp", 80)}, "icmp ports must be built by ElemICMP"},
+		{HashMac, "", []Dimension{ElemMAC(net.HardwareAddr{1})}, "invalid mac address 01"},
+		{HashNetIface, "", []Dimension{ElemIP(ip), ElemIface("")}, `invalid interface name ""`},
+		{ListSet, "", []Dimension{ElemSet("")}, `invalid set name ""`},
+	}
+
+	for _, tc := range tt {
+		_, err := BuildEntry(tc.setType, tc.family, tc.dims...)
+		require.Error(t, err, tc.err)
+		assert.True(t, errors.Is(err, ErrInvalidEntry))
+		assert.Equal(t, "ipset: can't build entry of "+string(tc.setType)+": invalid entry: "+tc.err, err.Error())
+	}
+}
@@ -0,0 +1,337 @@
+package ipset
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Data types of set dimensions
+const (
+	_ip    = "ip"
+	_net   = "net"
+	_mac   = "mac"
+	_port  = "port"
+	_iface = "iface"
+	_mark  = "mark"
+	_set   = "set"
+)
+
+// dimensions returns the data types stored in a set of the type,
+// e.g. [net port net] for hash:net,port,net.
+func dimensions(setType SetType) []string {
+	t := string(setType)
+	if i := strings.IndexByte(t, ':'); i != -1 {
+		t = t[i+1:]
+	}
+	return strings.Split(t, ",")
+}
+
+// method returns the storage method of the set type, which is
+// one of bitmap, hash and list.
+func method(setType SetType) string {
+	t := string(setType)
+	if i := strings.IndexByte(t, ':'); i != -1 {
+		return t[:i]
+	}
+	return t
+}
+
+// element is a set entry split into its typed dimensions. The
+// first ip or net dimension is kept in ip, ipTo and cidr, the
+// second one in ip2, ip2To and cidr2.
+type element struct {
+	ip      net.IP
+	ipTo    net.IP
+	cidr    uint8
+	port    uint16
+	portTo  uint16
+	proto   uint8
+	ip2     net.IP
+	ip2To   net.IP
+	cidr2   uint8
+	mac     net.HardwareAddr
+	iface   string
+	physdev bool
+	mark    uint32
+	name    string
+}
+
+// parseElement parses the entry of a set with specific type and
+// family into an element.
+func parseElement(setType SetType, family NetFamily, entry string) (*element, error) {
+	dims := dimensions(setType)
+	parts := strings.Split(entry, ",")
+	if len(parts) != len(dims) {
+		// bitmap:ip,mac allows to leave out the mac part
+		if !(setType == BitmapIpMac && len(parts) == 1) {
+			return nil, fmt.Errorf("Syntax error: %s requires %d dimension(s) in element %s",
+				setType, len(dims), entry)
+		}
+	}
+
+	e := &element{}
+	ips := 0
+	for i, part := range parts {
+		var err error
+		switch dims[i] {
+		case _ip, _net:
+			var (
+				ip, to net.IP
+				cidr   uint8
+			)
+			if ip, to, cidr, err = parseIPAddr(part, family); err != nil {
+				break
+			}
+			if ips == 0 {
+				e.ip, e.ipTo, e.cidr = ip, to, cidr
+			} else {
+				e.ip2, e.ip2To, e.cidr2 = ip, to, cidr
+			}
+			ips++
+		case _port:
+			e.proto, e.port, e.portTo, err = parsePort(part)
+		case _mac:
+			e.mac, err = net.ParseMAC(part)
+		case _iface:
+			e.iface = part
+			if strings.HasPrefix(part, "physdev:") {
+				e.physdev = true
+				e.iface = part[len("physdev:"):]
+			}
+			if e.iface == "" || len(e.iface) >= 16 {
+				err = fmt.Errorf("Syntax error: invalid interface name %s", part)
+			}
+		case _mark:
+			var mark uint64
+			if mark, err = strconv.ParseUint(part, 0, 32); err == nil {
+				e.mark = uint32(mark)
+			}
+		case _set:
+			e.name = part
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Syntax error: cannot parse %s as %s: %s", part, dims[i], err)
+		}
+	}
+	return e, nil
+}
+
+// format formats the element like the ipset utility does.
+func (e *element) format(setType SetType) string {
+	var (
+		b   strings.Builder
+		ips int
+	)
+	for i, dim := range dimensions(setType) {
+		if i > 0 {
+			if dim == _mac && e.mac == nil {
+				break
+			}
+			b.WriteByte(',')
+		}
+		switch dim {
+		case _ip, _net:
+			if ips == 0 {
+				b.WriteString(formatIPAddr(e.ip, e.ipTo, e.cidr))
+			} else {
+				b.WriteString(formatIPAddr(e.ip2, e.ip2To, e.cidr2))
+			}
+			ips++
+		case _port:
+			b.WriteString(formatPort(setType, e.proto, e.port, e.portTo))
+		case _mac:
+			b.WriteString(strings.ToUpper(e.mac.String()))
+		case _iface:
+			if e.physdev {
+				b.WriteString("physdev:")
+			}
+			b.WriteString(e.iface)
+		case _mark:
+			b.WriteString(fmt.Sprintf("0x%08x", e.mark))
+		case _set:
+			b.WriteString(e.name)
+		}
+	}
+	return b.String()
+}
+
+// parseIPAddr parses ip, fromip-toip or ip/cidr. Host names are
+// resolved and the first address of the family is used.
+func parseIPAddr(s string, family NetFamily) (ip, to net.IP, cidr uint8, err error) {
+	if i := strings.IndexByte(s, '/'); i != -1 {
+		var n uint64
+		if n, err = strconv.ParseUint(s[i+1:], 10, 8); err != nil {
+			return
+		}
+		cidr = uint8(n)
+		s = s[:i]
+	} else if i = strings.IndexByte(s, '-'); i != -1 && family != Inet6 &&
+		net.ParseIP(s[:i]) != nil {
+		if to, err = lookupIP(s[i+1:], family); err != nil {
+			return
+		}
+		s = s[:i]
+	}
+
+	if ip, err = lookupIP(s, family); err != nil {
+		return
+	}
+	if int(cidr) > len(ip)*8 {
+		err = fmt.Errorf("invalid cidr %d", cidr)
+	}
+	return
+}
+
+// lookupIP parses s as an ip address of the family or resolves
+// it as a host name.
+func lookupIP(s string, family NetFamily) (net.IP, error) {
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	ips := []net.IP{net.ParseIP(s)}
+	if ips[0] == nil {
+		var err error
+		if ips, err = net.LookupIP(s); err != nil {
+			return nil, err
+		}
+	}
+	for _, ip := range ips {
+		if v4 := ip.To4(); v4 != nil && family != Inet6 {
+			return v4, nil
+		}
+		if ip.To4() == nil && family == Inet6 {
+			return ip.To16(), nil
+		}
+	}
+	if family == Inet6 {
+		return nil, fmt.Errorf("an IPv6 address is expected, but %s received", s)
+	}
+	return nil, fmt.Errorf("an IPv4 address is expected, but %s received", s)
+}
+
+// formatIPAddr formats the ip address, range or network, the host
+// prefix is left out.
+func formatIPAddr(ip, to net.IP, cidr uint8) string {
+	if ip == nil {
+		return ""
+	}
+	if to != nil {
+		return ip.String() + "-" + to.String()
+	}
+	if cidr != 0 && int(cidr) < len(ip)*8 {
+		return ip.String() + "/" + strconv.Itoa(int(cidr))
+	}
+	return ip.String()
+}
+
+// Protocol numbers
+const (
+	protoICMP    = 1
+	protoTCP     = 6
+	protoUDP     = 17
+	protoICMPv6  = 58
+	protoSCTP    = 132
+	protoUDPLite = 136
+)
+
+var protoNames = map[uint8]string{
+	protoICMP:    "icmp",
+	protoTCP:     "tcp",
+	protoUDP:     "udp",
+	protoICMPv6:  "icmpv6",
+	protoSCTP:    "sctp",
+	protoUDPLite: "udplite",
+}
+
+// parsePort parses [proto:]port[-port], where port is type/code
+// for icmp and icmpv6.
+func parsePort(s string) (proto uint8, port, to uint16, err error) {
+	proto = protoTCP
+	if i := strings.IndexByte(s, ':'); i != -1 {
+		if proto, err = parseProto(s[:i]); err != nil {
+			return
+		}
+		s = s[i+1:]
+	}
+
+	if proto == protoICMP || proto == protoICMPv6 {
+		i := strings.IndexByte(s, '/')
+		if i == -1 {
+			err = fmt.Errorf("invalid icmp type/code %s", s)
+			return
+		}
+		var typ, code uint64
+		if typ, err = strconv.ParseUint(s[:i], 10, 8); err != nil {
+			return
+		}
+		if code, err = strconv.ParseUint(s[i+1:], 10, 8); err != nil {
+			return
+		}
+		port = uint16(typ<<8 | code)
+		return
+	}
+
+	if i := strings.IndexByte(s, '-'); i != -1 {
+		if to, err = lookupPort(s[i+1:], proto); err != nil {
+			return
+		}
+		s = s[:i]
+	}
+	port, err = lookupPort(s, proto)
+	return
+}
+
+func parseProto(s string) (uint8, error) {
+	for n, name := range protoNames {
+		if name == s {
+			return n, nil
+		}
+	}
+	if s == "ipv6-icmp" {
+		return protoICMPv6, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("unknown protocol %s", s)
+	}
+	return uint8(n), nil
+}
+
+// lookupPort parses s as a port number or resolves it as a service
+// name.
+func lookupPort(s string, proto uint8) (uint16, error) {
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	if n, err := strconv.ParseUint(s, 10, 16); err == nil {
+		return uint16(n), nil
+	}
+	network := protoNames[proto]
+	if network != "udp" {
+		network = "tcp"
+	}
+	n, err := net.LookupPort(network, s)
+	if err != nil {
+		return 0, err
+	}
+	return uint16(n), nil
+}
+
+func formatPort(setType SetType, proto uint8, port, to uint16) string {
+	if setType == BitmapPort {
+		if to != 0 && to != port {
+			return i2str(uint64(port)) + "-" + i2str(uint64(to))
+		}
+		return i2str(uint64(port))
+	}
+
+	name, ok := protoNames[proto]
+	if !ok {
+		name = i2str(uint64(proto))
+	}
+	if proto == protoICMP || proto == protoICMPv6 {
+		return name + ":" + i2str(uint64(port>>8)) + "/" + i2str(uint64(port&0xff))
+	}
+	if to != 0 && to != port {
+		return name + ":" + i2str(uint64(port)) + "-" + i2str(uint64(to))
+	}
+	return name + ":" + i2str(uint64(port))
+}
@@ -0,0 +1,74 @@
+package ipset
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Element_Format(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		setType SetType
+		family  NetFamily
+		entry   string
+		out     string
+	}{
+		{BitmapIp, Inet, "192.168.1.1", "192.168.1.1"},
+		{BitmapIp, Inet, "192.168.1.1-192.168.1.9", "192.168.1.1-192.168.1.9"},
+		{BitmapIpMac, Inet, "192.168.1.1", "192.168.1.1"},
+		{BitmapIpMac, Inet, "192.168.1.1,12:34:56:78:9a:bc", "192.168.1.1,12:34:56:78:9A:BC"},
+		{BitmapPort, Inet, "80-90", "80-90"},
+		{HashIp, Inet, "1.1.1.1/32", "1.1.1.1"},
+		{HashIp, Inet6, "::1", "::1"},
+		{HashMac, "", "01:02:03:04:05:06", "01:02:03:04:05:06"},
+		{HashNet, Inet, "10.0.0.0/8", "10.0.0.0/8"},
+		{HashNet, Inet6, "2001:db8::/32", "2001:db8::/32"},
+		{HashNetNet, Inet, "10.0.0.0/8,192.168.0.1", "10.0.0.0/8,192.168.0.1"},
+		{HashIpPort, Inet, "1.1.1.1,80", "1.1.1.1,tcp:80"},
+		{HashIpPort, Inet, "1.1.1.1,udp:53", "1.1.1.1,udp:53"},
+		{HashIpPort, Inet, "1.1.1.1,icmp:8/0", "1.1.1.1,icmp:8/0"},
+		{HashIpPort, Inet, "1.1.1.1,47:0", "1.1.1.1,47:0"},
+		{HashNetPort, Inet, "10.0.0.0/8,tcp:80-90", "10.0.0.0/8,tcp:80-90"},
+		{HashIpPortIp, Inet, "1.1.1.1,80,2.2.2.2", "1.1.1.1,tcp:80,2.2.2.2"},
+		{HashIpPortNet, Inet, "1.1.1.1,80,10.0.0.0/8", "1.1.1.1,tcp:80,10.0.0.0/8"},
+		{HashIpMark, Inet, "1.1.1.1,0x10", "1.1.1.1,0x00000010"},
+		{HashNetPortNet, Inet, "10.0.0.0/8,sctp:1,2.2.2.0/24", "10.0.0.0/8,sctp:1,2.2.2.0/24"},
+		{HashNetIface, Inet, "10.0.0.0/8,eth0", "10.0.0.0/8,eth0"},
+		{HashNetIface, Inet, "10.0.0.0/8,physdev:eth0", "10.0.0.0/8,physdev:eth0"},
+		{ListSet, "", "foo", "foo"},
+	}
+
+	for _, tc := range tt {
+		e, err := parseElement(tc.setType, tc.family, tc.entry)
+		require.Nil(t, err, tc.entry)
+		assert.Equal(t, tc.out, e.format(tc.setType))
+	}
+}
+
+func Test_Element_Invalid(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		setType SetType
+		family  NetFamily
+		entry   string
+	}{
+		{HashIp, Inet, "1.1.1.1,80"},
+		{HashIp, Inet, "::1"},
+		{HashIp, Inet6, "1.1.1.1"},
+		{HashNet, Inet, "10.0.0.0/33"},
+		{HashIpPort, Inet, "1.1.1.1,foo:80"},
+		{HashIpPort, Inet, "1.1.1.1,icmp:8"},
+		{HashMac, "", "01:02:03"},
+		{HashIpMark, Inet, "1.1.1.1,mark"},
+		{HashNetIface, Inet, "10.0.0.0/8,physdev:"},
+	}
+
+	for _, tc := range tt {
+		_, err := parseElement(tc.setType, tc.family, tc.entry)
+		assert.Error(t, err, tc.entry)
+	}
+}
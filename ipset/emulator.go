@@ -0,0 +1,1087 @@
+package ipset
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Emulator is an in-memory Backend which behaves like the ipset
+// utility with the kernel behind it, so the code managing sets can
+// be unit tested without root privileges:
+//
+//	e := ipset.NewEmulator()
+//	c := ipset.NewClient(e)
+//	s, _ := c.New("foo", ipset.HashNet, ipset.Timeout(time.Minute))
+//	_ = s.Add("10.0.0.0/8")
+//	ok, _ := s.Test("10.1.1.1") // true
+//	e.Advance(time.Minute)
+//	ok, _ = s.Test("10.1.1.1") // false
+//
+// Ranges, networks, nomatch entries, wildcard interfaces, timeouts,
+// maxelem, forceadd and list:set references work as they do in the kernel, errors
+// carry the same messages as the ipset utility reports. Entries
+// of hash types are listed in insertion order and forceadd evicts
+// the oldest entry, so the results are reproducible.
+type Emulator struct {
+	mu     sync.Mutex
+	sets   []*emuSet
+	clock  func() time.Time
+	offset time.Duration
+}
+
+// NewEmulator creates an empty emulator which uses the system
+// clock.
+func NewEmulator() *Emulator {
+	return &Emulator{clock: time.Now}
+}
+
+// SetClock sets the clock which the timeouts of entries follow.
+func (m *Emulator) SetClock(clock func() time.Time) {
+	m.mu.Lock()
+	m.clock = clock
+	m.mu.Unlock()
+}
+
+// Advance moves the clock of the emulator forward, entries whose
+// timeout is passed disappear.
+func (m *Emulator) Advance(d time.Duration) {
+	m.mu.Lock()
+	m.offset += d
+	m.mu.Unlock()
+}
+
+// Run carries out the ipset command in memory.
+func (m *Emulator) Run(ctx context.Context, args []string, stdin io.Reader) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return []byte(err.Error()), err
+	}
+	req, err := parseRequest(args)
+	if err == nil {
+		var out bytes.Buffer
+		err = withFile(req, stdin, &out, func(stdin io.Reader, out io.Writer) error {
+			return m.do(ctx, req, stdin, out)
+		})
+		if err == nil {
+			return out.Bytes(), nil
+		}
+	}
+	return []byte(err.Error()), err
+}
+
+// emuVersion is the version of the ipset utility which the
+// emulator behaves like.
+const emuVersion = "v7.1"
+
+// emuRevisions holds the revisions of set types in the emulated
+// kernel.
+var emuRevisions = map[SetType]uint8{
+	BitmapIp:       3,
+	BitmapIpMac:    3,
+	BitmapPort:     3,
+	HashIp:         4,
+	HashMac:        0,
+	HashIpMac:      0,
+	HashNet:        6,
+	HashNetNet:     2,
+	HashIpPort:     5,
+	HashNetPort:    7,
+	HashIpPortIp:   5,
+	HashIpPortNet:  7,
+	HashIpMark:     2,
+	HashNetPortNet: 2,
+	HashNetIface:   7,
+	ListSet:        3,
+}
+
+// Defaults of the create options
+const (
+	emuHashSize = 1024
+	emuMaxElem  = 65536
+	emuListSize = 8
+	emuMaxRange = 65536
+)
+
+// emuSet is a set living in the emulator.
+type emuSet struct {
+	name     string
+	setType  SetType
+	revision uint8
+	header   createArgs
+	// first and last bound the range of bitmap types
+	first, last uint32
+	entries     map[string]*emuEntry
+	// keys keeps the order of entries
+	keys []string
+	refs uint32
+}
+
+// emuEntry is an entry of a set with its extensions.
+type emuEntry struct {
+	elem    *element
+	ext     entryArgs
+	expires time.Time
+}
+
+func (m *Emulator) now() time.Time {
+	return m.clock().Add(m.offset)
+}
+
+func (m *Emulator) do(ctx context.Context, req *request, stdin io.Reader, out io.Writer) error {
+	switch req.action {
+	case _version:
+		_, err := fmt.Fprintf(out, "ipset %s (emulator), protocol version: %d\n", emuVersion, ipsetProtocol)
+		return err
+	case _create:
+		return m.create(req)
+	case _destroy:
+		return m.destroy(req)
+	case _flush:
+		return m.flush(req)
+	case _rename:
+		return m.rename(req)
+	case _swap:
+		return m.swap(req)
+	case _add, _del, _test:
+		return m.adt(req, out)
+	case _list, _save:
+		return m.list(req, out)
+	case _restore:
+		return restoreLines(ctx, stdin, req.exist, func(lr *request) error {
+			return m.do(ctx, lr, nil, out)
+		})
+	}
+	return fmt.Errorf("Unknown command: `%s'", req.action)
+}
+
+// find returns the index of the set with the name, or -1.
+func (m *Emulator) find(name string) int {
+	for i, s := range m.sets {
+		if s.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// get returns the set with the name, expired entries are dropped.
+func (m *Emulator) get(cmd uint8, name string) (*emuSet, error) {
+	i := m.find(name)
+	if i == -1 {
+		return nil, kernelError(cmd, "", nlError(errnoENOENT))
+	}
+	s := m.sets[i]
+	m.expire(s)
+	return s, nil
+}
+
+// expire drops the expired entries of the set.
+func (m *Emulator) expire(s *emuSet) {
+	if !s.header.hasTimeout {
+		return
+	}
+	now := m.now()
+	for i := 0; i < len(s.keys); i++ {
+		key := s.keys[i]
+		if e := s.entries[key]; !e.expires.IsZero() && !now.Before(e.expires) {
+			m.remove(s, key)
+			i--
+		}
+	}
+}
+
+func (m *Emulator) create(req *request) error {
+	setType := SetType(req.arg)
+	revision, ok := emuRevisions[setType]
+	if !ok {
+		return fmt.Errorf("Syntax error: typename '%s' is unknown", req.arg)
+	}
+
+	s := &emuSet{
+		name:     req.name,
+		setType:  setType,
+		revision: revision,
+		header:   req.create,
+		entries:  make(map[string]*emuEntry),
+	}
+	if err := s.init(); err != nil {
+		return err
+	}
+
+	if i := m.find(req.name); i != -1 {
+		old := m.sets[i]
+		if req.exist && old.setType == s.setType &&
+			formatHeader(old.setType, &old.header) == formatHeader(s.setType, &s.header) {
+			return nil
+		}
+		return kernelError(ipsetCmdCreate, setType, nlError(ipsetErrExist))
+	}
+	m.sets = append(m.sets, s)
+	return nil
+}
+
+// init checks the create options and fills in the defaults.
+func (s *emuSet) init() error {
+	h := &s.header
+	fail := func(code int) error {
+		return kernelError(ipsetCmdCreate, s.setType, nlError(code))
+	}
+
+	switch method(s.setType) {
+	case "hash":
+		if s.setType == HashMac {
+			h.family = ""
+		} else if h.family == "" {
+			h.family = Inet
+		}
+		if h.hashSize == 0 {
+			h.hashSize = emuHashSize
+		}
+		// the kernel rounds hashsize up to a power of two
+		size := uint32(64)
+		for size < h.hashSize && size < 1<<31 {
+			size <<= 1
+		}
+		h.hashSize = size
+		if h.maxElem == 0 {
+			h.maxElem = emuMaxElem
+		}
+		if s.setType == HashIpMark && h.markmask == 0 {
+			h.markmask = 0xffffffff
+		}
+		if h.netmask != 0 {
+			bits := uint8(32)
+			if h.family == Inet6 {
+				bits = 128
+			}
+			if s.setType != HashIp || h.netmask > bits {
+				return fail(ipsetErrInvalidNetmask)
+			}
+			if h.netmask == bits {
+				h.netmask = 0
+			}
+		}
+	case "bitmap":
+		if h.family == Inet6 {
+			return fail(ipsetErrInvalidFamily)
+		}
+		h.family = ""
+		if h.ipRange == "" {
+			return fmt.Errorf("Syntax error: Missing mandatory argument: range")
+		}
+		if err := s.initRange(); err != nil {
+			return err
+		}
+	case "list":
+		h.family = ""
+		if h.size == 0 {
+			h.size = emuListSize
+		}
+	}
+	return nil
+}
+
+// initRange parses the range of a bitmap set.
+func (s *emuSet) initRange() error {
+	h := &s.header
+	fail := func(code int) error {
+		return kernelError(ipsetCmdCreate, s.setType, nlError(code))
+	}
+
+	if s.setType == BitmapPort {
+		_, from, to, err := parsePort(h.ipRange)
+		if err != nil || to == 0 {
+			return fmt.Errorf("Syntax error: invalid port range %s", h.ipRange)
+		}
+		if from > to {
+			from, to = to, from
+		}
+		s.first, s.last = uint32(from), uint32(to)
+		h.ipRange = i2str(uint64(from)) + "-" + i2str(uint64(to))
+		return nil
+	}
+
+	ip, to, cidr, err := parseIPAddr(h.ipRange, Inet)
+	if err != nil {
+		return fmt.Errorf("Syntax error: cannot parse %s: %s", h.ipRange, err)
+	}
+	s.first, s.last = ip2uint(ip), ip2uint(ip)
+	if to != nil {
+		s.last = ip2uint(to)
+	} else if cidr != 0 {
+		mask := ^uint32(0) << (32 - cidr)
+		s.first &= mask
+		s.last = s.first | ^mask
+	} else {
+		return fmt.Errorf("Syntax error: invalid range %s", h.ipRange)
+	}
+	if s.first > s.last {
+		s.first, s.last = s.last, s.first
+	}
+
+	if h.netmask != 0 {
+		if s.setType != BitmapIp || h.netmask > 32 {
+			return fail(ipsetErrInvalidNetmask)
+		}
+		if h.netmask == 32 {
+			h.netmask = 0
+		}
+	}
+	mask := s.netmask()
+	s.first &= mask
+	s.last |= ^mask
+	if uint64(s.last-s.first)/(uint64(^mask)+1)+1 > emuMaxRange {
+		return fail(ipsetErrTypeSpecific + 1)
+	}
+	h.ipRange = uint2ip(s.first).String() + "-" + uint2ip(s.last).String()
+	return nil
+}
+
+// netmask returns the netmask of an IPv4 set as an integer.
+func (s *emuSet) netmask() uint32 {
+	if s.header.netmask == 0 {
+		return ^uint32(0)
+	}
+	return ^uint32(0) << (32 - s.header.netmask)
+}
+
+func (m *Emulator) destroy(req *request) error {
+	if req.name == "" {
+		for _, s := range m.sets {
+			if s.refs > 0 {
+				return kernelError(ipsetCmdDestroy, "", nlError(ipsetErrBusy))
+			}
+		}
+		m.sets = nil
+		return nil
+	}
+
+	i := m.find(req.name)
+	if i == -1 {
+		return kernelError(ipsetCmdDestroy, "", nlError(errnoENOENT))
+	}
+	s := m.sets[i]
+	if s.refs > 0 {
+		return kernelError(ipsetCmdDestroy, "", nlError(ipsetErrBusy))
+	}
+	m.clear(s)
+	m.sets = append(m.sets[:i], m.sets[i+1:]...)
+	return nil
+}
+
+func (m *Emulator) flush(req *request) error {
+	if req.name == "" {
+		for _, s := range m.sets {
+			m.clear(s)
+		}
+		return nil
+	}
+
+	s, err := m.get(ipsetCmdFlush, req.name)
+	if err != nil {
+		return err
+	}
+	m.clear(s)
+	return nil
+}
+
+// clear removes all entries of the set.
+func (m *Emulator) clear(s *emuSet) {
+	for len(s.keys) > 0 {
+		m.remove(s, s.keys[len(s.keys)-1])
+	}
+}
+
+func (m *Emulator) rename(req *request) error {
+	s, err := m.get(ipsetCmdRename, req.name)
+	if err != nil {
+		return err
+	}
+	if m.find(req.arg) != -1 {
+		return kernelError(ipsetCmdRename, "", nlError(ipsetErrExistSetname2))
+	}
+	if s.refs > 0 {
+		return kernelError(ipsetCmdRename, "", nlError(ipsetErrReferenced))
+	}
+	s.name = req.arg
+	return nil
+}
+
+func (m *Emulator) swap(req *request) error {
+	i := m.find(req.name)
+	if i == -1 {
+		return kernelError(ipsetCmdSwap, "", nlError(errnoENOENT))
+	}
+	j := m.find(req.arg)
+	if j == -1 {
+		return kernelError(ipsetCmdSwap, "", nlError(ipsetErrExistSetname2))
+	}
+	from, to := m.sets[i], m.sets[j]
+	if from.setType != to.setType || from.header.family != to.header.family {
+		return kernelError(ipsetCmdSwap, "", nlError(ipsetErrTypeMismatch))
+	}
+
+	// names and references stay where they are, contents move
+	from.name, to.name = to.name, from.name
+	from.refs, to.refs = to.refs, from.refs
+	m.sets[i], m.sets[j] = to, from
+	return nil
+}
+
+func (m *Emulator) adt(req *request, out io.Writer) error {
+	cmd := uint8(ipsetCmdAdd)
+	switch req.action {
+	case _del:
+		cmd = ipsetCmdDel
+	case _test:
+		cmd = ipsetCmdTest
+	}
+
+	s, err := m.get(cmd, req.name)
+	if err != nil {
+		return err
+	}
+	if err = s.checkExt(cmd, &req.entry); err != nil {
+		return err
+	}
+
+	e, err := parseElement(s.setType, s.header.family, req.arg)
+	if err != nil {
+		return err
+	}
+	if s.setType == ListSet {
+		err = m.adtList(cmd, s, req, e.name)
+	} else {
+		err = m.adtElement(cmd, s, req, e)
+	}
+	if err == nil && cmd == ipsetCmdTest {
+		_, err = fmt.Fprintf(out, "%s is in set %s.\n", req.arg, req.name)
+	}
+	return err
+}
+
+// checkExt checks whether the extensions are supported by the set.
+func (s *emuSet) checkExt(cmd uint8, ext *entryArgs) error {
+	h := &s.header
+	code := 0
+	switch {
+	case ext.hasTimeout && !h.hasTimeout:
+		code = ipsetErrTimeout
+	case (ext.packets != 0 || ext.bytes != 0) && !h.counters:
+		code = ipsetErrCounter
+	case ext.comment != "" && !h.comment:
+		code = ipsetErrComment
+	case (ext.skbmask != 0 || ext.skbprio != 0 || ext.skbqueue != 0) && !h.skbinfo:
+		code = ipsetErrSkbinfo
+	}
+	if code != 0 {
+		return kernelError(cmd, s.setType, nlError(code))
+	}
+
+	if ext.nomatch && !hasNet(s.setType) {
+		return fmt.Errorf("Unknown argument: `%s'", _nomatch)
+	}
+	if ext.wildcard && s.setType != HashNetIface {
+		return fmt.Errorf("Unknown argument: `%s'", _wildcard)
+	}
+	if s.setType != ListSet {
+		if ext.before != "" {
+			return fmt.Errorf("Unknown argument: `%s'", _before)
+		}
+		if ext.after != "" {
+			return fmt.Errorf("Unknown argument: `%s'", _after)
+		}
+	}
+	return nil
+}
+
+// hasNet reports whether the set type stores networks.
+func hasNet(setType SetType) bool {
+	for _, dim := range dimensions(setType) {
+		if dim == _net {
+			return true
+		}
+	}
+	return false
+}
+
+// ipKinds returns the kinds of the first and second ip dimension
+// of the set type, which are empty if there isn't one.
+func ipKinds(setType SetType) (k1, k2 string) {
+	for _, dim := range dimensions(setType) {
+		if dim != _ip && dim != _net {
+			continue
+		}
+		if k1 == "" {
+			k1 = dim
+		} else {
+			k2 = dim
+		}
+	}
+	return
+}
+
+func (m *Emulator) adtElement(cmd uint8, s *emuSet, req *request, e *element) error {
+	fail := func(code int) error {
+		return kernelError(cmd, s.setType, nlError(code))
+	}
+
+	// a zero prefix length is refused for networks and covers the
+	// whole address space for addresses
+	parts := strings.Split(req.arg, ",")
+	for i, dim := range dimensions(s.setType) {
+		if i >= len(parts) || !strings.HasSuffix(parts[i], "/0") {
+			continue
+		}
+		if dim == _net {
+			return fail(ipsetErrInvalidCidr)
+		}
+		if dim == _ip && cmd != ipsetCmdTest {
+			if method(s.setType) == "bitmap" {
+				return fail(ipsetErrTypeSpecific)
+			}
+			return fail(ipsetErrTypeSpecific + 5)
+		}
+	}
+	if s.setType == HashIpMark {
+		e.mark &= s.header.markmask
+	}
+	if method(s.setType) == "hash" {
+		if (s.setType == HashIp && e.ip.IsUnspecified() && !strings.ContainsAny(parts[0], "/-")) ||
+			(s.setType == HashMac && bytes.Equal(e.mac, make(net.HardwareAddr, len(e.mac)))) {
+			return fail(ipsetErrTypeSpecific + 1)
+		}
+	}
+
+	if cmd == ipsetCmdTest {
+		return m.test(s, req, e)
+	}
+	if method(s.setType) == "bitmap" {
+		// nothing is changed if a part of the element is out of range
+		if err := s.each(e, s.inRange); err != nil {
+			return err
+		}
+	}
+	return s.each(e, func(el *element) error {
+		if cmd == ipsetCmdAdd {
+			return m.addEntry(s, req, s.key(el), el)
+		}
+		return m.delEntry(s, req, s.key(el))
+	})
+}
+
+// key returns the key of an expanded element in the set.
+func (s *emuSet) key(e *element) string {
+	if s.setType == BitmapIpMac {
+		// one entry is stored for an ip, the mac may be filled later
+		return e.ip.String()
+	}
+	return e.format(s.setType)
+}
+
+// inRange checks whether the element is in the range of a bitmap
+// set.
+func (s *emuSet) inRange(e *element) error {
+	if method(s.setType) != "bitmap" {
+		return nil
+	}
+	n := uint32(e.port)
+	if s.setType != BitmapPort {
+		n = ip2uint(e.ip)
+	}
+	if n < s.first || n > s.last {
+		return kernelError(ipsetCmdAdd, s.setType, nlError(ipsetErrTypeSpecific))
+	}
+	return nil
+}
+
+func (m *Emulator) addEntry(s *emuSet, req *request, key string, e *element) error {
+	entry := &emuEntry{elem: e, ext: req.entry}
+	entry.ext.before, entry.ext.after = "", ""
+	if entry.ext.skbmask == 0 {
+		entry.ext.skbmask = 0xffffffff
+	}
+	timeout := s.header.timeout
+	if entry.ext.hasTimeout {
+		timeout = entry.ext.timeout
+	}
+	if s.header.hasTimeout && timeout != 0 {
+		entry.expires = m.now().Add(time.Duration(timeout) * time.Second)
+	}
+
+	if old, ok := s.entries[key]; ok {
+		if !req.exist {
+			return kernelError(ipsetCmdAdd, s.setType, nlError(ipsetErrExist))
+		}
+		if old.elem.mac != nil && e.mac == nil {
+			entry.elem = old.elem
+		}
+		s.entries[key] = entry
+		return nil
+	}
+
+	if method(s.setType) == "hash" && uint32(len(s.keys)) >= s.header.maxElem {
+		if !s.header.forceadd {
+			return kernelError(ipsetCmdAdd, s.setType, nlError(ipsetErrTypeSpecific))
+		}
+		m.remove(s, s.keys[0])
+	}
+	s.entries[key] = entry
+	s.keys = append(s.keys, key)
+	return nil
+}
+
+func (m *Emulator) delEntry(s *emuSet, req *request, key string) error {
+	if _, ok := s.entries[key]; !ok {
+		if req.exist {
+			return nil
+		}
+		return kernelError(ipsetCmdDel, s.setType, nlError(ipsetErrExist))
+	}
+	m.remove(s, key)
+	return nil
+}
+
+// remove removes the entry with the key from the set, the
+// reference held by a list:set entry is released.
+func (m *Emulator) remove(s *emuSet, key string) {
+	delete(s.entries, key)
+	for i, k := range s.keys {
+		if k == key {
+			s.keys = append(s.keys[:i], s.keys[i+1:]...)
+			break
+		}
+	}
+	if s.setType == ListSet {
+		if i := m.find(key); i != -1 {
+			m.sets[i].refs--
+		}
+	}
+}
+
+// test looks for the most specific entry matching the element, an
+// entry with nomatch makes the element not in the set unless the
+// nomatch flag is tested too.
+func (m *Emulator) test(s *emuSet, req *request, e *element) error {
+	k1, k2 := ipKinds(s.setType)
+	host1 := k1 == _net && e.cidr == 0
+	host2 := k2 == _net && e.cidr2 == 0
+
+	var (
+		t   = *e
+		err error
+	)
+	t.ipTo, t.ip2To, t.portTo = nil, nil, 0
+	if t.ip, t.cidr, err = s.testIP(k1, t.ip, t.cidr, s.header.netmask); err != nil {
+		return err
+	}
+	if t.ip2, t.cidr2, err = s.testIP(k2, t.ip2, t.cidr2, 0); err != nil {
+		return err
+	}
+	if err = s.inRange(&t); err != nil {
+		return fmt.Errorf("%s is NOT in set %s.", req.arg, req.name)
+	}
+
+	found := s.entries[s.key(&t)]
+	if found != nil && s.setType == BitmapIpMac && t.mac != nil &&
+		found.elem.mac != nil && !bytes.Equal(found.elem.mac, t.mac) {
+		found = nil
+	}
+	if found == nil && (host1 || host2 || s.setType == HashNetIface) {
+		var best [2]uint8
+		for _, key := range s.keys {
+			entry := s.entries[key]
+			if !matchNet(entry.elem, &t, host1, host2, entry.ext.wildcard) {
+				continue
+			}
+			if c := [2]uint8{entry.elem.cidr, entry.elem.cidr2}; found == nil ||
+				c[0] > best[0] || (c[0] == best[0] && c[1] > best[1]) {
+				found, best = entry, c
+			}
+		}
+	}
+
+	if found == nil || found.ext.nomatch != req.entry.nomatch {
+		return fmt.Errorf("%s is NOT in set %s.", req.arg, req.name)
+	}
+	return nil
+}
+
+// testIP normalizes a tested ip dimension: addresses are masked by
+// the netmask and a network without prefix length is a host.
+func (s *emuSet) testIP(kind string, ip net.IP, cidr, netmask uint8) (net.IP, uint8, error) {
+	if ip == nil {
+		return nil, 0, nil
+	}
+	bits := uint8(len(ip) * 8)
+	switch kind {
+	case _ip:
+		if netmask != 0 {
+			ip = ip.Mask(net.CIDRMask(int(netmask), int(bits)))
+		}
+		if method(s.setType) == "bitmap" {
+			return ip, netmask, nil
+		}
+		return ip, 0, nil
+	case _net:
+		if cidr == 0 {
+			cidr = bits
+		}
+		return ip.Mask(net.CIDRMask(int(cidr), int(bits))), cidr, nil
+	}
+	return ip, cidr, nil
+}
+
+// matchNet reports whether the networks of the entry contain the
+// hosts of the tested element, the other dimensions must be equal.
+// The interface of a wildcard entry is a prefix of the tested one.
+func matchNet(e, t *element, host1, host2, wildcard bool) bool {
+	contains := func(ip net.IP, cidr uint8, host bool, tip net.IP, tcidr uint8) bool {
+		if ip == nil {
+			return true
+		}
+		if len(ip) != len(tip) {
+			return false
+		}
+		if !host {
+			return cidr == tcidr && ip.Equal(tip)
+		}
+		return ip.Equal(tip.Mask(net.CIDRMask(int(cidr), len(ip)*8)))
+	}
+	return contains(e.ip, e.cidr, host1, t.ip, t.cidr) &&
+		contains(e.ip2, e.cidr2, host2, t.ip2, t.cidr2) &&
+		e.proto == t.proto && e.port == t.port &&
+		bytes.Equal(e.mac, t.mac) &&
+		(e.iface == t.iface || wildcard && strings.HasPrefix(t.iface, e.iface)) &&
+		e.physdev == t.physdev &&
+		e.mark == t.mark
+}
+
+// each expands ranges and networks of the element to the entries
+// stored in the set, and calls fn for each one of them.
+func (s *emuSet) each(e *element, fn func(*element) error) error {
+	k1, k2 := ipKinds(s.setType)
+	return s.eachIP(k1, e.ip, e.ipTo, e.cidr, s.header.netmask, func(ip net.IP, cidr uint8) error {
+		return eachPort(e, func(port uint16) error {
+			return s.eachIP(k2, e.ip2, e.ip2To, e.cidr2, 0, func(ip2 net.IP, cidr2 uint8) error {
+				el := *e
+				el.ip, el.ipTo, el.cidr = ip, nil, cidr
+				el.port, el.portTo = port, 0
+				el.ip2, el.ip2To, el.cidr2 = ip2, nil, cidr2
+				return fn(&el)
+			})
+		})
+	})
+}
+
+// eachIP expands an ip dimension. A range of networks is split into
+// networks, a range or network of addresses is split into addresses
+// or networks of the netmask.
+func (s *emuSet) eachIP(kind string, ip, to net.IP, cidr, netmask uint8, fn func(net.IP, uint8) error) error {
+	if ip == nil {
+		return fn(nil, 0)
+	}
+	bits := uint8(len(ip) * 8)
+	typeError := func(code int) error {
+		return kernelError(ipsetCmdAdd, s.setType, nlError(ipsetErrTypeSpecific+code))
+	}
+
+	if kind == _net {
+		if to == nil {
+			if cidr == 0 {
+				cidr = bits
+			}
+			return fn(ip.Mask(net.CIDRMask(int(cidr), int(bits))), cidr)
+		}
+		from, last := ip2uint(ip), ip2uint(to)
+		if from > last {
+			from, last = last, from
+		}
+		if from == 0 && last == ^uint32(0) {
+			return typeError(5)
+		}
+		return rangeToCIDRs(from, last, func(n uint32, cidr uint8) error {
+			return fn(uint2ip(n), cidr)
+		})
+	}
+
+	if bits != 32 {
+		if to != nil || (cidr != 0 && cidr != bits) {
+			return typeError(4)
+		}
+		if netmask != 0 {
+			ip = ip.Mask(net.CIDRMask(int(netmask), int(bits)))
+		}
+		return fn(ip, 0)
+	}
+
+	from, last := ip2uint(ip), ip2uint(ip)
+	if to != nil {
+		last = ip2uint(to)
+		if from > last {
+			from, last = last, from
+		}
+	} else if cidr != 0 {
+		mask := ^uint32(0) << (32 - cidr)
+		from &= mask
+		last = from | ^mask
+	}
+	if from == 0 && last == ^uint32(0) && method(s.setType) == "hash" {
+		return typeError(5)
+	}
+
+	mask := ^uint32(0)
+	if netmask != 0 {
+		mask <<= 32 - netmask
+	}
+	stored := uint8(0)
+	if method(s.setType) == "bitmap" {
+		stored = netmask
+	}
+	for n := from & mask; n <= last; {
+		if err := fn(uint2ip(n), stored); err != nil {
+			return err
+		}
+		next := n + ^mask + 1
+		if next <= n {
+			break
+		}
+		n = next
+	}
+	return nil
+}
+
+// eachPort expands the port range of the element.
+func eachPort(e *element, fn func(uint16) error) error {
+	from, to := e.port, e.portTo
+	if to == 0 || e.proto == protoICMP || e.proto == protoICMPv6 {
+		to = from
+	}
+	if from > to {
+		from, to = to, from
+	}
+	for port := uint32(from); port <= uint32(to); port++ {
+		if err := fn(uint16(port)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rangeToCIDRs splits the IPv4 range into the least networks.
+func rangeToCIDRs(from, to uint32, fn func(uint32, uint8) error) error {
+	for n := uint64(from); n <= uint64(to); {
+		cidr := uint8(32)
+		for cidr > 1 {
+			size := uint64(1) << (33 - cidr)
+			if n%size != 0 || n+size-1 > uint64(to) {
+				break
+			}
+			cidr--
+		}
+		if err := fn(uint32(n), cidr); err != nil {
+			return err
+		}
+		n += uint64(1) << (32 - cidr)
+	}
+	return nil
+}
+
+func ip2uint(ip net.IP) uint32 {
+	if v4 := ip.To4(); v4 != nil {
+		return binary.BigEndian.Uint32(v4)
+	}
+	return 0
+}
+
+func uint2ip(n uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip
+}
+
+// adtList adds, deletes or tests the member set of a list:set.
+func (m *Emulator) adtList(cmd uint8, s *emuSet, req *request, name string) error {
+	fail := func(code int) error {
+		return kernelError(cmd, s.setType, nlError(code))
+	}
+
+	i := m.find(name)
+	if i == -1 {
+		if cmd == ipsetCmdTest {
+			return fmt.Errorf("%s is NOT in set %s.", req.arg, req.name)
+		}
+		return fail(ipsetErrTypeSpecific)
+	}
+	member := m.sets[i]
+	if cmd == ipsetCmdAdd && member.setType == ListSet {
+		return fail(ipsetErrTypeSpecific + 1)
+	}
+
+	// pos is where the member is inserted or expected by before
+	// or after
+	pos := -1
+	ref, after := req.entry.before, false
+	if req.entry.after != "" {
+		ref, after = req.entry.after, true
+	}
+	if ref != "" {
+		if m.find(ref) == -1 {
+			return fail(ipsetErrTypeSpecific + 3)
+		}
+		if pos = indexOf(s.keys, ref); pos == -1 {
+			if cmd == ipsetCmdTest {
+				return fmt.Errorf("%s is NOT in set %s.", req.arg, req.name)
+			}
+			return fail(ipsetErrTypeSpecific + 5)
+		}
+		if after {
+			pos++
+		}
+	}
+
+	cur := indexOf(s.keys, name)
+	if cmd != ipsetCmdAdd {
+		// the member is right before or after the referred one
+		want := cur
+		if ref != "" && !after {
+			want = pos - 1
+		} else if ref != "" {
+			want = pos
+		}
+		if cur == -1 || cur != want {
+			if cmd == ipsetCmdTest {
+				return fmt.Errorf("%s is NOT in set %s.", req.arg, req.name)
+			}
+			if req.exist && cur == -1 {
+				return nil
+			}
+			return fail(ipsetErrExist)
+		}
+		if cmd == ipsetCmdDel {
+			m.remove(s, name)
+		}
+		return nil
+	}
+
+	if cur != -1 {
+		if !req.exist {
+			return fail(ipsetErrExist)
+		}
+		return m.addEntry(s, req, name, &element{name: name})
+	}
+	if uint32(len(s.keys)) >= s.header.size {
+		return fail(ipsetErrTypeSpecific + 4)
+	}
+	if err := m.addEntry(s, req, name, &element{name: name}); err != nil {
+		return err
+	}
+	if pos != -1 {
+		// move the appended member to its position
+		copy(s.keys[pos+1:], s.keys[pos:len(s.keys)-1])
+		s.keys[pos] = name
+	}
+	member.refs++
+	return nil
+}
+
+func indexOf(keys []string, key string) int {
+	for i, k := range keys {
+		if k == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *Emulator) list(req *request, out io.Writer) error {
+	cmd := uint8(ipsetCmdList)
+	if req.action == _save {
+		cmd = ipsetCmdSave
+	}
+
+	sets := m.sets
+	if req.name != "" {
+		s, err := m.get(cmd, req.name)
+		if err != nil {
+			return err
+		}
+		sets = []*emuSet{s}
+	}
+
+	data := make([]*setData, 0, len(sets))
+	for _, s := range sets {
+		m.expire(s)
+		data = append(data, m.data(s))
+	}
+	return writeSets(out, data, req)
+}
+
+// data renders the set with the remaining timeouts of entries.
+func (m *Emulator) data(s *emuSet) *setData {
+	d := &setData{
+		name:       s.name,
+		setType:    s.setType,
+		revision:   s.revision,
+		header:     s.header,
+		references: s.refs,
+		members:    make([]member, 0, len(s.keys)),
+	}
+
+	keys := s.keys
+	if method(s.setType) == "bitmap" {
+		// bitmaps are listed in the order of ip addresses or ports
+		keys = append([]string(nil), keys...)
+		sort.Slice(keys, func(i, j int) bool {
+			return bitmapIndex(s, keys[i]) < bitmapIndex(s, keys[j])
+		})
+	}
+
+	now := m.now()
+	for _, key := range keys {
+		e := s.entries[key]
+		ext := e.ext
+		ext.timeout = 0
+		if !e.expires.IsZero() {
+			// remaining seconds are rounded up
+			ext.timeout = uint32((e.expires.Sub(now) + time.Second - 1) / time.Second)
+		}
+		d.members = append(d.members, member{elem: e.elem.format(s.setType), ext: ext})
+	}
+	d.memSize = emuMemSize(s, len(d.members))
+	return d
+}
+
+// bitmapIndex returns the position of the entry key in a bitmap.
+func bitmapIndex(s *emuSet, key string) uint32 {
+	if s.setType == BitmapPort {
+		n, _ := strconv.ParseUint(key, 10, 16)
+		return uint32(n)
+	}
+	if i := strings.IndexByte(key, '/'); i != -1 {
+		key = key[:i]
+	}
+	return ip2uint(net.ParseIP(key))
+}
+
+// emuMemSize returns an estimated memory size of the set.
+func emuMemSize(s *emuSet, n int) uint32 {
+	switch method(s.setType) {
+	case "bitmap":
+		return 200 + (s.last-s.first)/8 + 1
+	case "list":
+		return 88 + 32*s.header.size
+	}
+	return 168 + 64*uint32(n)
+}
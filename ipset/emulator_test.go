@@ -0,0 +1,417 @@
+package ipset
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// run runs the command line with the emulator and fails the test
+// on error.
+func run(t *testing.T, e *Emulator, line string) string {
+	t.Helper()
+	out, err := e.Run(context.Background(), strings.Fields(line), nil)
+	require.Nil(t, err, "%s: %s", line, out)
+	return string(out)
+}
+
+// runErr runs the command line with the emulator and returns the
+// error message.
+func runErr(t *testing.T, e *Emulator, line string) string {
+	t.Helper()
+	out, err := e.Run(context.Background(), strings.Fields(line), nil)
+	require.Error(t, err, line)
+	assert.Equal(t, err.Error(), string(out))
+	return string(out)
+}
+
+func Test_Emulator_Client(t *testing.T) {
+	t.Parallel()
+
+	c := NewClient(NewEmulator())
+	require.Nil(t, c.Check())
+
+	s, err := c.New("foo", HashIp, Timeout(time.Minute), Comment(true))
+	require.Nil(t, err)
+	require.Nil(t, s.Add("1.1.1.1", CommentContent("a b")))
+	require.Nil(t, s.Add("1.1.1.2"))
+	ok, err := s.Test("1.1.1.1")
+	require.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = s.Test("1.1.1.3")
+	require.Nil(t, err)
+	assert.False(t, ok)
+
+	info, err := s.List()
+	require.Nil(t, err)
+	assert.Equal(t, "foo", info.Name)
+	assert.Equal(t, HashIp, info.SetType)
+	assert.Equal(t, 4, info.Revision)
+	assert.Equal(t, "family inet hashsize 1024 maxelem 65536 timeout 60 comment", info.Header.String())
+	assert.Equal(t, []string{`1.1.1.1 timeout 60 comment "a b"`, "1.1.1.2 timeout 60"}, info.Entries)
+
+	err = s.Add("1.1.1.1")
+	require.Error(t, err)
+	assert.Equal(t,
+		"ipset: can't add foo 1.1.1.1: Element cannot be added to the set: it's already added",
+		err.Error())
+
+	out, err := s.Save()
+	require.Nil(t, err)
+	require.Nil(t, s.Destroy())
+	_, err = s.List()
+	require.Error(t, err)
+
+	require.Nil(t, s.Restore(out))
+	ok, err = s.Test("1.1.1.2")
+	require.Nil(t, err)
+	assert.True(t, ok)
+
+	err = s.Restore(strings.NewReader("add foo 1.1.1.3\nadd foo 1.1.1.3\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(),
+		"Error in line 2: Element cannot be added to the set: it's already added")
+}
+
+func Test_Emulator_Sets(t *testing.T) {
+	t.Parallel()
+
+	e := NewEmulator()
+	run(t, e, "create foo hash:ip")
+	run(t, e, "create foo hash:ip -exist")
+	assert.Equal(t, "Set cannot be created: set with the same name already exists",
+		runErr(t, e, "create foo hash:ip timeout 10 -exist"))
+	assert.Equal(t, "Syntax error: typename 'hash:foo' is unknown",
+		runErr(t, e, "create bar hash:foo"))
+
+	run(t, e, "create bar hash:ip")
+	run(t, e, "add bar 1.1.1.1")
+	run(t, e, "swap foo bar")
+	assert.Contains(t, run(t, e, "list foo"), "1.1.1.1")
+	assert.Equal(t, "Sets cannot be swapped: the second set does not exist",
+		runErr(t, e, "swap foo baz"))
+	run(t, e, "create baz hash:net")
+	assert.Equal(t, "The sets cannot be swapped: their type does not match",
+		runErr(t, e, "swap foo baz"))
+
+	assert.Equal(t, "Set cannot be renamed: a set with the new name already exists",
+		runErr(t, e, "rename foo bar"))
+	run(t, e, "rename foo qux")
+
+	run(t, e, "flush")
+	assert.NotContains(t, run(t, e, "list qux"), "1.1.1.1")
+	assert.Equal(t, "Name: qux\nType: hash:ip\nRevision: 4\n"+
+		"Header: family inet hashsize 1024 maxelem 65536\n"+
+		"Size in memory: 168\nReferences: 0\nNumber of entries: 0\n", run(t, e, "list qux -terse"))
+	assert.Equal(t, "create bar hash:ip family inet hashsize 1024 maxelem 65536\n",
+		run(t, e, "save bar"))
+
+	run(t, e, "destroy qux")
+	assert.Equal(t, "The set with the given name does not exist", runErr(t, e, "destroy qux"))
+	assert.Equal(t, "The set with the given name does not exist", runErr(t, e, "list qux"))
+	run(t, e, "destroy")
+	assert.Equal(t, "", run(t, e, "list"))
+	assert.Contains(t, run(t, e, "version"), "ipset v7.1")
+}
+
+func Test_Emulator_Hash(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ranges", func(t *testing.T) {
+		e := NewEmulator()
+		run(t, e, "create foo hash:ip,port")
+		run(t, e, "add foo 10.0.0.0/31,udp:53-54")
+		assert.Equal(t, "create foo hash:ip,port family inet hashsize 1024 maxelem 65536\n"+
+			"add foo 10.0.0.0,udp:53\n"+
+			"add foo 10.0.0.0,udp:54\n"+
+			"add foo 10.0.0.1,udp:53\n"+
+			"add foo 10.0.0.1,udp:54\n", run(t, e, "save foo"))
+		run(t, e, "test foo 10.0.0.1,udp:54")
+		runErr(t, e, "test foo 10.0.0.1,tcp:54")
+		run(t, e, "del foo 10.0.0.0-10.0.0.1,udp:53")
+		assert.Equal(t, "Element cannot be deleted from the set: it's not added",
+			runErr(t, e, "del foo 10.0.0.0,udp:53"))
+		run(t, e, "del foo 10.0.0.0,udp:53 -exist")
+	})
+
+	t.Run("netmask", func(t *testing.T) {
+		e := NewEmulator()
+		run(t, e, "create foo hash:ip netmask 24")
+		run(t, e, "add foo 192.168.1.100")
+		run(t, e, "test foo 192.168.1.1")
+		assert.Contains(t, run(t, e, "list foo"), "\n192.168.1.0\n")
+		assert.Equal(t, "The value of the netmask parameter is invalid",
+			runErr(t, e, "create bar hash:ip netmask 33"))
+	})
+
+	t.Run("nets", func(t *testing.T) {
+		e := NewEmulator()
+		run(t, e, "create foo hash:net")
+		run(t, e, "add foo 10.0.0.0/8")
+		run(t, e, "add foo 10.1.0.0/16 nomatch")
+		run(t, e, "add foo 192.168.0.1-192.168.0.6")
+		run(t, e, "test foo 10.2.0.1")
+		run(t, e, "test foo 10.0.0.0/8")
+		runErr(t, e, "test foo 10.0.0.0/9")
+		assert.Equal(t, "10.1.1.1 is NOT in set foo.", runErr(t, e, "test foo 10.1.1.1"))
+		run(t, e, "test foo 10.1.0.0/16 nomatch")
+		run(t, e, "test foo 192.168.0.5")
+		runErr(t, e, "test foo 192.168.0.7")
+		assert.Contains(t, run(t, e, "list foo"),
+			"192.168.0.1\n192.168.0.2/31\n192.168.0.4/31\n192.168.0.6\n")
+		assert.Equal(t, "The value of the CIDR parameter of the IP address is invalid",
+			runErr(t, e, "add foo 10.0.0.0/0"))
+	})
+
+	t.Run("net dimensions", func(t *testing.T) {
+		e := NewEmulator()
+		run(t, e, "create foo hash:net,port,net")
+		run(t, e, "add foo 10.0.0.0/8,80,192.168.0.0/24")
+		run(t, e, "test foo 10.1.1.1,80,192.168.0.1")
+		runErr(t, e, "test foo 10.1.1.1,81,192.168.0.1")
+		runErr(t, e, "test foo 10.1.1.1,80,192.168.1.1")
+
+		run(t, e, "create bar hash:net,iface family inet6")
+		run(t, e, "add bar 2001:db8::/32,eth0")
+		run(t, e, "test bar 2001:db8::1,eth0")
+		runErr(t, e, "test bar 2001:db8::1,physdev:eth0")
+	})
+
+	t.Run("wildcard", func(t *testing.T) {
+		e := NewEmulator()
+		run(t, e, "create foo hash:net,iface")
+		run(t, e, "add foo 10.0.0.0/8,eth wildcard")
+		run(t, e, "add foo 192.168.0.0/16,wlan0")
+		run(t, e, "test foo 10.1.1.1,eth0")
+		run(t, e, "test foo 10.0.0.0/8,eth1")
+		runErr(t, e, "test foo 10.1.1.1,wlan0")
+		runErr(t, e, "test foo 192.168.0.1,wlan")
+		assert.Contains(t, run(t, e, "list foo"), "\n10.0.0.0/8,eth wildcard\n")
+
+		run(t, e, "create bar hash:net")
+		assert.Equal(t, "Unknown argument: `wildcard'", runErr(t, e, "add bar 10.0.0.0/8 wildcard"))
+	})
+
+	t.Run("null", func(t *testing.T) {
+		e := NewEmulator()
+		run(t, e, "create foo hash:ip")
+		assert.Equal(t, "Null-valued element, cannot be stored in a hash type of set",
+			runErr(t, e, "add foo 0.0.0.0"))
+		assert.Equal(t, "Invalid range, covers the whole address space",
+			runErr(t, e, "add foo 0.0.0.0/0"))
+	})
+
+	t.Run("mark", func(t *testing.T) {
+		e := NewEmulator()
+		run(t, e, "create foo hash:ip,mark markmask 0xff")
+		run(t, e, "add foo 1.1.1.1,0x1234")
+		run(t, e, "test foo 1.1.1.1,0x34")
+		assert.Contains(t, run(t, e, "list foo"), "1.1.1.1,0x00000034")
+	})
+}
+
+func Test_Emulator_Full(t *testing.T) {
+	t.Parallel()
+
+	e := NewEmulator()
+	run(t, e, "create foo hash:ip maxelem 2")
+	run(t, e, "add foo 1.1.1.1-1.1.1.2")
+	assert.Equal(t, "Hash is full, cannot add more elements", runErr(t, e, "add foo 1.1.1.3"))
+
+	run(t, e, "create bar hash:ip maxelem 2 forceadd")
+	run(t, e, "add bar 1.1.1.1-1.1.1.3")
+	runErr(t, e, "test bar 1.1.1.1")
+	run(t, e, "test bar 1.1.1.3")
+}
+
+func Test_Emulator_Timeout(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1600000000, 0)
+	e := NewEmulator()
+	e.SetClock(func() time.Time { return now })
+
+	run(t, e, "create foo hash:ip timeout 60")
+	run(t, e, "add foo 1.1.1.1")
+	run(t, e, "add foo 1.1.1.2 timeout 0")
+	run(t, e, "add foo 1.1.1.3 timeout 10")
+	assert.Equal(t, "Timeout cannot be used: set was created without timeout support",
+		func() string {
+			run(t, e, "create bar hash:ip")
+			return runErr(t, e, "add bar 1.1.1.1 timeout 10")
+		}())
+
+	e.Advance(30 * time.Second)
+	assert.Contains(t, run(t, e, "list foo"),
+		"Members:\n1.1.1.1 timeout 30\n1.1.1.2 timeout 0\n")
+	run(t, e, "add foo 1.1.1.1 -exist")
+
+	e.Advance(45 * time.Second)
+	run(t, e, "test foo 1.1.1.1")
+	run(t, e, "test foo 1.1.1.2")
+	runErr(t, e, "test foo 1.1.1.3")
+
+	e.Advance(time.Hour)
+	runErr(t, e, "test foo 1.1.1.1")
+	assert.Contains(t, run(t, e, "list foo"), "Number of entries: 1\n")
+}
+
+func Test_Emulator_Extensions(t *testing.T) {
+	t.Parallel()
+
+	e := NewEmulator()
+	run(t, e, "create foo hash:ip counters skbinfo")
+	run(t, e, "add foo 1.1.1.1 packets 10 bytes 20 skbmark 0x10/0xff skbqueue 2")
+	assert.Contains(t, run(t, e, "list foo"),
+		"1.1.1.1 packets 10 bytes 20 skbmark 0x10/0xff skbqueue 2\n")
+	assert.Equal(t, "Comment cannot be used: set was created without comment support",
+		runErr(t, e, "add foo 1.1.1.2 comment bar"))
+	assert.Equal(t, "Unknown argument: `nomatch'", runErr(t, e, "add foo 1.1.1.2 nomatch"))
+}
+
+func Test_Emulator_Bitmap(t *testing.T) {
+	t.Parallel()
+
+	e := NewEmulator()
+	run(t, e, "create foo bitmap:ip range 192.168.0.0/16 netmask 24")
+	run(t, e, "add foo 192.168.2.1")
+	run(t, e, "add foo 192.168.1.0-192.168.1.255")
+	run(t, e, "test foo 192.168.2.200")
+	assert.Equal(t, "Element is out of the range of the set",
+		runErr(t, e, "add foo 192.168.255.0-192.169.0.0"))
+	runErr(t, e, "test foo 192.168.255.1")
+	runErr(t, e, "test foo 10.0.0.1")
+	assert.Equal(t, "create foo bitmap:ip range 192.168.0.0-192.168.255.255 netmask 24\n"+
+		"add foo 192.168.1.0/24\n"+
+		"add foo 192.168.2.0/24\n", run(t, e, "save foo"))
+
+	assert.Equal(t, "The range you specified exceeds the size limit of the set type",
+		runErr(t, e, "create bar bitmap:ip range 10.0.0.0/8"))
+	assert.Equal(t, "Protocol family not supported by the set type",
+		runErr(t, e, "create bar bitmap:ip range 10.0.0.0/24 family inet6"))
+
+	run(t, e, "create bar bitmap:ip,mac range 10.0.0.0/24")
+	run(t, e, "add bar 10.0.0.1")
+	run(t, e, "add bar 10.0.0.1,01:02:03:04:05:06 -exist")
+	run(t, e, "test bar 10.0.0.1")
+	run(t, e, "test bar 10.0.0.1,01:02:03:04:05:06")
+	runErr(t, e, "test bar 10.0.0.1,01:02:03:04:05:07")
+
+	run(t, e, "create baz bitmap:port range 1024-2048")
+	run(t, e, "add baz 1500")
+	run(t, e, "add baz 1100-1101")
+	runErr(t, e, "add baz 80")
+	assert.Contains(t, run(t, e, "list baz"), "Members:\n1100\n1101\n1500\n")
+}
+
+func Test_Emulator_List(t *testing.T) {
+	t.Parallel()
+
+	e := NewEmulator()
+	run(t, e, "create foo list:set size 3")
+	run(t, e, "create a hash:ip")
+	run(t, e, "create b hash:net")
+	run(t, e, "create c hash:ip")
+	run(t, e, "create d hash:ip")
+
+	run(t, e, "add foo a")
+	run(t, e, "add foo c")
+	run(t, e, "add foo b before c")
+	run(t, e, "test foo b after a")
+	run(t, e, "test foo b before c")
+	runErr(t, e, "test foo a after b")
+	assert.Contains(t, run(t, e, "list foo"), "Members:\na\nb\nc\n")
+	assert.Contains(t, run(t, e, "list a"), "References: 1\n")
+
+	assert.Equal(t, "The set is full, more elements cannot be added.", runErr(t, e, "add foo d"))
+	assert.Equal(t, "Set to be added/deleted/tested as element does not exist.",
+		runErr(t, e, "add foo x"))
+	assert.Equal(t, "Sets with list:set type cannot be added to the set.",
+		runErr(t, e, "add foo foo"))
+	assert.Equal(t, "The set to which you referred with 'before' or 'after' is not added to the set.",
+		runErr(t, e, "add foo d before d"))
+
+	assert.Equal(t, "Set cannot be destroyed: it is in use by a kernel component",
+		runErr(t, e, "destroy a"))
+	assert.Equal(t, "Set cannot be destroyed: it is in use by a kernel component",
+		runErr(t, e, "destroy"))
+	assert.Equal(t, "Set cannot be renamed: it is in use by another system",
+		runErr(t, e, "rename a x"))
+
+	run(t, e, "del foo a")
+	run(t, e, "destroy a")
+	run(t, e, "flush foo")
+	run(t, e, "destroy")
+}
+
+func Test_Emulator_Restore(t *testing.T) {
+	t.Parallel()
+
+	e := NewEmulator()
+	_, err := e.Run(context.Background(), []string{_restore}, strings.NewReader(
+		"create foo hash:net comment\n"+
+			"add foo 10.0.0.0/8 comment \"a b\"\n"+
+			"\n"+
+			"add foo 10.0.0.0/8\n"))
+	require.Error(t, err)
+	assert.Equal(t, "Error in line 4: Element cannot be added to the set: it's already added", err.Error())
+	assert.Contains(t, run(t, e, "save"), `add foo 10.0.0.0/8 comment "a b"`)
+
+	_, err = e.Run(context.Background(), []string{_restore, _exist}, strings.NewReader("add foo 10.0.0.0/8\n"))
+	require.Nil(t, err)
+}
+
+func Test_Emulator_Output(t *testing.T) {
+	t.Parallel()
+
+	e := NewEmulator()
+	run(t, e, "create foo hash:ip")
+	run(t, e, "add foo 2.2.2.2")
+	run(t, e, "add foo 1.1.1.1")
+
+	assert.Equal(t, "create foo hash:ip family inet hashsize 1024 maxelem 65536\n"+
+		"add foo 1.1.1.1\nadd foo 2.2.2.2\n", run(t, e, "save foo -sorted"))
+	assert.Contains(t, run(t, e, "save foo -output plain"), "Members:\n2.2.2.2\n1.1.1.1\n")
+	assert.Contains(t, run(t, e, "list foo -o save"), "add foo 2.2.2.2\n")
+	assert.Equal(t, "Syntax error: xml output is not supported", runErr(t, e, "list -output xml"))
+	assert.Equal(t, "Syntax error: unknown output mode 'json'", runErr(t, e, "list -output json"))
+	assert.Equal(t, "Syntax error: missing value of -file", runErr(t, e, "save -file"))
+
+	dir, err := ioutil.TempDir("", "ipset")
+	require.Nil(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	file := filepath.Join(dir, "foo.save")
+
+	c := NewClient(e)
+	s, err := c.Open("foo")
+	require.Nil(t, err)
+	r, err := s.Save(File(file))
+	require.Nil(t, err)
+	out, _ := ioutil.ReadAll(r)
+	assert.Len(t, out, 0)
+
+	require.Nil(t, s.Destroy())
+	require.Nil(t, c.RestoreFile(file))
+	require.Error(t, c.RestoreFile(file))
+	require.Nil(t, c.RestoreFile(file, true))
+	info, err := s.List(Terse(true))
+	require.Nil(t, err)
+	assert.Len(t, info.Entries, 0)
+	assert.Equal(t, 2, info.NumEntries)
+
+	err = c.RestoreFile(filepath.Join(dir, "bar.save"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Cannot open")
+
+	infos, err := c.ListAll(Terse(true), Sorted(true))
+	require.Nil(t, err)
+	require.Len(t, infos, 1)
+	assert.Len(t, infos[0].Entries, 0)
+}
@@ -0,0 +1,9 @@
+//go:build armbe || arm64be || mips || mips64 || mips64p32 || ppc || ppc64 || s390 || s390x || sparc || sparc64
+// +build armbe arm64be mips mips64 mips64p32 ppc ppc64 s390 s390x sparc sparc64
+
+package ipset
+
+import "encoding/binary"
+
+// nativeEndian is the byte order of netlink message headers.
+var nativeEndian binary.ByteOrder = binary.BigEndian
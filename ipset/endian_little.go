@@ -0,0 +1,9 @@
+//go:build 386 || amd64 || amd64p32 || arm || arm64 || mipsle || mips64le || mips64p32le || ppc64le || riscv || riscv64 || wasm || loong64
+// +build 386 amd64 amd64p32 arm arm64 mipsle mips64le mips64p32le ppc64le riscv riscv64 wasm loong64
+
+package ipset
+
+import "encoding/binary"
+
+// nativeEndian is the byte order of netlink message headers.
+var nativeEndian binary.ByteOrder = binary.LittleEndian
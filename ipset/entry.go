@@ -0,0 +1,231 @@
+package ipset
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Entry is a member of a set with its extensions. The element is
+// split into its dimensions by the type of the set, e.g. IP, Proto
+// and Port are filled for 1.1.1.1,tcp:80 of hash:ip,port.
+type Entry struct {
+	// Elem is the element as listed by ipset.
+	Elem string
+
+	// IP, IPTo and CIDR hold the first ip or net dimension, which
+	// is an address, a range or a network.
+	IP   net.IP
+	IPTo net.IP
+	CIDR uint8
+	// Proto is the protocol name or number of the port dimension.
+	Proto string
+	// Port and PortTo hold the port or port range, icmp and icmpv6
+	// store type<<8 | code in Port.
+	Port   uint16
+	PortTo uint16
+	// IP2, IP2To and CIDR2 hold the second ip or net dimension.
+	IP2     net.IP
+	IP2To   net.IP
+	CIDR2   uint8
+	MAC     net.HardwareAddr
+	Iface   string
+	Physdev bool
+	Mark    uint32
+	// Set is the member set of a list:set.
+	Set string
+
+	// Timeout is the remaining time of the entry, HasTimeout is
+	// set if the timeout is listed, where 0 means permanent.
+	Timeout    time.Duration
+	HasTimeout bool
+	Packets    uint64
+	Bytes      uint64
+	Comment    string
+	Skbmark    uint32
+	Skbmask    uint32
+	Skbprio    uint32
+	Skbqueue   uint16
+	Nomatch    bool
+	// Wildcard is set if the interface of a hash:net,iface entry
+	// matches all interfaces with it as prefix.
+	Wildcard bool
+}
+
+// String formats the entry as a line of list command, which can be
+// added back in a restore line after "add SETNAME".
+func (e *Entry) String() string {
+	h, a := e.ext()
+	return e.Elem + formatExt(h, a)
+}
+
+// words returns the element and the extensions of the entry as the
+// words of a restore line after "add SETNAME".
+func (e *Entry) words() []string {
+	h, a := e.ext()
+	return appendExt([]string{e.Elem}, h, a)
+}
+
+// ext returns the extensions of the entry with a header which
+// enables them.
+func (e *Entry) ext() (*createArgs, *entryArgs) {
+	h := &createArgs{
+		hasTimeout: e.HasTimeout,
+		counters:   e.Packets != 0 || e.Bytes != 0,
+		comment:    true,
+		skbinfo:    true,
+	}
+	return h, &entryArgs{
+		timeout:  uint32(e.Timeout / time.Second),
+		packets:  e.Packets,
+		bytes:    e.Bytes,
+		comment:  e.Comment,
+		skbmark:  e.Skbmark,
+		skbmask:  e.Skbmask,
+		skbprio:  e.Skbprio,
+		skbqueue: e.Skbqueue,
+		nomatch:  e.Nomatch,
+		wildcard: e.Wildcard,
+	}
+}
+
+// parseEntry parses a member line of list output, or the part of
+// an add line after the set name.
+func parseEntry(setType SetType, family NetFamily, line string) (*Entry, error) {
+	words, err := splitLine(line)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("empty entry")
+	}
+	return newEntry(setType, family, words[0], words[1:])
+}
+
+// newEntry builds an entry from the element and the words of its
+// extensions.
+func newEntry(setType SetType, family NetFamily, elem string, ext []string) (*Entry, error) {
+	var a entryArgs
+	if err := a.parse(ext); err != nil {
+		return nil, err
+	}
+	return entryOf(setType, family, elem, &a)
+}
+
+// entryOf builds an entry from the element and its extensions.
+func entryOf(setType SetType, family NetFamily, elem string, a *entryArgs) (*Entry, error) {
+	e := &Entry{
+		Elem:       elem,
+		Timeout:    time.Duration(a.timeout) * time.Second,
+		HasTimeout: a.hasTimeout,
+		Packets:    a.packets,
+		Bytes:      a.bytes,
+		Comment:    a.comment,
+		Skbmark:    a.skbmark,
+		Skbmask:    a.skbmask,
+		Skbprio:    a.skbprio,
+		Skbqueue:   a.skbqueue,
+		Nomatch:    a.nomatch,
+		Wildcard:   a.wildcard,
+	}
+
+	// host names printed by the resolve option are not looked up
+	// again, such entries keep the element only
+	if setType == "" || !isLiteral(setType, elem) {
+		return e, nil
+	}
+	el, err := parseElement(setType, family, elem)
+	if err != nil {
+		return nil, err
+	}
+	e.IP, e.IPTo, e.CIDR = el.ip, el.ipTo, el.cidr
+	e.Port, e.PortTo = el.port, el.portTo
+	e.IP2, e.IP2To, e.CIDR2 = el.ip2, el.ip2To, el.cidr2
+	e.MAC, e.Iface, e.Physdev = el.mac, el.iface, el.physdev
+	e.Mark, e.Set = el.mark, el.name
+	if el.port != 0 || el.proto != 0 {
+		if name, ok := protoNames[el.proto]; ok {
+			e.Proto = name
+		} else {
+			e.Proto = i2str(uint64(el.proto))
+		}
+	}
+	if setType == BitmapPort {
+		e.Proto = ""
+	}
+	return e, nil
+}
+
+// isLiteral reports whether the ip dimensions of the element are
+// written as addresses instead of host names.
+func isLiteral(setType SetType, elem string) bool {
+	parts := strings.Split(elem, ",")
+	for i, dim := range dimensions(setType) {
+		if i >= len(parts) || (dim != _ip && dim != _net) {
+			continue
+		}
+		part := parts[i]
+		if j := strings.IndexByte(part, '/'); j != -1 {
+			part = part[:j]
+		}
+		for _, ip := range strings.SplitN(part, "-", 2) {
+			if net.ParseIP(strings.Trim(ip, "[]")) == nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ParseEntries parses the add lines of save output into entries
+// grouped by set name, the create lines tell the types of sets.
+func ParseEntries(r io.Reader) (map[string][]*Entry, error) {
+	type info struct {
+		setType SetType
+		family  NetFamily
+	}
+	var (
+		sets    = make(map[string]info)
+		entries = make(map[string][]*Entry)
+	)
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 4096), 1<<20)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' || line == "COMMIT" {
+			continue
+		}
+		words, err := splitLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("ipset: can't parse entries in line %d: %s", n, err)
+		}
+		switch {
+		case len(words) >= 3 && words[0] == _create:
+			h, err := parseHeaderLine(strings.Join(words[3:], " "))
+			if err != nil {
+				return nil, fmt.Errorf("ipset: can't parse entries in line %d: %s", n, err)
+			}
+			sets[words[1]] = info{SetType(words[2]), h.Family}
+		case len(words) >= 3 && words[0] == _add:
+			set, ok := sets[words[1]]
+			if !ok {
+				return nil, fmt.Errorf("ipset: can't parse entries in line %d: set %s is not created", n, words[1])
+			}
+			e, err := newEntry(set.setType, set.family, words[2], words[3:])
+			if err != nil {
+				return nil, fmt.Errorf("ipset: can't parse entries in line %d: %s", n, err)
+			}
+			entries[words[1]] = append(entries[words[1]], e)
+		default:
+			return nil, fmt.Errorf("ipset: can't parse entries in line %d: unknown command %s", n, words[0])
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
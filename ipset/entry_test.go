@@ -0,0 +1,143 @@
+package ipset
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Entry_Parse(t *testing.T) {
+	t.Parallel()
+
+	t.Run("extensions", func(t *testing.T) {
+		e, err := parseEntry(HashIp, Inet,
+			`1.1.1.3 timeout 3599 packets 4 bytes 300 comment "x y  z" skbmark 0x10/0xff skbprio 1:2 skbqueue 3`)
+		require.Nil(t, err)
+		assert.Equal(t, "1.1.1.3", e.Elem)
+		assert.Equal(t, net.IPv4(1, 1, 1, 3).To4(), e.IP)
+		assert.Equal(t, 3599*time.Second, e.Timeout)
+		assert.True(t, e.HasTimeout)
+		assert.Equal(t, uint64(4), e.Packets)
+		assert.Equal(t, uint64(300), e.Bytes)
+		assert.Equal(t, "x y  z", e.Comment)
+		assert.Equal(t, uint32(0x10), e.Skbmark)
+		assert.Equal(t, uint32(0xff), e.Skbmask)
+		assert.Equal(t, uint32(0x10002), e.Skbprio)
+		assert.Equal(t, uint16(3), e.Skbqueue)
+	})
+
+	t.Run("dimensions", func(t *testing.T) {
+		e, err := parseEntry(HashNetPortNet, Inet, "10.0.0.0/8,udp:53-54,192.168.0.1 nomatch")
+		require.Nil(t, err)
+		assert.Equal(t, "10.0.0.0", e.IP.String())
+		assert.Equal(t, uint8(8), e.CIDR)
+		assert.Equal(t, "udp", e.Proto)
+		assert.Equal(t, uint16(53), e.Port)
+		assert.Equal(t, uint16(54), e.PortTo)
+		assert.Equal(t, "192.168.0.1", e.IP2.String())
+		assert.True(t, e.Nomatch)
+
+		e, err = parseEntry(HashNetIface, Inet6, "2001:db8::/32,physdev:eth0")
+		require.Nil(t, err)
+		assert.Equal(t, "eth0", e.Iface)
+		assert.True(t, e.Physdev)
+
+		e, err = parseEntry(HashNetIface, Inet, "10.0.0.0/8,eth wildcard")
+		require.Nil(t, err)
+		assert.Equal(t, "eth", e.Iface)
+		assert.True(t, e.Wildcard)
+		assert.Equal(t, "10.0.0.0/8,eth wildcard", e.String())
+
+		e, err = parseEntry(BitmapPort, "", "80")
+		require.Nil(t, err)
+		assert.Equal(t, uint16(80), e.Port)
+		assert.Equal(t, "", e.Proto)
+
+		e, err = parseEntry(ListSet, "", "foo")
+		require.Nil(t, err)
+		assert.Equal(t, "foo", e.Set)
+	})
+
+	t.Run("resolved", func(t *testing.T) {
+		e, err := parseEntry(HashIp, Inet, "one.one.one.one")
+		require.Nil(t, err)
+		assert.Equal(t, "one.one.one.one", e.Elem)
+		assert.Nil(t, e.IP)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		_, err := parseEntry(HashIp, Inet, `1.1.1.1 comment "x`)
+		assert.Error(t, err)
+		_, err = parseEntry(HashIp, Inet, "1.1.1.1 timeout x")
+		assert.Error(t, err)
+		_, err = parseEntry(HashIpPort, Inet, "1.1.1.1")
+		assert.Error(t, err)
+	})
+}
+
+func Test_Entry_String(t *testing.T) {
+	t.Parallel()
+
+	for _, line := range []string{
+		"1.1.1.1",
+		"1.1.1.1 timeout 0",
+		`10.0.0.0/8 timeout 10 nomatch packets 1 bytes 2 comment "a \b c"`,
+		"1.1.1.1 skbmark 0x10 skbprio 1:2 skbqueue 3",
+	} {
+		e, err := parseEntry(HashNet, Inet, line)
+		require.Nil(t, err, line)
+		assert.Equal(t, line, e.String())
+	}
+}
+
+func Test_Entry_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	c := NewClient(NewEmulator())
+	s, err := c.New("foo", HashIp, Comment(true))
+	require.Nil(t, err)
+	require.Nil(t, s.Add("1.1.1.1", CommentContent("a b  c")))
+
+	info, err := s.List()
+	require.Nil(t, err)
+	require.Len(t, info.Members, 1)
+	assert.Equal(t, "a b  c", info.Members[0].Comment)
+
+	require.Nil(t, s.Flush())
+	require.Nil(t, s.Restore(strings.NewReader("add foo "+info.Members[0].String()+"\n")))
+	info, err = s.List()
+	require.Nil(t, err)
+	assert.Equal(t, "a b  c", info.Members[0].Comment)
+}
+
+func Test_ParseEntries(t *testing.T) {
+	t.Parallel()
+
+	entries, err := ParseEntries(strings.NewReader(`
+create foo hash:ip,port family inet6 hashsize 1024 maxelem 65536 comment
+add foo ::1,tcp:80 comment "x y"
+add foo ::2,udp:53
+create bar list:set size 8
+add bar foo
+`))
+	require.Nil(t, err)
+	require.Len(t, entries["foo"], 2)
+	assert.Equal(t, "::1", entries["foo"][0].IP.String())
+	assert.Equal(t, "x y", entries["foo"][0].Comment)
+	assert.Equal(t, "udp", entries["foo"][1].Proto)
+	assert.Equal(t, "foo", entries["bar"][0].Set)
+
+	for _, data := range []string{
+		"add foo 1.1.1.1\n",
+		"create foo hash:ip\nadd foo 1.1.1.1,80\n",
+		"create foo hash:ip\nadd foo 1.1.1.1 comment \"x\n",
+		"delete foo\n",
+	} {
+		_, err = ParseEntries(strings.NewReader(data))
+		assert.Error(t, err, data)
+	}
+}
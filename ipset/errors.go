@@ -0,0 +1,131 @@
+package ipset
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// Errors recognized from the output of failed commands, which are
+// wrapped by Error and can be checked by errors.Is.
+var (
+	// ErrSetNotFound is returned if the set doesn't exist.
+	ErrSetNotFound = errors.New("set not found")
+	// ErrSetExists is returned if a set with the name already exists.
+	ErrSetExists = errors.New("set already exists")
+	// ErrEntryExists is returned if the entry is already added.
+	ErrEntryExists = errors.New("entry already added")
+	// ErrEntryMissing is returned if the entry to delete is not added.
+	ErrEntryMissing = errors.New("entry not added")
+	// ErrSetFull is returned if no more entries can be added.
+	ErrSetFull = errors.New("set is full")
+	// ErrSetInUse is returned if the set is referenced by iptables
+	// rules or list:set sets.
+	ErrSetInUse = errors.New("set in use")
+	// ErrIncompatibleType is returned if the types of sets to swap
+	// don't match.
+	ErrIncompatibleType = errors.New("incompatible set types")
+	// ErrKernelModuleMissing is returned if the kernel doesn't
+	// support ipset or the set type.
+	ErrKernelModuleMissing = errors.New("kernel module missing")
+	// ErrPermission is returned if the process has no permission to
+	// talk to the kernel.
+	ErrPermission = errors.New("operation not permitted")
+)
+
+// ErrInvalidOption is returned by Validate and strict commands if an
+// option is not valid for the action or the set type.
+var ErrInvalidOption = errors.New("invalid option")
+
+// ErrUnsupported is returned if a feature or set type is newer than
+// the detected version of ipset.
+var ErrUnsupported = errors.New("not supported by ipset")
+
+// ErrVersionUnknown is returned by Client.Version if the version
+// can't be found in the output of the backend.
+var ErrVersionUnknown = errors.New("unknown ipset version")
+
+// ErrInvalidEntry is returned by BuildEntry if the dimensions don't fit
+// the set type.
+var ErrInvalidEntry = errors.New("invalid entry")
+
+// ErrChecksum is returned if the SHA-256 trailer of a save file
+// doesn't match its content.
+var ErrChecksum = errors.New("checksum mismatch")
+
+// ErrStop can be returned by the callback of ForEachEntry to stop
+// early, ForEachEntry returns nil then.
+var ErrStop = errors.New("stop")
+
+// errorPatterns maps parts of the messages of the ipset utility and
+// the kernel to the errors.
+var errorPatterns = []struct {
+	pattern string
+	err     error
+}{
+	{"The set with the given name does not exist", ErrSetNotFound},
+	{"the second set does not exist", ErrSetNotFound},
+	{"set with the same name already exists", ErrSetExists},
+	{"a set with the new name already exists", ErrSetExists},
+	{"it's already added", ErrEntryExists},
+	{"it's not added", ErrEntryMissing},
+	{"is full, cannot add more elements", ErrSetFull},
+	{"is full, more elements cannot be added", ErrSetFull},
+	{"it is in use by", ErrSetInUse},
+	{"their type does not match", ErrIncompatibleType},
+	{"set type not supported", ErrKernelModuleMissing},
+	{"set type does not supported", ErrKernelModuleMissing},
+	{"Kernel support protocol versions", ErrKernelModuleMissing},
+	{"Operation not permitted", ErrPermission},
+	{"Permission denied", ErrPermission},
+	{"Cannot open session to kernel", ErrPermission},
+}
+
+// Error is the error of a failed command. Its message is the raw
+// output, which is wrapped with the command by the callers.
+type Error struct {
+	Action string
+	Set    string
+	// Entry is the entry of add, del and test, or the other set of
+	// rename and swap.
+	Entry string
+	// Output is the raw output of the command.
+	Output string
+	// Line is the failing line of the input of restore, it's 0 if
+	// ipset doesn't report it.
+	Line int
+	// Err is the recognized error, it's nil if the output is unknown.
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.Output
+}
+
+// Unwrap returns the recognized error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// newError builds the error of a command by its output.
+func newError(action, set, entry, out string) *Error {
+	e := &Error{Action: action, Set: set, Entry: entry, Output: out}
+	e.Line, _ = parseLineError([]byte(out))
+	for _, p := range errorPatterns {
+		if strings.Contains(out, p.pattern) {
+			e.Err = p.err
+			break
+		}
+	}
+	return e
+}
+
+// failure returns the error of a failed command. It's the error of
+// ctx if the command is given up, so that callers can tell it apart
+// from the failures of ipset by errors.Is, otherwise it's an Error.
+func failure(ctx context.Context, action, set, entry string, out []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return newError(action, set, entry, string(out))
+}
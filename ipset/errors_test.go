@@ -0,0 +1,109 @@
+package ipset
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Errors(t *testing.T) {
+	t.Parallel()
+
+	c := NewClient(NewEmulator())
+	s, err := asSet(c.New("foo", HashIp, MaxElem(1)))
+	require.Nil(t, err)
+
+	_, err = c.New("foo", HashIp)
+	assert.True(t, errors.Is(err, ErrSetExists))
+
+	require.Nil(t, s.Add("1.1.1.1"))
+	err = s.Add("1.1.1.1")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrEntryExists))
+	assert.Equal(t, "ipset: can't add foo 1.1.1.1: Element cannot be added to the set: it's already added", err.Error())
+
+	var e *Error
+	require.True(t, errors.As(err, &e))
+	assert.Equal(t, _add, e.Action)
+	assert.Equal(t, "foo", e.Set)
+	assert.Equal(t, "1.1.1.1", e.Entry)
+	assert.Equal(t, "Element cannot be added to the set: it's already added", e.Output)
+
+	assert.True(t, errors.Is(s.Add("1.1.1.2"), ErrSetFull))
+	assert.True(t, errors.Is(s.Del("1.1.1.2"), ErrEntryMissing))
+	assert.True(t, errors.Is(c.Flush("bar"), ErrSetNotFound))
+	_, err = s.Test("1.1.1.2")
+	assert.Nil(t, err)
+
+	_, err = c.New("bar", HashNet)
+	require.Nil(t, err)
+	err = c.Swap("foo", "bar")
+	assert.True(t, errors.Is(err, ErrIncompatibleType))
+	require.True(t, errors.As(err, &e))
+	assert.Equal(t, "foo", e.Set)
+	assert.Equal(t, "bar", e.Entry)
+
+	l, err := c.New("l", ListSet)
+	require.Nil(t, err)
+	require.Nil(t, l.Add("bar"))
+	assert.True(t, errors.Is(c.Destroy("bar"), ErrSetInUse))
+
+	results, err := s.AddMany([]string{"1.1.1.1"})
+	require.Error(t, err)
+	assert.True(t, errors.Is(results[0].Err, ErrEntryExists))
+	require.True(t, errors.As(results[0].Err, &e))
+	assert.Equal(t, "1.1.1.1", e.Entry)
+
+	// unknown outputs wrap nothing
+	err = NewClient(&recorder{out: "fake error", err: errors.New("exit status 1")}).Flush("foo")
+	require.True(t, errors.As(err, &e))
+	assert.Nil(t, e.Err)
+	assert.Nil(t, errors.Unwrap(e))
+}
+
+func Test_NewError(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		out string
+		err error
+	}{
+		{"ipset v7.1: The set with the given name does not exist\n", ErrSetNotFound},
+		{"Sets cannot be swapped: the second set does not exist", ErrSetNotFound},
+		{"ipset v7.1: Set cannot be created: set with the same name already exists", ErrSetExists},
+		{"Set cannot be renamed: a set with the new name already exists", ErrSetExists},
+		{"Element cannot be added to the set: it's already added", ErrEntryExists},
+		{"Element cannot be deleted from the set: it's not added", ErrEntryMissing},
+		{"Hash is full, cannot add more elements", ErrSetFull},
+		{"The set is full, more elements cannot be added.", ErrSetFull},
+		{"Set cannot be destroyed: it is in use by a kernel component", ErrSetInUse},
+		{"Set cannot be renamed: it is in use by another system", ErrSetInUse},
+		{"The sets cannot be swapped: their type does not match", ErrIncompatibleType},
+		{"ipset v7.1: Kernel error received: set type not supported", ErrKernelModuleMissing},
+		{"ipset v6.38: Kernel support protocol versions 6-6 while userspace supports protocol versions 6-7", ErrKernelModuleMissing},
+		{"ipset v7.1: Kernel error received: Operation not permitted", ErrPermission},
+		{"ipset v7.1: Cannot open session to kernel.", ErrPermission},
+		{"fake error", nil},
+	}
+
+	for _, tc := range tt {
+		e := newError(_add, "foo", "", tc.out)
+		assert.Equal(t, tc.out, e.Error())
+		assert.Equal(t, tc.err, e.Err, tc.out)
+		if tc.err != nil {
+			assert.True(t, errors.Is(e, tc.err), tc.out)
+		}
+	}
+}
+
+func Test_Error_Line(t *testing.T) {
+	t.Parallel()
+
+	e := newError(_restore, "foo", "", "ipset v7.1: Error in line 3: Element cannot be added to the set: it's already added")
+	assert.Equal(t, 3, e.Line)
+	assert.True(t, errors.Is(e, ErrEntryExists))
+
+	assert.Equal(t, 0, newError(_restore, "foo", "", "fake error").Line)
+}
@@ -0,0 +1,193 @@
+package ipset
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checksumPrefix starts the trailer of save files, which is a
+// comment so that the files can still be read by ipset restore.
+const checksumPrefix = "# sha256 "
+
+// writeFile writes the file atomically by write, which is given a
+// writer of a temporary file in the same directory. The file is
+// synced and renamed to filename once it's written. It's compressed
+// by compress, or by gzip if the name ends with ".gz". The SHA-256
+// trailer is appended if checksum is set.
+func writeFile(filename, compress string, checksum bool, write func(w io.Writer) error) (err error) {
+	if compress == "" && strings.HasSuffix(filename, ".gz") {
+		compress = CompressGzip
+	}
+	if compress != "" && compress != CompressGzip {
+		return fmt.Errorf("%w: unknown compression %s", ErrInvalidOption, compress)
+	}
+
+	dir, base := filepath.Split(filepath.Clean(filename))
+	if dir == "" {
+		dir = "."
+	}
+	f, err := ioutil.TempFile(dir, "."+base+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	var (
+		w  io.Writer = f
+		zw *gzip.Writer
+	)
+	if compress == CompressGzip {
+		zw = gzip.NewWriter(f)
+		w = zw
+	}
+	h := sha256.New()
+	lw := &lastWriter{w: io.MultiWriter(w, h)}
+	if err = write(lw); err != nil {
+		return
+	}
+	if checksum {
+		if lw.n > 0 && lw.last != '\n' {
+			if _, err = lw.Write([]byte{'\n'}); err != nil {
+				return
+			}
+		}
+		if _, err = io.WriteString(w, checksumPrefix+hex.EncodeToString(h.Sum(nil))+"\n"); err != nil {
+			return
+		}
+	}
+	if zw != nil {
+		if err = zw.Close(); err != nil {
+			return
+		}
+	}
+	if err = f.Sync(); err != nil {
+		return
+	}
+	if err = f.Close(); err != nil {
+		return
+	}
+	if err = os.Rename(f.Name(), filename); err != nil {
+		return
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes the rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if e := d.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// lastWriter remembers the last byte written.
+type lastWriter struct {
+	w    io.Writer
+	n    int64
+	last byte
+}
+
+func (w *lastWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if n > 0 {
+		w.n += int64(n)
+		w.last = p[n-1]
+	}
+	return n, err
+}
+
+// openFile opens a file written by writeFile, which is decompressed
+// if it's gzipped.
+func openFile(filename string) (*fileReader, error) {
+	f, err := os.Open(filepath.Clean(filename))
+	if err != nil {
+		return nil, err
+	}
+	r := &fileReader{f: f}
+	if err = r.rewind(); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+type fileReader struct {
+	io.Reader
+	f *os.File
+}
+
+// rewind reads the file from the start again.
+func (r *fileReader) rewind() error {
+	if _, err := r.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	br := bufio.NewReader(r.f)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		r.Reader = zr
+		return nil
+	}
+	r.Reader = br
+	return nil
+}
+
+func (r *fileReader) Close() error {
+	return r.f.Close()
+}
+
+// verify reads the file through and checks its SHA-256 trailer, then
+// rewinds it. The open file is the one restored, so a file renamed
+// over it meanwhile isn't restored unverified. Files without the
+// trailer pass unless required is set, truncated gzip files are
+// refused either way.
+func (r *fileReader) verify(required bool) error {
+	var (
+		br   = bufio.NewReader(r)
+		h    = sha256.New()
+		prev string
+	)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			_, _ = io.WriteString(h, prev)
+			prev = line
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !strings.HasPrefix(prev, checksumPrefix) {
+		if required {
+			return fmt.Errorf("%w: no checksum in %s", ErrChecksum, r.f.Name())
+		}
+		return r.rewind()
+	}
+	if sum := strings.TrimSpace(prev[len(checksumPrefix):]); sum != hex.EncodeToString(h.Sum(nil)) {
+		return fmt.Errorf("%w: %s", ErrChecksum, r.f.Name())
+	}
+	return r.rewind()
+}
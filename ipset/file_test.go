@@ -0,0 +1,139 @@
+package ipset
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "ipset")
+	require.Nil(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return dir
+}
+
+func Test_WriteFile(t *testing.T) {
+	t.Parallel()
+
+	dir := tempDir(t)
+	write := func(s string) func(w io.Writer) error {
+		return func(w io.Writer) error {
+			_, err := io.WriteString(w, s)
+			return err
+		}
+	}
+
+	verify := func(filename string, required bool) error {
+		r, err := openFile(filename)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = r.Close() }()
+		return r.verify(required)
+	}
+
+	plain := filepath.Join(dir, "plain")
+	require.Nil(t, writeFile(plain, "", true, write("add foo 1.1.1.1")))
+	b, err := ioutil.ReadFile(plain)
+	require.Nil(t, err)
+	lines := strings.Split(string(b), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, "add foo 1.1.1.1", lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], checksumPrefix))
+	require.Nil(t, verify(plain, false))
+
+	// the file is compressed by its extension
+	gz := filepath.Join(dir, "sets.gz")
+	require.Nil(t, writeFile(gz, "", true, write("add foo 1.1.1.1\n")))
+	b, err = ioutil.ReadFile(gz)
+	require.Nil(t, err)
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	require.Nil(t, err)
+	content, err := ioutil.ReadAll(zr)
+	require.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(content), "add foo 1.1.1.1\n"+checksumPrefix))
+	require.Nil(t, verify(gz, false))
+
+	// truncated files are refused
+	require.Nil(t, ioutil.WriteFile(gz, b[:len(b)-10], 0600))
+	assert.Error(t, verify(gz, false))
+
+	require.Nil(t, ioutil.WriteFile(plain, bytes.Replace(content, []byte("1.1.1.1"), []byte("1.1.1.2"), 1), 0600))
+	assert.True(t, errors.Is(verify(plain, false), ErrChecksum))
+
+	// files without checksum are refused only if it's required
+	require.Nil(t, ioutil.WriteFile(plain, []byte("add foo 1.1.1.1\n"), 0600))
+	require.Nil(t, verify(plain, false))
+	assert.True(t, errors.Is(verify(plain, true), ErrChecksum))
+
+	// the verified file is read again from the start, even if
+	// another one is renamed over it
+	require.Nil(t, writeFile(gz, "", true, write("add foo 1.1.1.1\n")))
+	r, err := openFile(gz)
+	require.Nil(t, err)
+	require.Nil(t, r.verify(true))
+	require.Nil(t, writeFile(gz, "", true, write("add foo 1.1.1.2\n")))
+	content, err = ioutil.ReadAll(r)
+	require.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(content), "add foo 1.1.1.1\n"+checksumPrefix))
+	require.Nil(t, r.Close())
+
+	// nothing is left if the write fails
+	fail := errors.New("fail")
+	assert.Equal(t, fail, writeFile(filepath.Join(dir, "failed"), CompressGzip, true, func(io.Writer) error {
+		return fail
+	}))
+	err = writeFile(filepath.Join(dir, "failed"), "zstd", true, write(""))
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+	files, err := ioutil.ReadDir(dir)
+	require.Nil(t, err)
+	assert.Len(t, files, 2)
+}
+
+func Test_Set_SaveToFile_Compressed(t *testing.T) {
+	t.Parallel()
+
+	dir := tempDir(t)
+	filename := filepath.Join(dir, "foo")
+
+	c := NewClient(NewEmulator())
+	s, err := c.New("foo", HashIp)
+	require.Nil(t, err)
+	require.Nil(t, s.Add("1.1.1.1"))
+	require.Nil(t, s.SaveToFile(filename, Compress(CompressGzip)))
+	require.Nil(t, s.Destroy())
+
+	require.Nil(t, s.RestoreFromFile(filename))
+	info, err := s.List()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"1.1.1.1"}, info.Entries)
+
+	// the kernel is not touched if the checksum mismatches
+	require.Nil(t, writeFile(filename, "", false, func(w io.Writer) error {
+		_, err := io.WriteString(w, "add foo 1.1.1.2\n"+checksumPrefix+"00\n")
+		return err
+	}))
+	err = s.RestoreFromFile(filename)
+	assert.True(t, errors.Is(err, ErrChecksum))
+	info, err = s.List()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"1.1.1.1"}, info.Entries)
+
+	all := filepath.Join(dir, "all.gz")
+	require.Nil(t, c.SaveAllToFile(all))
+	c2 := NewClient(NewEmulator())
+	require.Nil(t, c2.RestoreAllFromFile(all))
+	names, err := c2.Names()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"foo"}, names)
+}
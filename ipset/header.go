@@ -0,0 +1,231 @@
+package ipset
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Header holds the create options of a set, which are listed in
+// the "Header:" line.
+type Header struct {
+	Family   NetFamily
+	HashSize uint
+	MaxElem  uint
+	Netmask  byte
+	Markmask uint32
+	// Range is the ip or port range of bitmap types.
+	Range string
+	// Size is the size of list:set.
+	Size uint
+	// Bucketsize and Initval are listed by ipset v7.11 and later.
+	Bucketsize uint8
+	Initval    uint32
+	// Timeout is the default timeout of entries, HasTimeout is set
+	// if the set supports timeouts.
+	Timeout    time.Duration
+	HasTimeout bool
+	Counters   bool
+	Comment    bool
+	Skbinfo    bool
+	Forceadd   bool
+}
+
+// String formats the header like ipset does.
+func (h *Header) String() string {
+	return formatHeader("", h.args())
+}
+
+// Options returns the options which create a set with the header.
+func (h *Header) Options() []Option {
+	opts := []Option{
+		Counters(h.Counters),
+		Comment(h.Comment),
+		Skbinfo(h.Skbinfo),
+		Forceadd(h.Forceadd),
+	}
+	if h.Family != "" {
+		opts = append(opts, Family(h.Family))
+	}
+	if h.HashSize != 0 {
+		opts = append(opts, HashSize(h.HashSize))
+	}
+	if h.MaxElem != 0 {
+		opts = append(opts, MaxElem(h.MaxElem))
+	}
+	if h.Netmask != 0 {
+		opts = append(opts, Netmask(h.Netmask))
+	}
+	if h.Markmask != 0 {
+		opts = append(opts, Markmask(h.Markmask))
+	}
+	if h.Range != "" {
+		opts = append(opts, IpRange(h.Range), PortRange(h.Range))
+	}
+	if h.Size != 0 {
+		opts = append(opts, ListSize(h.Size))
+	}
+	if h.Bucketsize != 0 {
+		opts = append(opts, Bucketsize(h.Bucketsize))
+	}
+	if h.Initval != 0 {
+		opts = append(opts, Initval(h.Initval))
+	}
+	if h.HasTimeout {
+		opts = append(opts, withTimeout(h.Timeout))
+	}
+	return opts
+}
+
+// newHeader returns the header of a set created with the options.
+func newHeader(o *options) *Header {
+	return &Header{
+		Family:     o.family,
+		HashSize:   o.hashSize,
+		MaxElem:    o.maxElem,
+		Netmask:    o.netmask,
+		Markmask:   o.markmask,
+		Range:      o.ipRange + o.portRange,
+		Size:       o.listSize,
+		Bucketsize: o.bucketsize,
+		Initval:    o.initval,
+		Timeout:    o.timeout,
+		HasTimeout: o.timeout > 0 || o.hasTimeout,
+		Counters:   o.counters,
+		Comment:    o.comment,
+		Skbinfo:    o.skbinfo,
+		Forceadd:   o.forceadd,
+	}
+}
+
+func (h *Header) args() *createArgs {
+	return &createArgs{
+		family:     h.Family,
+		ipRange:    h.Range,
+		hashSize:   uint32(h.HashSize),
+		maxElem:    uint32(h.MaxElem),
+		netmask:    h.Netmask,
+		markmask:   h.Markmask,
+		size:       uint32(h.Size),
+		bucketsize: h.Bucketsize,
+		initval:    h.Initval,
+		timeout:    uint32(h.Timeout / time.Second),
+		hasTimeout: h.HasTimeout,
+		counters:   h.Counters,
+		comment:    h.Comment,
+		skbinfo:    h.Skbinfo,
+		forceadd:   h.Forceadd,
+	}
+}
+
+// header returns the header of the create options.
+func (a *createArgs) header() Header {
+	return Header{
+		Family:     a.family,
+		HashSize:   uint(a.hashSize),
+		MaxElem:    uint(a.maxElem),
+		Netmask:    a.netmask,
+		Markmask:   a.markmask,
+		Range:      a.ipRange,
+		Size:       uint(a.size),
+		Bucketsize: a.bucketsize,
+		Initval:    a.initval,
+		Timeout:    time.Duration(a.timeout) * time.Second,
+		HasTimeout: a.hasTimeout,
+		Counters:   a.counters,
+		Comment:    a.comment,
+		Skbinfo:    a.skbinfo,
+		Forceadd:   a.forceadd,
+	}
+}
+
+// parseHeaderLine parses the header line of a set. Options unknown
+// to the package, e.g. the ones of newer ipset versions, are
+// skipped with their values.
+func parseHeaderLine(s string) (Header, error) {
+	var (
+		h     Header
+		words = strings.Fields(s)
+	)
+	isKey := func(w string) bool {
+		switch w {
+		case _family, _hashsize, _maxelem, _netmask, _markmask, _range, _size, _timeout,
+			_bucketsize, _initval, _counters, _comment, _skbinfo, _forceadd:
+			return true
+		}
+		return false
+	}
+
+	for i := 0; i < len(words); i++ {
+		key := words[i]
+		switch key {
+		case _counters:
+			h.Counters = true
+			continue
+		case _comment:
+			h.Comment = true
+			continue
+		case _skbinfo:
+			h.Skbinfo = true
+			continue
+		case _forceadd:
+			h.Forceadd = true
+			continue
+		}
+
+		if i+1 == len(words) || isKey(words[i+1]) {
+			if isKey(key) {
+				return h, fmt.Errorf("missing value of %s", key)
+			}
+			continue
+		}
+		i++
+		value := words[i]
+
+		var (
+			n   uint64
+			err error
+		)
+		switch key {
+		case _family:
+			h.Family = NetFamily(value)
+		case _range:
+			h.Range = value
+		case _hashsize, _maxelem, _size, _timeout:
+			if n, err = strconv.ParseUint(value, 10, 32); err != nil {
+				break
+			}
+			switch key {
+			case _hashsize:
+				h.HashSize = uint(n)
+			case _maxelem:
+				h.MaxElem = uint(n)
+			case _size:
+				h.Size = uint(n)
+			case _timeout:
+				h.Timeout, h.HasTimeout = time.Duration(n)*time.Second, true
+			}
+		case _netmask:
+			if n, err = strconv.ParseUint(value, 10, 8); err == nil {
+				h.Netmask = byte(n)
+			}
+		case _markmask:
+			if n, err = strconv.ParseUint(value, 0, 32); err == nil {
+				h.Markmask = uint32(n)
+			}
+		case _bucketsize:
+			if n, err = strconv.ParseUint(value, 10, 8); err == nil {
+				h.Bucketsize = uint8(n)
+			}
+		case _initval:
+			if n, err = strconv.ParseUint(value, 0, 32); err == nil {
+				h.Initval = uint32(n)
+			}
+		}
+		if err != nil {
+			return h, fmt.Errorf("invalid value %s of %s", value, key)
+		}
+	}
+	return h, nil
+}
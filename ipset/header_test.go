@@ -0,0 +1,78 @@
+package ipset
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Header_Parse(t *testing.T) {
+	t.Parallel()
+
+	h, err := parseHeaderLine("family inet6 hashsize 1024 maxelem 100000 bucketsize 12 initval 0x5f3c timeout 10800 counters comment skbinfo forceadd")
+	require.Nil(t, err)
+	assert.Equal(t, Header{
+		Family:     Inet6,
+		HashSize:   1024,
+		MaxElem:    100000,
+		Bucketsize: 12,
+		Initval:    0x5f3c,
+		Timeout:    10800 * time.Second,
+		HasTimeout: true,
+		Counters:   true,
+		Comment:    true,
+		Skbinfo:    true,
+		Forceadd:   true,
+	}, h)
+	assert.Equal(t, "family inet6 hashsize 1024 maxelem 100000 bucketsize 12 initval 0x00005f3c timeout 10800 counters comment skbinfo forceadd", h.String())
+
+	// options of newer versions are skipped
+	h, err = parseHeaderLine("family inet hashsize 1024 foo 1 maxelem 10")
+	require.Nil(t, err)
+	assert.Equal(t, uint(10), h.MaxElem)
+
+	h, err = parseHeaderLine("range 192.168.0.0-192.168.255.255 netmask 24")
+	require.Nil(t, err)
+	assert.Equal(t, "192.168.0.0-192.168.255.255", h.Range)
+	assert.Equal(t, byte(24), h.Netmask)
+
+	h, err = parseHeaderLine("family inet markmask 0x000000ff hashsize 64 maxelem 65536")
+	require.Nil(t, err)
+	assert.Equal(t, uint32(0xff), h.Markmask)
+
+	h, err = parseHeaderLine("size 8 timeout 0")
+	require.Nil(t, err)
+	assert.Equal(t, uint(8), h.Size)
+	assert.True(t, h.HasTimeout)
+
+	for _, s := range []string{"hashsize x", "timeout", "netmask 256", "maxelem counters"} {
+		_, err = parseHeaderLine(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func Test_Header_Options(t *testing.T) {
+	t.Parallel()
+
+	r := &recorder{}
+	c := NewClient(r)
+	for _, tc := range []struct {
+		setType SetType
+		header  string
+		line    string
+	}{
+		{HashIp, "family inet6 hashsize 64 maxelem 10 timeout 60 comment",
+			"create foo hash:ip timeout 60 comment family inet6 hashsize 64 maxelem 10"},
+		{BitmapPort, "range 0-1024 counters", "create foo bitmap:port counters range 0-1024"},
+		{ListSet, "size 4", "create foo list:set size 4"},
+		{HashNet, "timeout 0", "create foo hash:net timeout 0"},
+	} {
+		h, err := parseHeaderLine(tc.header)
+		require.Nil(t, err)
+		_, err = c.New("foo", tc.setType, h.Options()...)
+		require.Nil(t, err)
+		assert.Equal(t, tc.line, r.lines[len(r.lines)-1])
+	}
+}
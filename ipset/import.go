@@ -0,0 +1,78 @@
+package ipset
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+func (s set) Import(r io.Reader, options ...Option) error {
+	return s.ImportContext(context.Background(), r, options...)
+}
+
+func (s set) ImportContext(ctx context.Context, r io.Reader, options ...Option) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("ipset: can't import to %s: %w", s.name, err)
+		}
+	}()
+
+	o := acquireOptions().apply(options...)
+	source, mode, exist := o.sourceName, o.createMode, o.exist
+	releaseOptions(o)
+
+	switch mode {
+	case "", CreateKeep, CreateSkip:
+		// the lines are restored to the set itself, so every line is
+		// checked first, a refused one leaves the set untouched
+		var buf bytes.Buffer
+		lines := newRewriteReader(r, source, s.name, mode != CreateSkip, true)
+		if _, err = io.Copy(&buf, lines); err != nil {
+			return
+		}
+		if lines.err != nil {
+			return lines.err
+		}
+		return s.restore(ctx, &buf, exist)
+	case CreateReplace:
+		return s.importReplace(ctx, r, source, exist)
+	}
+	return fmt.Errorf("%w: unknown create mode %s", ErrInvalidOption, mode)
+}
+
+// importReplace restores the save output to a temporary set, which
+// is swapped with the set, or renamed to it if the set doesn't
+// exist.
+func (s set) importReplace(ctx context.Context, r io.Reader, source string, exist bool) (err error) {
+	var name string
+	if name, err = tempName(s.name); err != nil {
+		return
+	}
+	tmp := &set{name: name, setType: s.setType, client: s.client}
+	renamed := false
+	defer func() {
+		if renamed {
+			return
+		}
+		// cleanup is not bound to ctx which may be done already
+		if e := s.client.destroy(context.Background(), tmp.name); e != nil && err == nil {
+			err = e
+		}
+	}()
+
+	lines := newRewriteReader(r, source, tmp.name, true, true)
+	if err = tmp.restore(ctx, lines, exist); err != nil {
+		return
+	}
+	if lines.err != nil {
+		return lines.err
+	}
+
+	if err = s.client.SwapContext(ctx, tmp.name, s.name); errors.Is(err, ErrSetNotFound) {
+		err = tmp.RenameContext(ctx, s.name)
+		renamed = err == nil
+	}
+	return
+}
@@ -0,0 +1,95 @@
+package ipset
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const prodDump = `create prod_block hash:net family inet hashsize 1024 maxelem 65536 comment
+add prod_block 10.0.0.0/8 comment "rfc1918"
+add prod_block 192.168.0.0/16
+`
+
+func Test_Set_Import(t *testing.T) {
+	t.Parallel()
+
+	c := NewClient(NewEmulator())
+	s := &set{name: "staging_block", setType: HashNet, client: c}
+
+	require.Nil(t, s.Import(strings.NewReader(prodDump)))
+	info, err := s.List()
+	require.Nil(t, err)
+	assert.True(t, info.Header.Comment)
+	require.Len(t, info.Members, 2)
+	assert.Equal(t, "rfc1918", info.Members[0].Comment)
+
+	// the set exists now
+	err = s.Import(strings.NewReader(prodDump))
+	assert.True(t, errors.Is(err, ErrSetExists))
+	require.Nil(t, s.Import(strings.NewReader(prodDump), Exist(true)))
+
+	require.Nil(t, s.Flush())
+	require.Nil(t, s.Import(strings.NewReader(prodDump), CreateMode(CreateSkip)))
+	info, err = s.List()
+	require.Nil(t, err)
+	assert.Len(t, info.Members, 2)
+
+	// a refused line leaves the set untouched
+	require.Nil(t, s.Flush())
+	err = s.Import(strings.NewReader(prodDump+"add other 1.1.1.1\n"), CreateMode(CreateSkip), Exist(true))
+	assert.Equal(t, `ipset: can't import to staging_block: line "add other 1.1.1.1" is not for set prod_block`, err.Error())
+	info, err = s.List()
+	require.Nil(t, err)
+	assert.Len(t, info.Members, 0)
+
+	err = s.Import(strings.NewReader(prodDump), SourceName("other"))
+	assert.Equal(t, `ipset: can't import to staging_block: line "create prod_block hash:net family inet hashsize 1024 maxelem 65536 comment" is not for set other`, err.Error())
+
+	err = s.Import(strings.NewReader(prodDump), CreateMode("merge"))
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+
+	// failing lines are reported by their numbers in the dump
+	err = s.Import(strings.NewReader("# dump\ncreate prod_block hash:net\nadd prod_block x\n"), CreateMode(CreateSkip))
+	var e *Error
+	require.True(t, errors.As(err, &e))
+	assert.Equal(t, 3, e.Line)
+}
+
+func Test_Set_Import_Replace(t *testing.T) {
+	t.Parallel()
+
+	c := NewClient(NewEmulator())
+	s, err := asSet(c.New("staging_block", HashNet, Timeout(0)))
+	require.Nil(t, err)
+	require.Nil(t, s.Add("172.16.0.0/12"))
+
+	require.Nil(t, s.Import(strings.NewReader(prodDump), CreateMode(CreateReplace)))
+	info, err := s.List()
+	require.Nil(t, err)
+	assert.True(t, info.Header.Comment)
+	assert.Equal(t, []string{"10.0.0.0/8 comment \"rfc1918\"", "192.168.0.0/16"}, info.Entries)
+	names, err := c.Names()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"staging_block"}, names)
+
+	// the set is created if it doesn't exist
+	other := &set{name: "other", setType: HashNet, client: c}
+	require.Nil(t, other.Import(strings.NewReader(prodDump), CreateMode(CreateReplace)))
+	names, err = c.Names()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"staging_block", "other"}, names)
+
+	// the set is kept if any line fails
+	err = s.Import(strings.NewReader(prodDump+"add prod_block x\n"), CreateMode(CreateReplace))
+	require.Error(t, err)
+	info, err = s.List()
+	require.Nil(t, err)
+	assert.Len(t, info.Members, 2)
+	names, err = c.Names()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"staging_block", "other"}, names)
+}
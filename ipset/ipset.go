@@ -17,11 +17,11 @@
 package ipset
 
 import (
-	"bytes"
+	"context"
 	"errors"
-	"fmt"
 	"io"
 	"os/exec"
+	"sync"
 )
 
 // Version of current package
@@ -30,7 +30,10 @@ const Version = "v1.0.0-alpha.2"
 const minMajorVersion = 6
 
 var (
+	// ipsetPath is the utility found by Check, it's guarded by
+	// pathMu
 	ipsetPath string
+	pathMu    sync.RWMutex
 	// ErrNotFound is returned if there is no ipset found in os path
 	ErrNotFound = errors.New("ipset utility not found")
 	// ErrVersionNotSupported is returned if ipset's version is not bigger than v6.0
@@ -38,10 +41,45 @@ var (
 )
 
 var (
-	execCommand  = exec.Command
+	execCommand  = exec.CommandContext
 	execLookPath = exec.LookPath
 )
 
+// UseNetlink makes the package level functions talk to the kernel
+// through the NFNL_SUBSYS_IPSET netlink protocol directly instead
+// of forking the ipset utility for every command, and Check is not
+// required anymore. It should be called before using any set, and
+// ErrNetlinkNotSupported is returned on platforms other than linux.
+func UseNetlink() error {
+	b, err := NewNetlinkBackend()
+	if err != nil {
+		return err
+	}
+	useBackend(b)
+	return nil
+}
+
+// UseExec makes the package level functions fork the ipset utility
+// for every command, which is the default behavior.
+func UseExec() {
+	useBackend(ExecBackend{})
+}
+
+// useBackend replaces the backend of the default client. The detected
+// version belongs to the old backend, so it's detected again.
+func useBackend(b Backend) {
+	defaultClient.mu.Lock()
+	defaultClient.backendMu.Lock()
+	old := defaultClient.backend
+	defaultClient.backend, defaultClient.version = b, nil
+	defaultClient.backendMu.Unlock()
+	defaultClient.mu.Unlock()
+
+	if nb, ok := old.(*NetlinkBackend); ok {
+		_ = nb.Close()
+	}
+}
+
 // IPSet is abstract of ipset
 type IPSet interface {
 	// List dumps header data and the entries for the set to an
@@ -88,146 +126,266 @@ type IPSet interface {
 	SaveToFile(filename string, options ...Option) error
 
 	// Restore restores a saved session from io.Reader generated by
-	// save. Set exist to true to ignore exist error.
+	// save. Set exist to true to ignore exist error. The input is
+	// streamed to one restore command, a failing line is reported by
+	// the Line of the returned Error.
 	Restore(r io.Reader, exist ...bool) error
 
 	// RestoreFromFile restores a saved session from a specific file
-	// generated by save. Set exist to true to ignore exist error.
+	// generated by save or SaveToFile. The file is read through first
+	// and refused if it has a checksum which doesn't match, then it's
+	// rewound to be restored. Set exist to true to ignore exist
+	// error.
 	RestoreFromFile(filename string, exist ...bool) error
 }
 
+// The sets returned by New and Open implement the interfaces below
+// besides IPSet, which can be asserted from an IPSet, e.g.
+//
+//      results, err := set.(ipset.Batcher).AddMany(entries)
+//
+// They are kept apart so that other implementations of IPSet, e.g.
+// mocks, don't have to implement them.
+
+// IPSetContext holds the methods of IPSet which take a ctx. They're
+// like their counterparts, but the command is given up once ctx is
+// done. The returned error wraps ctx.Err() then, which can be told
+// apart from the failures of ipset by errors.Is.
+type IPSetContext interface {
+	ListContext(ctx context.Context, options ...Option) (*Info, error)
+	ListToFileContext(ctx context.Context, filename string, options ...Option) error
+	RenameContext(ctx context.Context, newName string) error
+	AddContext(ctx context.Context, entry string, options ...Option) error
+	DelContext(ctx context.Context, entry string, options ...Option) error
+	TestContext(ctx context.Context, entry string) (bool, error)
+	FlushContext(ctx context.Context) error
+	DestroyContext(ctx context.Context) error
+	SaveContext(ctx context.Context, options ...Option) (io.Reader, error)
+	SaveToFileContext(ctx context.Context, filename string, options ...Option) error
+	RestoreContext(ctx context.Context, r io.Reader, exist ...bool) error
+	RestoreFromFileContext(ctx context.Context, filename string, exist ...bool) error
+}
+
+// EntryStreamer reads a set without holding it in memory at once by
+// backends which are Streamers.
+type EntryStreamer interface {
+	// ForEachEntry calls fn with the entries of the set one by one
+	// while they're listed. The listing stops at the first error of
+	// fn, which is returned unless it's ErrStop.
+	ForEachEntry(fn func(*Entry) error, options ...Option) error
+	ForEachEntryContext(ctx context.Context, fn func(*Entry) error, options ...Option) error
+
+	// SaveTo dumps the set data to w in a format that restore can
+	// read. The output is copied while it's produced.
+	SaveTo(w io.Writer, options ...Option) error
+	SaveToContext(ctx context.Context, w io.Writer, options ...Option) error
+}
+
+// Batcher adds or deletes many entries through one restore process.
+type Batcher interface {
+	// AddMany adds the entries through one restore process, the
+	// options are applied to every entry. The results tell which
+	// entries failed and why, and an error is returned if any
+	// entry failed. The Exist option ignores the entries already
+	// added.
+	AddMany(entries []string, options ...Option) ([]EntryResult, error)
+	AddManyContext(ctx context.Context, entries []string, options ...Option) ([]EntryResult, error)
+
+	// DelMany deletes the entries through one restore process like
+	// AddMany. The Exist option ignores the entries not in the set.
+	DelMany(entries []string, options ...Option) ([]EntryResult, error)
+	DelManyContext(ctx context.Context, entries []string, options ...Option) ([]EntryResult, error)
+}
+
+// Replacer replaces all entries of a set atomically.
+type Replacer interface {
+	// Replace replaces all entries of the set atomically. The entries
+	// are added to a temporary set with the same type and header
+	// like AddMany, which is swapped with the set and destroyed then.
+	// The temporary set is destroyed if any step fails.
+	Replace(entries []string, options ...Option) error
+	ReplaceContext(ctx context.Context, entries []string, options ...Option) error
+
+	// ReplaceFrom replaces all entries of the set atomically like
+	// Replace with the save output of the set, its create line is
+	// skipped. Set exist to true to ignore exist error.
+	ReplaceFrom(r io.Reader, exist ...bool) error
+	ReplaceFromContext(ctx context.Context, r io.Reader, exist ...bool) error
+}
+
+// Restorer restores save output in other ways than Restore.
+type Restorer interface {
+	// RestoreAtomic restores the save output of the set all or
+	// nothing. The entries of the set are copied to a temporary set
+	// with the same type and header, then the lines are restored to
+	// it and it's swapped with the set. Entries added to the set
+	// meanwhile are lost. The set is left untouched if any line
+	// fails, and the Line of the returned Error tells which one.
+	RestoreAtomic(r io.Reader, exist ...bool) error
+	RestoreAtomicContext(ctx context.Context, r io.Reader, exist ...bool) error
+
+	// RestoreReport restores the lines of r and carries on after
+	// failed lines. Lines are parsed first and lines of other sets
+	// are refused, then the rest are restored through as few
+	// processes as possible. The result tells how many lines
+	// are applied and which are rejected for what reasons. An error
+	// is returned only if the restore can't go on, e.g. the process
+	// has no permission.
+	RestoreReport(r io.Reader, exist ...bool) (*RestoreResult, error)
+	RestoreReportContext(ctx context.Context, r io.Reader, exist ...bool) (*RestoreResult, error)
+
+	// Import restores the save output of another set to the set.
+	// The lines of the set named by the SourceName option, or the
+	// set of the first line, are renamed to the set and lines of
+	// other sets are refused. The CreateMode option tells what to do
+	// with the create line, the Exist option is supported too.
+	Import(r io.Reader, options ...Option) error
+	ImportContext(ctx context.Context, r io.Reader, options ...Option) error
+
+	// RestoreFromFileWith is like RestoreFromFile but takes the Exist
+	// and RequireChecksum options, the latter refuses files without
+	// checksum.
+	RestoreFromFileWith(filename string, options ...Option) error
+	RestoreFromFileWithContext(ctx context.Context, filename string, options ...Option) error
+}
+
+// Reconciler makes the entries of a set the desired ones.
+type Reconciler interface {
+	// Reconcile lists the entries of the set, compares them with the
+	// desired ones and applies the difference in one restore batch,
+	// so the set is never emptied like Flush followed by adding
+	// everything. Elements are compared as listed by ipset, e.g.
+	// 10.1.2.3/8 of hash:net is 10.0.0.0/8. Timeouts are compared
+	// only with the TimeoutTolerance option.
+	Reconcile(desired []Entry, options ...Option) (*ReconcileReport, error)
+	ReconcileContext(ctx context.Context, desired []Entry, options ...Option) (*ReconcileReport, error)
+
+	// ReconcilePlan computes the changes of Reconcile without
+	// applying them.
+	ReconcilePlan(desired []Entry, options ...Option) (*ReconcileReport, error)
+	ReconcilePlanContext(ctx context.Context, desired []Entry, options ...Option) (*ReconcileReport, error)
+}
+
 // New create a set identified with setname and specified type.
 // The type may require type specific options. If the Exist
 // option is specified, ipset ignores the error when the same set
 // (setname and create parameters are identical) already exists.
 func New(name string, setType SetType, options ...Option) (IPSet, error) {
-	c := getCmd(_create, name, setType, string(setType))
-	defer putCmd(c)
-	if err := c.exec(options...); err != nil {
-		return nil, err
-	}
-	return &set{name, setType}, nil
+	return defaultClient.New(name, setType, options...)
+}
+
+// Open returns the existing set identified with setname, its type
+// and header are discovered by listing the set. An error wrapping
+// ErrSetNotFound is returned if the set doesn't exist.
+func Open(name string) (IPSet, error) {
+	return defaultClient.Open(name)
+}
+
+// ListAll dumps header data and the entries of all sets. The
+// Resolve option can be used to force action lookups(which may be
+// slow).
+func ListAll(options ...Option) ([]*Info, error) {
+	return defaultClient.ListAll(options...)
+}
+
+// ListTerse dumps header data of all sets without their entries.
+func ListTerse() ([]*Info, error) {
+	return defaultClient.ListTerse()
+}
+
+// Names returns the names of all sets.
+func Names() ([]string, error) {
+	return defaultClient.Names()
 }
 
 // Flush all entries from the specified set or flush all sets if none
 // is given.
 func Flush(names ...string) error {
-	if len(names) > 0 {
-		for _, name := range names {
-			if err := flush(name); err != nil {
-				return err
-			}
-		}
-	}
-	return flushAll()
-}
-
-// flush flushes specific set
-func flush(name string) error {
-	if out, err := execCommand(ipsetPath, _flush, name).
-		CombinedOutput(); err != nil {
-		return fmt.Errorf("ipset: can't flush set %s: %s", name, out)
-	}
-	return nil
+	return defaultClient.Flush(names...)
 }
 
-// flushAll flushes all set
-func flushAll() error {
-	if out, err := execCommand(ipsetPath, _flush).
-		CombinedOutput(); err != nil {
-		return fmt.Errorf("ipset: can't flush all set: %s", out)
-	}
-	return nil
+// FlushContext is like Flush but the commands are given up once ctx
+// is done, and the returned error wraps ctx.Err().
+func FlushContext(ctx context.Context, names ...string) error {
+	return defaultClient.FlushContext(ctx, names...)
 }
 
 // Destroy removes the specified set or all the sets if none is given.
 // If the set has got reference(s), nothing is done and no set destroyed.
 func Destroy(names ...string) error {
-	if len(names) > 0 {
-		for _, name := range names {
-			if err := destroy(name); err != nil {
-				return err
-			}
-		}
-	}
-	return destroyAll()
-}
-
-// destroy removes specific set
-func destroy(name string) error {
-	if out, err := execCommand(ipsetPath, _destroy, name).
-		CombinedOutput(); err != nil {
-		return fmt.Errorf("ipset: can't destroy set %s: %s", name, out)
-	}
-	return nil
+	return defaultClient.Destroy(names...)
 }
 
-// destroyAll removes all set
-func destroyAll() error {
-	if out, err := execCommand(ipsetPath, _destroy).
-		CombinedOutput(); err != nil {
-		return fmt.Errorf("ipset: can't destroy all set: %s", out)
-	}
-	return nil
+// DestroyContext is like Destroy but the commands are given up once
+// ctx is done, and the returned error wraps ctx.Err().
+func DestroyContext(ctx context.Context, names ...string) error {
+	return defaultClient.DestroyContext(ctx, names...)
 }
 
 // Swap swaps the content of two sets, or in another words,
 // exchange the action of two sets. The referred sets must
 // exist and compatible type of sets can be swapped only.
 func Swap(from, to string) error {
-	if out, err := execCommand(ipsetPath, _swap, from, to).
-		CombinedOutput(); err != nil {
-		return fmt.Errorf("ipset: can't swap from %s to %s: %s", from, to, out)
-	}
-	return nil
+	return defaultClient.Swap(from, to)
 }
 
-//Check checks whether there is an ipset command in the system.
-// If so, check if the version is legal.
-func Check() error {
-	if ipsetPath != "" {
-		return nil
-	}
+// SwapContext is like Swap but the command is given up once ctx is
+// done, and the returned error wraps ctx.Err().
+func SwapContext(ctx context.Context, from, to string) error {
+	return defaultClient.SwapContext(ctx, from, to)
+}
 
-	path, err := execLookPath("ipset")
-	if err != nil {
-		return ErrNotFound
-	}
-	ipsetPath = path
+// SaveAll dumps all the sets in a format that RestoreAll can read,
+// entries of list:set sets are put last.
+func SaveAll(options ...Option) (io.Reader, error) {
+	return defaultClient.SaveAll(options...)
+}
 
-	var supported bool
-	if supported, err = isSupported(); err != nil {
-		return fmt.Errorf("ipset: can't check version : %s", err)
-	}
+// SaveAllContext is like SaveAll but the command is given up once ctx
+// is done.
+func SaveAllContext(ctx context.Context, options ...Option) (io.Reader, error) {
+	return defaultClient.SaveAllContext(ctx, options...)
+}
 
-	if supported {
-		return nil
-	}
-	return ErrVersionNotSupported
+// SaveAllTo dumps all the sets to w while they're listed.
+func SaveAllTo(w io.Writer, options ...Option) error {
+	return defaultClient.SaveAllTo(w, options...)
 }
 
-func isSupported() (bool, error) {
-	out, err := execCommand(ipsetPath, _version).
-		CombinedOutput()
+// SaveAllToFile dumps all the sets to the file.
+func SaveAllToFile(filename string, options ...Option) error {
+	return defaultClient.SaveAllToFile(filename, options...)
+}
 
-	if err == nil {
-		return getMajorVersion(out) >= minMajorVersion, nil
-	}
+// RestoreAll restores the sets dumped by SaveAll or ipset save.
+func RestoreAll(r io.Reader, options ...Option) error {
+	return defaultClient.RestoreAll(r, options...)
+}
 
-	return false, err
+// RestoreAllContext is like RestoreAll but the command is given up
+// once ctx is done.
+func RestoreAllContext(ctx context.Context, r io.Reader, options ...Option) error {
+	return defaultClient.RestoreAllContext(ctx, r, options...)
 }
 
-func getMajorVersion(version []byte) int {
-	vIndex := bytes.IndexByte(version, 'v')
-	dotIndex := bytes.IndexByte(version, '.')
-	var majorVersion int
-	for i := vIndex + 1; i < dotIndex; i++ {
-		if c := version[i]; c >= '0' && c <= '9' {
-			majorVersion = majorVersion*10 + int(c-'0')
-		} else {
-			return 0
-		}
-	}
+// RestoreAllFromFile restores the sets dumped to the file.
+func RestoreAllFromFile(filename string, options ...Option) error {
+	return defaultClient.RestoreAllFromFile(filename, options...)
+}
 
-	return majorVersion
+//Check checks whether there is an ipset command in the system.
+// If so, check if the version is legal.
+func Check() error {
+	return defaultClient.Check()
+}
+
+// CheckContext is like Check but the command is given up once ctx
+// is done, and the returned error wraps ctx.Err().
+func CheckContext(ctx context.Context) error {
+	return defaultClient.CheckContext(ctx)
+}
+
+func getMajorVersion(version []byte) int {
+	v, _ := ParseVersion(version)
+	return v.Major
 }
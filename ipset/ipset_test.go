@@ -1,8 +1,11 @@
 package ipset
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -91,6 +94,85 @@ func Test_New(t *testing.T) {
 	})
 }
 
+func Test_Open(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		setupCmd()
+		defer teardownCmd()
+
+		s, err := Open("foo")
+		require.Nil(t, err)
+		assert.Equal(t, "foo", s.Name())
+		assert.Equal(t, HashIp, s.(*set).setType)
+		assert.Equal(t, uint(65536), s.(*set).header.MaxElem)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		setupCmd(flag)
+		defer teardownCmd()
+
+		_, err := Open("foo")
+		require.Error(t, err)
+		assert.Equal(t, "ipset: can't open foo: fake error", err.Error())
+	})
+}
+
+func Test_ListAll(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		setupCmd()
+		defer teardownCmd()
+
+		infos, err := ListAll()
+		require.Nil(t, err)
+		require.Len(t, infos, 2)
+		assert.Equal(t, "foo", infos[0].Name)
+		assert.Equal(t, []string{"1.1.1.1"}, infos[0].Entries)
+		assert.Equal(t, "bar", infos[1].Name)
+		assert.Equal(t, HashNetPort, infos[1].SetType)
+		assert.Equal(t, Inet6, infos[1].Header.Family)
+		require.Len(t, infos[1].Members, 2)
+		assert.Equal(t, uint16(53), infos[1].Members[1].Port)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		setupCmd(flag)
+		defer teardownCmd()
+
+		_, err := ListAll()
+		require.Error(t, err)
+		assert.Equal(t, "ipset: can't list all sets: fake error", err.Error())
+	})
+}
+
+func Test_ListTerse(t *testing.T) {
+	setupCmd()
+	defer teardownCmd()
+
+	infos, err := ListTerse()
+	require.Nil(t, err)
+	require.Len(t, infos, 2)
+	assert.Nil(t, infos[1].Members)
+}
+
+func Test_Names(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		setupCmd()
+		defer teardownCmd()
+
+		names, err := Names()
+		require.Nil(t, err)
+		assert.Equal(t, []string{"foo", "bar"}, names)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		setupCmd(flag)
+		defer teardownCmd()
+
+		_, err := Names()
+		require.Error(t, err)
+		assert.Equal(t, "ipset: can't list set names: fake error", err.Error())
+	})
+}
+
 func Test_Flush(t *testing.T) {
 	t.Run("all", func(t *testing.T) {
 		t.Run("success", func(t *testing.T) {
@@ -194,3 +276,73 @@ func Test_Swap(t *testing.T) {
 			err.Error())
 	})
 }
+
+func Test_Context(t *testing.T) {
+	setupHangCmd()
+	defer teardownCmd()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	assert.True(t, errors.Is(FlushContext(ctx, "foo"), context.DeadlineExceeded))
+	assert.True(t, errors.Is(DestroyContext(ctx, "foo"), context.DeadlineExceeded))
+	assert.True(t, errors.Is(SwapContext(ctx, "foo", "bar"), context.DeadlineExceeded))
+
+	setupLookPath()
+	defer teardownLookPath()
+	assert.True(t, errors.Is(CheckContext(ctx), context.DeadlineExceeded))
+}
+
+func Test_UseExec(t *testing.T) {
+	b, err := newNetlinkBackend(&fakeConn{replies: []fakeReply{
+		{payloads: [][]byte{fixture(t, fixtureProtocol)}},
+	}})
+	require.Nil(t, err)
+	useBackend(b)
+	defer UseExec()
+
+	v, err := defaultClient.Version()
+	require.Nil(t, err)
+	assert.Equal(t, "v7.0", v.String())
+	require.NotNil(t, defaultClient.version)
+
+	// the version of netlink is not kept for exec
+	UseExec()
+	assert.Equal(t, ExecBackend{}, defaultClient.Backend())
+	assert.Nil(t, defaultClient.version)
+}
+
+func Test_UseBackend_Race(t *testing.T) {
+	defer UseExec()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			useBackend(NewEmulator())
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		_, _ = Names()
+		_, _ = defaultClient.Version()
+	}
+	<-done
+}
+
+func Test_OptionalInterfaces(t *testing.T) {
+	s, err := NewClient(NewEmulator()).New("foo", HashIp)
+	require.Nil(t, err)
+
+	_, ok := s.(IPSetContext)
+	assert.True(t, ok)
+	_, ok = s.(EntryStreamer)
+	assert.True(t, ok)
+	_, ok = s.(Batcher)
+	assert.True(t, ok)
+	_, ok = s.(Replacer)
+	assert.True(t, ok)
+	_, ok = s.(Restorer)
+	assert.True(t, ok)
+	_, ok = s.(Reconciler)
+	assert.True(t, ok)
+}
@@ -0,0 +1,215 @@
+package ipset
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// setData is the content of a set which is rendered into the
+// output of list and save command.
+type setData struct {
+	name       string
+	setType    SetType
+	revision   uint8
+	header     createArgs
+	memSize    uint32
+	references uint32
+	members    []member
+}
+
+// member is an entry of a set with its extensions.
+type member struct {
+	elem string
+	ext  entryArgs
+}
+
+// writeList writes the set data in the format of list command,
+// members are left out if terse is set.
+func writeList(w io.Writer, d *setData, terse bool) error {
+	var b strings.Builder
+	b.WriteString("Name: " + d.name + "\n")
+	b.WriteString("Type: " + string(d.setType) + "\n")
+	b.WriteString("Revision: " + i2str(uint64(d.revision)) + "\n")
+	b.WriteString("Header: " + formatHeader(d.setType, &d.header) + "\n")
+	b.WriteString("Size in memory: " + i2str(uint64(d.memSize)) + "\n")
+	b.WriteString("References: " + i2str(uint64(d.references)) + "\n")
+	b.WriteString("Number of entries: " + i2str(uint64(len(d.members))) + "\n")
+	if terse {
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+	b.WriteString("Members:\n")
+	for _, m := range d.members {
+		b.WriteString(m.elem + formatExt(&d.header, &m.ext) + "\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeSets writes the data of sets in the format of list or save
+// command, or only their names for list -name.
+func writeSets(w io.Writer, sets []*setData, req *request) error {
+	mode := req.output
+	if mode == "" {
+		mode = OutputPlain
+		if req.action == _save {
+			mode = OutputSave
+		}
+	}
+	if mode == OutputXML {
+		return fmt.Errorf("Syntax error: xml output is not supported")
+	}
+
+	for i, d := range sets {
+		if req.sorted {
+			sorted := *d
+			sorted.members = append([]member(nil), d.members...)
+			sort.SliceStable(sorted.members, func(i, j int) bool {
+				return sorted.members[i].elem < sorted.members[j].elem
+			})
+			d = &sorted
+		}
+
+		var err error
+		switch {
+		case mode == OutputSave:
+			err = writeSave(w, d)
+		case req.names:
+			_, err = io.WriteString(w, d.name+"\n")
+		default:
+			if i > 0 {
+				_, _ = io.WriteString(w, "\n")
+			}
+			err = writeList(w, d, req.terse)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSave writes the set data in the format of save command,
+// which can be read by restore command.
+func writeSave(w io.Writer, d *setData) error {
+	var b strings.Builder
+	b.WriteString(_create + " " + d.name + " " + string(d.setType))
+	if h := formatHeader(d.setType, &d.header); h != "" {
+		b.WriteString(" " + h)
+	}
+	b.WriteByte('\n')
+	for _, m := range d.members {
+		b.WriteString(_add + " " + d.name + " " + m.elem + formatExt(&d.header, &m.ext) + "\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// formatHeader formats the create options of a set.
+func formatHeader(setType SetType, h *createArgs) string {
+	var words []string
+	add := func(w ...string) { words = append(words, w...) }
+
+	if method(setType) == "hash" && setType != HashMac {
+		family := h.family
+		if family == "" {
+			family = Inet
+		}
+		add(_family, string(family))
+	} else if setType == "" && h.family != "" {
+		// the family is kept as is if the type is unknown
+		add(_family, string(h.family))
+	}
+	if h.ipRange != "" {
+		add(_range, h.ipRange)
+	}
+	if h.hashSize != 0 {
+		add(_hashsize, i2str(uint64(h.hashSize)))
+	}
+	if h.maxElem != 0 {
+		add(_maxelem, i2str(uint64(h.maxElem)))
+	}
+	if h.netmask != 0 {
+		add(_netmask, i2str(uint64(h.netmask)))
+	}
+	if h.markmask != 0 {
+		add(_markmask, fmt.Sprintf("0x%08x", h.markmask))
+	}
+	if h.size != 0 {
+		add(_size, i2str(uint64(h.size)))
+	}
+	if h.bucketsize != 0 {
+		add(_bucketsize, i2str(uint64(h.bucketsize)))
+	}
+	if h.initval != 0 {
+		add(_initval, fmt.Sprintf("0x%08x", h.initval))
+	}
+	if h.hasTimeout {
+		add(_timeout, i2str(uint64(h.timeout)))
+	}
+	if h.counters {
+		add(_counters)
+	}
+	if h.comment {
+		add(_comment)
+	}
+	if h.skbinfo {
+		add(_skbinfo)
+	}
+	if h.forceadd {
+		add(_forceadd)
+	}
+	return strings.Join(words, " ")
+}
+
+// formatExt formats the extensions of an entry, which are
+// enabled by the header of its set.
+func formatExt(h *createArgs, e *entryArgs) string {
+	var b strings.Builder
+	words := appendExt(nil, h, e)
+	for i, w := range words {
+		if i > 0 && words[i-1] == _comment {
+			w = "\"" + w + "\""
+		}
+		b.WriteString(" " + w)
+	}
+	return b.String()
+}
+
+// appendExt appends the words of the extensions of an entry, which
+// are enabled by the header of its set.
+func appendExt(words []string, h *createArgs, e *entryArgs) []string {
+	if h.hasTimeout {
+		words = append(words, _timeout, i2str(uint64(e.timeout)))
+	}
+	if e.nomatch {
+		words = append(words, _nomatch)
+	}
+	if e.wildcard {
+		words = append(words, _wildcard)
+	}
+	if h.counters {
+		words = append(words, _packets, i2str(e.packets), _bytes, i2str(e.bytes))
+	}
+	if h.comment && e.comment != "" {
+		words = append(words, _comment, e.comment)
+	}
+	if h.skbinfo {
+		if e.skbmark != 0 || (e.skbmask != 0 && e.skbmask != 0xffffffff) {
+			mark := fmt.Sprintf("0x%x", e.skbmark)
+			if e.skbmask != 0xffffffff {
+				mark += fmt.Sprintf("/0x%x", e.skbmask)
+			}
+			words = append(words, _skbmark, mark)
+		}
+		if e.skbprio != 0 {
+			words = append(words, _skbprio, fmt.Sprintf("%x:%x", e.skbprio>>16, e.skbprio&0xffff))
+		}
+		if e.skbqueue != 0 {
+			words = append(words, _skbqueue, i2str(uint64(e.skbqueue)))
+		}
+	}
+	return words
+}
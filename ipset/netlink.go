@@ -0,0 +1,1135 @@
+package ipset
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// ErrNetlinkNotSupported is returned if the netlink backend is
+// used on a platform other than linux.
+var ErrNetlinkNotSupported = errors.New("ipset: netlink is only supported on linux")
+
+// Netlink protocol constants, see linux/netlink.h and
+// linux/netfilter/ipset/ip_set.h.
+const (
+	nlmsgHdrLen  = 16
+	nlmsgError   = 0x2
+	nlmsgDone    = 0x3
+	nlmFRequest  = 0x1
+	nlmFAck      = 0x4
+	nlmFDump     = 0x300
+	nlmFExcl     = 0x200
+	nlaFNested   = 1 << 15
+	nlaFNetOrder = 1 << 14
+	nlaTypeMask  = ^uint16(nlaFNested | nlaFNetOrder)
+
+	nfnlSubsysIPSet  = 6
+	nfnetlinkV0      = 0
+	afInet           = 2
+	nfprotoUnspec    = 0
+	nfprotoIPv4      = 2
+	nfprotoIPv6      = 10
+	ipsetProtocol    = 7
+	ipsetProtocolMin = 6
+)
+
+// Commands of ipset netlink messages
+const (
+	ipsetCmdProtocol = iota + 1
+	ipsetCmdCreate
+	ipsetCmdDestroy
+	ipsetCmdFlush
+	ipsetCmdRename
+	ipsetCmdSwap
+	ipsetCmdList
+	ipsetCmdSave
+	ipsetCmdAdd
+	ipsetCmdDel
+	ipsetCmdTest
+	ipsetCmdHeader
+	ipsetCmdType
+)
+
+// Command level attributes
+const (
+	ipsetAttrProtocol = iota + 1
+	ipsetAttrSetname
+	ipsetAttrTypename
+	ipsetAttrRevision
+	ipsetAttrFamily
+	ipsetAttrFlags
+	ipsetAttrData
+	ipsetAttrADT
+	ipsetAttrLineno
+	ipsetAttrProtocolMin
+
+	ipsetAttrSetname2    = ipsetAttrTypename
+	ipsetAttrRevisionMin = ipsetAttrProtocolMin
+)
+
+// Attributes of create, add, del and test data
+const (
+	ipsetAttrIP = iota + 1
+	ipsetAttrIPTo
+	ipsetAttrCidr
+	ipsetAttrPort
+	ipsetAttrPortTo
+	ipsetAttrTimeout
+	ipsetAttrProto
+	ipsetAttrCadtFlags
+	ipsetAttrCadtLineno
+	ipsetAttrMark
+	ipsetAttrMarkmask
+
+	ipsetAttrCadtMax = 16
+)
+
+// Create only attributes
+const (
+	ipsetAttrInitval = ipsetAttrCadtMax + 1 + iota
+	ipsetAttrHashsize
+	ipsetAttrMaxelem
+	ipsetAttrNetmask
+	ipsetAttrBucketsize
+	ipsetAttrResize
+	ipsetAttrSize
+	ipsetAttrElements
+	ipsetAttrReferences
+	ipsetAttrMemsize
+)
+
+// Add, del and test only attributes
+const (
+	ipsetAttrEther = ipsetAttrCadtMax + 1 + iota
+	ipsetAttrName
+	ipsetAttrNameref
+	ipsetAttrIP2
+	ipsetAttrCidr2
+	ipsetAttrIP2To
+	ipsetAttrIface
+	ipsetAttrBytes
+	ipsetAttrPackets
+	ipsetAttrComment
+	ipsetAttrSkbmark
+	ipsetAttrSkbprio
+	ipsetAttrSkbqueue
+)
+
+// Attributes of ip addresses
+const (
+	ipsetAttrIPAddrIPv4 = iota + 1
+	ipsetAttrIPAddrIPv6
+)
+
+// Flags of IPSET_ATTR_CADT_FLAGS
+const (
+	ipsetFlagBefore = 1 << iota
+	ipsetFlagPhysdev
+	ipsetFlagNomatch
+	ipsetFlagWithCounters
+	ipsetFlagWithComment
+	ipsetFlagWithForceadd
+	ipsetFlagWithSkbinfo
+	ipsetFlagIfaceWildcard
+)
+
+// nlAttrs builds netlink attributes.
+type nlAttrs struct {
+	b []byte
+}
+
+func (a *nlAttrs) put(typ uint16, data []byte) {
+	var hdr [4]byte
+	nativeEndian.PutUint16(hdr[:2], uint16(4+len(data)))
+	nativeEndian.PutUint16(hdr[2:], typ)
+	a.b = append(a.b, hdr[:]...)
+	a.b = append(a.b, data...)
+	for len(a.b)%4 != 0 {
+		a.b = append(a.b, 0)
+	}
+}
+
+func (a *nlAttrs) u8(typ uint16, v uint8) {
+	a.put(typ, []byte{v})
+}
+
+func (a *nlAttrs) be16(typ uint16, v uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	a.put(typ|nlaFNetOrder, b[:])
+}
+
+func (a *nlAttrs) be32(typ uint16, v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	a.put(typ|nlaFNetOrder, b[:])
+}
+
+func (a *nlAttrs) be64(typ uint16, v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	a.put(typ|nlaFNetOrder, b[:])
+}
+
+func (a *nlAttrs) str(typ uint16, s string) {
+	a.put(typ, append([]byte(s), 0))
+}
+
+func (a *nlAttrs) ip(typ uint16, ip net.IP) {
+	var nested nlAttrs
+	if v4 := ip.To4(); v4 != nil {
+		nested.put(ipsetAttrIPAddrIPv4|nlaFNetOrder, v4)
+	} else {
+		nested.put(ipsetAttrIPAddrIPv6|nlaFNetOrder, ip.To16())
+	}
+	a.nest(typ, &nested)
+}
+
+func (a *nlAttrs) nest(typ uint16, nested *nlAttrs) {
+	a.put(typ|nlaFNested, nested.b)
+}
+
+// nlAttr is a parsed netlink attribute.
+type nlAttr struct {
+	typ  uint16
+	data []byte
+}
+
+func parseAttrs(b []byte) ([]nlAttr, error) {
+	var attrs []nlAttr
+	for len(b) >= 4 {
+		l := int(nativeEndian.Uint16(b[:2]))
+		if l < 4 || l > len(b) {
+			return nil, errors.New("Kernel error received: malformed netlink attribute")
+		}
+		attrs = append(attrs, nlAttr{nativeEndian.Uint16(b[2:4]) & nlaTypeMask, b[4:l]})
+		l = (l + 3) &^ 3
+		if l > len(b) {
+			break
+		}
+		b = b[l:]
+	}
+	return attrs, nil
+}
+
+func (a nlAttr) u8() uint8 {
+	if len(a.data) < 1 {
+		return 0
+	}
+	return a.data[0]
+}
+
+func (a nlAttr) be16() uint16 {
+	if len(a.data) < 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(a.data)
+}
+
+func (a nlAttr) be32() uint32 {
+	if len(a.data) < 4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(a.data)
+}
+
+func (a nlAttr) be64() uint64 {
+	if len(a.data) < 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(a.data)
+}
+
+func (a nlAttr) str() string {
+	if i := bytes.IndexByte(a.data, 0); i != -1 {
+		return string(a.data[:i])
+	}
+	return string(a.data)
+}
+
+func (a nlAttr) ip() net.IP {
+	attrs, err := parseAttrs(a.data)
+	if err != nil || len(attrs) == 0 {
+		return nil
+	}
+	return net.IP(append([]byte(nil), attrs[0].data...))
+}
+
+// nlMessage builds an ipset netlink message.
+type nlMessage struct {
+	cmd   uint8
+	flags uint16
+	nlAttrs
+}
+
+func newMessage(cmd uint8, protocol uint8) *nlMessage {
+	m := &nlMessage{cmd: cmd, flags: nlmFRequest | nlmFAck}
+	m.u8(ipsetAttrProtocol, protocol)
+	return m
+}
+
+// encode encodes the message with netlink header and nfgenmsg.
+func (m *nlMessage) encode(seq uint32) []byte {
+	b := make([]byte, nlmsgHdrLen+4, nlmsgHdrLen+4+len(m.b))
+	nativeEndian.PutUint32(b[0:4], uint32(nlmsgHdrLen+4+len(m.b)))
+	nativeEndian.PutUint16(b[4:6], nfnlSubsysIPSet<<8|uint16(m.cmd))
+	nativeEndian.PutUint16(b[6:8], m.flags)
+	nativeEndian.PutUint32(b[8:12], seq)
+	b[16] = afInet
+	b[17] = nfnetlinkV0
+	return append(b, m.b...)
+}
+
+// nlConn sends ipset netlink messages to the kernel.
+type nlConn interface {
+	// request sends an encoded message and returns the attributes
+	// payload of every reply message until the ack or the end of
+	// the dump. A kernel error is returned as nlError. The replies
+	// are given up once ctx is done.
+	request(ctx context.Context, msg []byte) ([][]byte, error)
+
+	// close closes the connection.
+	close() error
+}
+
+// nlError is an error code received from the kernel.
+type nlError int
+
+func (e nlError) Error() string {
+	return fmt.Sprintf("netlink error code %d", int(e))
+}
+
+// readReplies reads netlink messages of the sequence from b and
+// returns their payload after nfgenmsg. done reports whether the
+// ack or the end of dump is met.
+func readReplies(b []byte, seq uint32, payloads *[][]byte) (done bool, err error) {
+	for len(b) >= nlmsgHdrLen {
+		l := int(nativeEndian.Uint32(b[0:4]))
+		if l < nlmsgHdrLen || l > len(b) {
+			return true, errors.New("Kernel error received: malformed netlink message")
+		}
+		typ := nativeEndian.Uint16(b[4:6])
+		data := b[nlmsgHdrLen:l]
+		if nativeEndian.Uint32(b[8:12]) == seq {
+			switch typ {
+			case nlmsgError:
+				if len(data) < 4 {
+					return true, errors.New("Kernel error received: malformed netlink message")
+				}
+				if code := int32(nativeEndian.Uint32(data[:4])); code != 0 {
+					return true, nlError(-code)
+				}
+				return true, nil
+			case nlmsgDone:
+				return true, nil
+			default:
+				if len(data) >= 4 {
+					*payloads = append(*payloads, append([]byte(nil), data[4:]...))
+				}
+			}
+		}
+		l = (l + 3) &^ 3
+		if l > len(b) {
+			break
+		}
+		b = b[l:]
+	}
+	return false, nil
+}
+
+// setInfo is the type and family of an existing set.
+type setInfo struct {
+	setType SetType
+	family  NetFamily
+}
+
+// NetlinkBackend carries out ipset commands through the netlink
+// protocol of NFNL_SUBSYS_IPSET without forking the ipset utility.
+// It's safe for concurrent use and requires CAP_NET_ADMIN like the
+// ipset utility does.
+type NetlinkBackend struct {
+	conn     nlConn
+	protocol uint8
+	mu       sync.Mutex
+	seq      uint32
+	sets     map[string]setInfo
+}
+
+// NewNetlinkBackend connects to the kernel and negotiates the
+// protocol version. ErrNetlinkNotSupported is returned on platforms
+// other than linux.
+func NewNetlinkBackend() (*NetlinkBackend, error) {
+	conn, err := dialNetlink()
+	if err != nil {
+		return nil, err
+	}
+	r, err := newNetlinkBackend(conn)
+	if err != nil {
+		_ = conn.close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func newNetlinkBackend(conn nlConn) (*NetlinkBackend, error) {
+	r := &NetlinkBackend{conn: conn, protocol: ipsetProtocol, sets: map[string]setInfo{}}
+	replies, err := r.exec(context.Background(), newMessage(ipsetCmdProtocol, ipsetProtocol))
+	if err != nil {
+		return nil, fmt.Errorf("ipset: can't negotiate netlink protocol: %s", kernelError(ipsetCmdProtocol, "", err))
+	}
+	for _, reply := range replies {
+		attrs, err := parseAttrs(reply)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range attrs {
+			if a.typ == ipsetAttrProtocol && a.u8() < r.protocol {
+				r.protocol = a.u8()
+			}
+		}
+	}
+	if r.protocol < ipsetProtocolMin {
+		return nil, ErrVersionNotSupported
+	}
+	return r, nil
+}
+
+// Run parses the ipset command and carries it out through netlink
+// messages, the output and error messages are the same as the ipset
+// utility's.
+func (r *NetlinkBackend) Run(ctx context.Context, args []string, stdin io.Reader) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return []byte(err.Error()), err
+	}
+	req, err := parseRequest(args)
+	if err == nil {
+		var out bytes.Buffer
+		err = withFile(req, stdin, &out, func(stdin io.Reader, out io.Writer) error {
+			return r.do(ctx, req, stdin, out)
+		})
+		if err == nil {
+			return out.Bytes(), nil
+		}
+	}
+	return []byte(err.Error()), err
+}
+
+// Close closes the netlink socket.
+func (r *NetlinkBackend) Close() error {
+	return r.conn.close()
+}
+
+func (r *NetlinkBackend) do(ctx context.Context, req *request, stdin io.Reader, out io.Writer) error {
+	switch req.action {
+	case _version:
+		// the kernel has no version but the protocol, features are
+		// not gated by this one
+		_, err := fmt.Fprintf(out, "ipset v%d.0 (netlink), protocol version: %d\n", r.protocol, r.protocol)
+		return err
+	case _create:
+		return r.create(ctx, req)
+	case _destroy, _flush:
+		return r.destroyOrFlush(ctx, req)
+	case _rename, _swap:
+		return r.renameOrSwap(ctx, req)
+	case _add, _del, _test:
+		return r.adt(ctx, req)
+	case _list, _save:
+		return r.list(ctx, req, out)
+	case _restore:
+		return r.restore(ctx, req, stdin, out)
+	}
+	return fmt.Errorf("Unknown command: `%s'", req.action)
+}
+
+// exec sends the message to the kernel and returns its replies.
+func (r *NetlinkBackend) exec(ctx context.Context, m *nlMessage) ([][]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	return r.conn.request(ctx, m.encode(r.seq))
+}
+
+func (r *NetlinkBackend) create(ctx context.Context, req *request) error {
+	setType := SetType(req.arg)
+	family := req.create.family
+	if family == "" {
+		family = Inet
+	}
+	nfproto := uint8(nfprotoIPv4)
+	if family == Inet6 {
+		nfproto = nfprotoIPv6
+	}
+	if setType == HashMac || method(setType) == "list" {
+		nfproto = nfprotoUnspec
+	}
+
+	revision, err := r.revision(ctx, setType, nfproto)
+	if err != nil {
+		return err
+	}
+
+	m := newMessage(ipsetCmdCreate, r.protocol)
+	if !req.exist {
+		m.flags |= nlmFExcl
+	}
+	m.str(ipsetAttrSetname, req.name)
+	m.str(ipsetAttrTypename, string(setType))
+	m.u8(ipsetAttrRevision, revision)
+	m.u8(ipsetAttrFamily, nfproto)
+
+	var data nlAttrs
+	if err = putCreateArgs(&data, setType, &req.create); err != nil {
+		return err
+	}
+	m.nest(ipsetAttrData, &data)
+
+	r.forget(req.name)
+	if _, err = r.exec(ctx, m); err != nil {
+		return kernelError(ipsetCmdCreate, setType, err)
+	}
+	return nil
+}
+
+// revision returns the newest revision of the set type supported
+// by the kernel.
+func (r *NetlinkBackend) revision(ctx context.Context, setType SetType, nfproto uint8) (uint8, error) {
+	m := newMessage(ipsetCmdType, r.protocol)
+	m.str(ipsetAttrTypename, string(setType))
+	m.u8(ipsetAttrFamily, nfproto)
+	replies, err := r.exec(ctx, m)
+	if err != nil {
+		return 0, kernelError(ipsetCmdType, setType, err)
+	}
+	for _, reply := range replies {
+		attrs, err := parseAttrs(reply)
+		if err != nil {
+			return 0, err
+		}
+		for _, a := range attrs {
+			if a.typ == ipsetAttrRevision {
+				return a.u8(), nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("Kernel error received: set type %s is not supported", setType)
+}
+
+func putCreateArgs(data *nlAttrs, setType SetType, a *createArgs) error {
+	if a.hasTimeout {
+		data.be32(ipsetAttrTimeout, a.timeout)
+	}
+	if a.hashSize != 0 {
+		data.be32(ipsetAttrHashsize, a.hashSize)
+	}
+	if a.maxElem != 0 {
+		data.be32(ipsetAttrMaxelem, a.maxElem)
+	}
+	if a.netmask != 0 {
+		data.u8(ipsetAttrNetmask, a.netmask)
+	}
+	if a.markmask != 0 {
+		data.be32(ipsetAttrMarkmask, a.markmask)
+	}
+	if a.size != 0 {
+		data.be32(ipsetAttrSize, a.size)
+	}
+	if a.bucketsize != 0 {
+		data.u8(ipsetAttrBucketsize, a.bucketsize)
+	}
+	if a.initval != 0 {
+		data.be32(ipsetAttrInitval, a.initval)
+	}
+	if a.ipRange != "" {
+		if setType == BitmapPort {
+			_, from, to, err := parsePort(a.ipRange)
+			if err != nil {
+				return fmt.Errorf("Syntax error: invalid range %s", a.ipRange)
+			}
+			data.be16(ipsetAttrPort, from)
+			data.be16(ipsetAttrPortTo, to)
+		} else {
+			ip, to, cidr, err := parseIPAddr(a.ipRange, Inet)
+			if err != nil {
+				return fmt.Errorf("Syntax error: invalid range %s", a.ipRange)
+			}
+			data.ip(ipsetAttrIP, ip)
+			if to != nil {
+				data.ip(ipsetAttrIPTo, to)
+			}
+			if cidr != 0 {
+				data.u8(ipsetAttrCidr, cidr)
+			}
+		}
+	}
+
+	var flags uint32
+	if a.counters {
+		flags |= ipsetFlagWithCounters
+	}
+	if a.comment {
+		flags |= ipsetFlagWithComment
+	}
+	if a.forceadd {
+		flags |= ipsetFlagWithForceadd
+	}
+	if a.skbinfo {
+		flags |= ipsetFlagWithSkbinfo
+	}
+	if flags != 0 {
+		data.be32(ipsetAttrCadtFlags, flags)
+	}
+	return nil
+}
+
+func (r *NetlinkBackend) destroyOrFlush(ctx context.Context, req *request) error {
+	cmd := uint8(ipsetCmdFlush)
+	if req.action == _destroy {
+		cmd = ipsetCmdDestroy
+		r.forget(req.name)
+	}
+	m := newMessage(cmd, r.protocol)
+	if req.name != "" {
+		m.str(ipsetAttrSetname, req.name)
+	}
+	if _, err := r.exec(ctx, m); err != nil {
+		return kernelError(cmd, "", err)
+	}
+	return nil
+}
+
+func (r *NetlinkBackend) renameOrSwap(ctx context.Context, req *request) error {
+	cmd := uint8(ipsetCmdSwap)
+	if req.action == _rename {
+		cmd = ipsetCmdRename
+	}
+	r.forget(req.name, req.arg)
+	m := newMessage(cmd, r.protocol)
+	m.str(ipsetAttrSetname, req.name)
+	m.str(ipsetAttrSetname2, req.arg)
+	if _, err := r.exec(ctx, m); err != nil {
+		return kernelError(cmd, "", err)
+	}
+	return nil
+}
+
+func (r *NetlinkBackend) adt(ctx context.Context, req *request) error {
+	info, err := r.info(ctx, req.name)
+	if err != nil {
+		return err
+	}
+
+	e, err := parseElement(info.setType, info.family, req.arg)
+	if err != nil {
+		return err
+	}
+
+	cmd := uint8(ipsetCmdAdd)
+	switch req.action {
+	case _del:
+		cmd = ipsetCmdDel
+	case _test:
+		cmd = ipsetCmdTest
+	}
+	m := newMessage(cmd, r.protocol)
+	if !req.exist {
+		m.flags |= nlmFExcl
+	}
+	m.str(ipsetAttrSetname, req.name)
+	var data nlAttrs
+	putElement(&data, info.setType, e, &req.entry)
+	m.nest(ipsetAttrData, &data)
+
+	if _, err = r.exec(ctx, m); err != nil {
+		// the type of the set may be changed by others
+		r.forget(req.name)
+		if cmd == ipsetCmdTest && err == nlError(ipsetErrExist) {
+			return fmt.Errorf("%s is NOT in set %s.", req.arg, req.name)
+		}
+		return kernelError(cmd, info.setType, err)
+	}
+	return nil
+}
+
+// info returns the type and family of the set, which are cached
+// until the set is renamed, swapped or destroyed.
+func (r *NetlinkBackend) info(ctx context.Context, name string) (setInfo, error) {
+	r.mu.Lock()
+	info, ok := r.sets[name]
+	r.mu.Unlock()
+	if ok {
+		return info, nil
+	}
+
+	m := newMessage(ipsetCmdHeader, r.protocol)
+	m.str(ipsetAttrSetname, name)
+	replies, err := r.exec(ctx, m)
+	if err != nil {
+		return info, kernelError(ipsetCmdHeader, "", err)
+	}
+	for _, reply := range replies {
+		attrs, err := parseAttrs(reply)
+		if err != nil {
+			return info, err
+		}
+		for _, a := range attrs {
+			switch a.typ {
+			case ipsetAttrTypename:
+				info.setType = SetType(a.str())
+			case ipsetAttrFamily:
+				info.family = nfprotoFamily(a.u8())
+			}
+		}
+	}
+
+	r.mu.Lock()
+	r.sets[name] = info
+	r.mu.Unlock()
+	return info, nil
+}
+
+func (r *NetlinkBackend) forget(names ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(names) == 0 || names[0] == "" {
+		r.sets = map[string]setInfo{}
+		return
+	}
+	for _, name := range names {
+		delete(r.sets, name)
+	}
+}
+
+func nfprotoFamily(nfproto uint8) NetFamily {
+	if nfproto == nfprotoIPv6 {
+		return Inet6
+	}
+	return Inet
+}
+
+func putElement(data *nlAttrs, setType SetType, e *element, a *entryArgs) {
+	var flags uint32
+	if e.ip != nil {
+		data.ip(ipsetAttrIP, e.ip)
+		if e.ipTo != nil {
+			data.ip(ipsetAttrIPTo, e.ipTo)
+		}
+		if e.cidr != 0 {
+			data.u8(ipsetAttrCidr, e.cidr)
+		}
+	}
+	for _, dim := range dimensions(setType) {
+		if dim != _port {
+			continue
+		}
+		data.be16(ipsetAttrPort, e.port)
+		if e.portTo != 0 {
+			data.be16(ipsetAttrPortTo, e.portTo)
+		}
+		if setType != BitmapPort {
+			data.u8(ipsetAttrProto, e.proto)
+		}
+	}
+	if e.ip2 != nil {
+		data.ip(ipsetAttrIP2, e.ip2)
+		if e.ip2To != nil {
+			data.ip(ipsetAttrIP2To, e.ip2To)
+		}
+		if e.cidr2 != 0 {
+			data.u8(ipsetAttrCidr2, e.cidr2)
+		}
+	}
+	if e.mac != nil {
+		data.put(ipsetAttrEther, e.mac)
+	}
+	if e.iface != "" {
+		data.str(ipsetAttrIface, e.iface)
+		if e.physdev {
+			flags |= ipsetFlagPhysdev
+		}
+	}
+	if setType == HashIpMark {
+		data.be32(ipsetAttrMark, e.mark)
+	}
+	if e.name != "" {
+		data.str(ipsetAttrName, e.name)
+	}
+
+	if a.hasTimeout {
+		data.be32(ipsetAttrTimeout, a.timeout)
+	}
+	if a.packets != 0 {
+		data.be64(ipsetAttrPackets, a.packets)
+	}
+	if a.bytes != 0 {
+		data.be64(ipsetAttrBytes, a.bytes)
+	}
+	if a.comment != "" {
+		data.str(ipsetAttrComment, a.comment)
+	}
+	if a.skbmark != 0 || (a.skbmask != 0 && a.skbmask != 0xffffffff) {
+		data.be64(ipsetAttrSkbmark, uint64(a.skbmark)<<32|uint64(a.skbmask))
+	}
+	if a.skbprio != 0 {
+		data.be32(ipsetAttrSkbprio, a.skbprio)
+	}
+	if a.skbqueue != 0 {
+		data.be16(ipsetAttrSkbqueue, a.skbqueue)
+	}
+	if a.nomatch {
+		flags |= ipsetFlagNomatch
+	}
+	if a.wildcard {
+		flags |= ipsetFlagIfaceWildcard
+	}
+	if a.before != "" {
+		data.str(ipsetAttrNameref, a.before)
+		flags |= ipsetFlagBefore
+	} else if a.after != "" {
+		data.str(ipsetAttrNameref, a.after)
+	}
+	if flags != 0 {
+		data.be32(ipsetAttrCadtFlags, flags)
+	}
+}
+
+func (r *NetlinkBackend) list(ctx context.Context, req *request, out io.Writer) error {
+	cmd := uint8(ipsetCmdList)
+	if req.action == _save {
+		cmd = ipsetCmdSave
+	}
+	m := newMessage(cmd, r.protocol)
+	m.flags = nlmFRequest | nlmFDump
+	if req.name != "" {
+		m.str(ipsetAttrSetname, req.name)
+	}
+	replies, err := r.exec(ctx, m)
+	if err != nil {
+		return kernelError(cmd, "", err)
+	}
+
+	sets, err := parseDump(replies)
+	if err != nil {
+		return err
+	}
+	return writeSets(out, sets, req)
+}
+
+// parseDump parses the reply messages of list and save command,
+// the data of a set may be split into several messages.
+func parseDump(replies [][]byte) ([]*setData, error) {
+	var (
+		sets []*setData
+		d    *setData
+	)
+	for _, reply := range replies {
+		attrs, err := parseAttrs(reply)
+		if err != nil {
+			return nil, err
+		}
+		var adt []nlAttr
+		for _, a := range attrs {
+			switch a.typ {
+			case ipsetAttrSetname:
+				if name := a.str(); d == nil || d.name != name {
+					d = &setData{name: name}
+					sets = append(sets, d)
+				}
+			case ipsetAttrTypename:
+				d.setType = SetType(a.str())
+			case ipsetAttrRevision:
+				d.revision = a.u8()
+			case ipsetAttrFamily:
+				d.header.family = nfprotoFamily(a.u8())
+			case ipsetAttrData:
+				if err = parseHeader(d, a.data); err != nil {
+					return nil, err
+				}
+			case ipsetAttrADT:
+				if adt, err = parseAttrs(a.data); err != nil {
+					return nil, err
+				}
+			}
+		}
+		for _, a := range adt {
+			if d == nil || a.typ != ipsetAttrData {
+				continue
+			}
+			m, err := parseMember(d, a.data)
+			if err != nil {
+				return nil, err
+			}
+			d.members = append(d.members, m)
+		}
+	}
+	return sets, nil
+}
+
+// parseHeader parses the header data of a set.
+func parseHeader(d *setData, data []byte) error {
+	attrs, err := parseAttrs(data)
+	if err != nil {
+		return err
+	}
+	h := &d.header
+	var (
+		ip, ipTo         net.IP
+		cidr             uint8
+		port, portTo     uint16
+		hasPort, hasCidr bool
+	)
+	for _, a := range attrs {
+		switch a.typ {
+		case ipsetAttrIP:
+			ip = a.ip()
+		case ipsetAttrIPTo:
+			ipTo = a.ip()
+		case ipsetAttrCidr:
+			cidr, hasCidr = a.u8(), true
+		case ipsetAttrPort:
+			port, hasPort = a.be16(), true
+		case ipsetAttrPortTo:
+			portTo = a.be16()
+		case ipsetAttrTimeout:
+			h.timeout, h.hasTimeout = a.be32(), true
+		case ipsetAttrHashsize:
+			h.hashSize = a.be32()
+		case ipsetAttrMaxelem:
+			h.maxElem = a.be32()
+		case ipsetAttrNetmask:
+			h.netmask = a.u8()
+		case ipsetAttrMarkmask:
+			h.markmask = a.be32()
+		case ipsetAttrSize:
+			h.size = a.be32()
+		case ipsetAttrBucketsize:
+			h.bucketsize = a.u8()
+		case ipsetAttrInitval:
+			h.initval = a.be32()
+		case ipsetAttrReferences:
+			d.references = a.be32()
+		case ipsetAttrMemsize:
+			d.memSize = a.be32()
+		case ipsetAttrCadtFlags:
+			flags := a.be32()
+			h.counters = flags&ipsetFlagWithCounters != 0
+			h.comment = flags&ipsetFlagWithComment != 0
+			h.forceadd = flags&ipsetFlagWithForceadd != 0
+			h.skbinfo = flags&ipsetFlagWithSkbinfo != 0
+		}
+	}
+	switch {
+	case ip != nil && ipTo != nil:
+		h.ipRange = formatIPAddr(ip, ipTo, 0)
+	case ip != nil && hasCidr:
+		h.ipRange = formatIPAddr(ip, nil, cidr)
+	case hasPort:
+		h.ipRange = i2str(uint64(port)) + "-" + i2str(uint64(portTo))
+	}
+	return nil
+}
+
+// parseMember parses the data of an entry.
+func parseMember(d *setData, data []byte) (m member, err error) {
+	attrs, err := parseAttrs(data)
+	if err != nil {
+		return
+	}
+	e := &element{}
+	for _, a := range attrs {
+		switch a.typ {
+		case ipsetAttrIP:
+			e.ip = a.ip()
+		case ipsetAttrIPTo:
+			e.ipTo = a.ip()
+		case ipsetAttrCidr:
+			e.cidr = a.u8()
+		case ipsetAttrPort:
+			e.port = a.be16()
+		case ipsetAttrPortTo:
+			e.portTo = a.be16()
+		case ipsetAttrProto:
+			e.proto = a.u8()
+		case ipsetAttrIP2:
+			e.ip2 = a.ip()
+		case ipsetAttrIP2To:
+			e.ip2To = a.ip()
+		case ipsetAttrCidr2:
+			e.cidr2 = a.u8()
+		case ipsetAttrEther:
+			e.mac = net.HardwareAddr(append([]byte(nil), a.data...))
+		case ipsetAttrIface:
+			e.iface = a.str()
+		case ipsetAttrMark:
+			e.mark = a.be32()
+		case ipsetAttrName:
+			e.name = a.str()
+		case ipsetAttrTimeout:
+			m.ext.timeout, m.ext.hasTimeout = a.be32(), true
+		case ipsetAttrPackets:
+			m.ext.packets = a.be64()
+		case ipsetAttrBytes:
+			m.ext.bytes = a.be64()
+		case ipsetAttrComment:
+			m.ext.comment = a.str()
+		case ipsetAttrSkbmark:
+			v := a.be64()
+			m.ext.skbmark, m.ext.skbmask = uint32(v>>32), uint32(v)
+		case ipsetAttrSkbprio:
+			m.ext.skbprio = a.be32()
+		case ipsetAttrSkbqueue:
+			m.ext.skbqueue = a.be16()
+		case ipsetAttrCadtFlags:
+			flags := a.be32()
+			e.physdev = flags&ipsetFlagPhysdev != 0
+			m.ext.nomatch = flags&ipsetFlagNomatch != 0
+			m.ext.wildcard = flags&ipsetFlagIfaceWildcard != 0
+		}
+	}
+	if m.ext.skbmark == 0 && m.ext.skbmask == 0 {
+		m.ext.skbmask = 0xffffffff
+	}
+	m.elem = e.format(d.setType)
+	return
+}
+
+// restore reads commands line by line from stdin and carries them
+// out, it stops at the first failed line or once ctx is done.
+func (r *NetlinkBackend) restore(ctx context.Context, req *request, stdin io.Reader, out io.Writer) error {
+	return restoreLines(ctx, stdin, req.exist, func(lr *request) error {
+		return r.do(ctx, lr, nil, out)
+	})
+}
+
+// ipset specific error codes of the kernel
+const (
+	ipsetErrPrivate = iota + 4096
+	ipsetErrProtocol
+	ipsetErrFindType
+	ipsetErrMaxSets
+	ipsetErrBusy
+	ipsetErrExistSetname2
+	ipsetErrTypeMismatch
+	ipsetErrExist
+	ipsetErrInvalidCidr
+	ipsetErrInvalidNetmask
+	ipsetErrInvalidFamily
+	ipsetErrTimeout
+	ipsetErrReferenced
+	ipsetErrIPAddrIPv4
+	ipsetErrIPAddrIPv6
+	ipsetErrCounter
+	ipsetErrComment
+	ipsetErrInvalidMarkmask
+	ipsetErrSkbinfo
+
+	ipsetErrTypeSpecific = 4352
+)
+
+// Linux error numbers
+const (
+	errnoEPERM    = 1
+	errnoENOENT   = 2
+	errnoENOMEM   = 12
+	errnoEEXIST   = 17
+	errnoEINVAL   = 22
+	errnoEMSGSIZE = 90
+)
+
+// errorMessages holds the messages of the ipset utility for
+// error codes, commands which are not listed share the messages
+// of command 0.
+var errorMessages = map[int]map[uint8]string{
+	errnoEPERM:    {0: "Kernel error received: Operation not permitted"},
+	errnoENOENT:   {0: "The set with the given name does not exist"},
+	errnoENOMEM:   {0: "Kernel error received: Cannot allocate memory"},
+	errnoEINVAL:   {0: "Kernel error received: Invalid argument"},
+	errnoEMSGSIZE: {0: "Kernel error received: message could not be created"},
+	errnoEEXIST: {
+		0:                "Kernel error received: File exists",
+		ipsetCmdCreate:   "Set cannot be created: set with the same name already exists",
+		ipsetCmdType:     "Kernel error received: set type does not supported",
+		ipsetCmdProtocol: "Kernel error received: ipset protocol error",
+	},
+	ipsetErrProtocol: {0: "Kernel error received: ipset protocol error"},
+	ipsetErrFindType: {0: "Kernel error received: set type not supported"},
+	ipsetErrMaxSets:  {0: "Kernel error received: maximal number of sets reached, cannot create more."},
+	ipsetErrBusy:     {0: "Set cannot be destroyed: it is in use by a kernel component"},
+	ipsetErrReferenced: {
+		0:              "Set cannot be destroyed: it is in use by a kernel component",
+		ipsetCmdRename: "Set cannot be renamed: it is in use by another system",
+	},
+	ipsetErrExistSetname2: {
+		0:            "Set cannot be renamed: a set with the new name already exists",
+		ipsetCmdSwap: "Sets cannot be swapped: the second set does not exist",
+	},
+	ipsetErrTypeMismatch: {0: "The sets cannot be swapped: their type does not match"},
+	ipsetErrExist: {
+		0:           "Set cannot be created: set with the same name already exists",
+		ipsetCmdAdd: "Element cannot be added to the set: it's already added",
+		ipsetCmdDel: "Element cannot be deleted from the set: it's not added",
+	},
+	ipsetErrInvalidCidr:     {0: "The value of the CIDR parameter of the IP address is invalid"},
+	ipsetErrInvalidNetmask:  {0: "The value of the netmask parameter is invalid"},
+	ipsetErrInvalidFamily:   {0: "Protocol family not supported by the set type"},
+	ipsetErrTimeout:         {0: "Timeout cannot be used: set was created without timeout support"},
+	ipsetErrIPAddrIPv4:      {0: "An IPv4 address is expected, but not received"},
+	ipsetErrIPAddrIPv6:      {0: "An IPv6 address is expected, but not received"},
+	ipsetErrCounter:         {0: "Packet/byte counters cannot be used: set was created without counter support"},
+	ipsetErrComment:         {0: "Comment cannot be used: set was created without comment support"},
+	ipsetErrInvalidMarkmask: {0: "The value of the markmask parameter is invalid"},
+	ipsetErrSkbinfo:         {0: "Skbinfo mapping cannot be used: set was created without skbinfo support"},
+}
+
+// typeErrorMessages holds the messages of set type specific error
+// codes by storage method.
+var typeErrorMessages = map[string][]string{
+	"bitmap": {
+		"Element is out of the range of the set",
+		"The range you specified exceeds the size limit of the set type",
+	},
+	"hash": {
+		"Hash is full, cannot add more elements",
+		"Null-valued element, cannot be stored in a hash type of set",
+		"Invalid protocol specified",
+		"Protocol missing, but must be specified",
+		"Range is not supported in the \"net\" component of the element",
+		"Invalid range, covers the whole address space",
+	},
+	"list": {
+		"Set to be added/deleted/tested as element does not exist.",
+		"Sets with list:set type cannot be added to the set.",
+		"No reference set specified.",
+		"The set to which you referred with 'before' or 'after' does not exist.",
+		"The set is full, more elements cannot be added.",
+		"The set to which you referred with 'before' or 'after' is not added to the set.",
+	},
+}
+
+// kernelError converts the error code received from the kernel to the
+// message which the ipset utility reports.
+func kernelError(cmd uint8, setType SetType, err error) error {
+	code, ok := err.(nlError)
+	if !ok {
+		return err
+	}
+	if code >= ipsetErrTypeSpecific {
+		msgs := typeErrorMessages[method(setType)]
+		if i := int(code) - ipsetErrTypeSpecific; i < len(msgs) {
+			return errors.New(msgs[i])
+		}
+	}
+	if msgs, ok := errorMessages[int(code)]; ok {
+		if msg, ok := msgs[cmd]; ok {
+			return errors.New(msg)
+		}
+		return errors.New(msgs[0])
+	}
+	return fmt.Errorf("Kernel error received: error code %d", int(code))
+}
@@ -0,0 +1,70 @@
+//go:build linux
+// +build linux
+
+package ipset
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+)
+
+// sockPoll is how often a receive waiting for the kernel wakes up to
+// check whether its ctx is done.
+const sockPoll = 100 * time.Millisecond
+
+// sockConn is a netlink socket of NETLINK_NETFILTER family.
+type sockConn struct {
+	fd  int
+	buf []byte
+}
+
+func dialNetlink() (nlConn, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_NETFILTER)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		_ = syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	tv := syscall.NsecToTimeval(int64(sockPoll))
+	if err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		_ = syscall.Close(fd)
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	return &sockConn{fd: fd, buf: make([]byte, os.Getpagesize()*8)}, nil
+}
+
+// request receives with a timeout of sockPoll, so that it's given up
+// soon after ctx is done. Replies left by a given up request are
+// skipped by their sequence numbers.
+func (c *sockConn) request(ctx context.Context, msg []byte) ([][]byte, error) {
+	if err := syscall.Sendto(c.fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, os.NewSyscallError("sendto", err)
+	}
+
+	seq := nativeEndian.Uint32(msg[8:12])
+	var payloads [][]byte
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		n, _, err := syscall.Recvfrom(c.fd, c.buf, 0)
+		if err != nil {
+			if err == syscall.EINTR || err == syscall.EAGAIN {
+				continue
+			}
+			return nil, os.NewSyscallError("recvfrom", err)
+		}
+		done, err := readReplies(c.buf[:n], seq, &payloads)
+		if done || err != nil {
+			return payloads, err
+		}
+	}
+}
+
+func (c *sockConn) close() error {
+	return syscall.Close(c.fd)
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package ipset
+
+func dialNetlink() (nlConn, error) {
+	return nil, ErrNetlinkNotSupported
+}
@@ -0,0 +1,384 @@
+package ipset
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeReply struct {
+	payloads [][]byte
+	err      error
+	// stuck replies come never, the request waits until ctx is done
+	stuck bool
+}
+
+// fakeConn records requests and answers with recorded replies.
+type fakeConn struct {
+	requests [][]byte
+	replies  []fakeReply
+}
+
+func (c *fakeConn) request(ctx context.Context, msg []byte) ([][]byte, error) {
+	c.requests = append(c.requests, msg)
+	if len(c.replies) == 0 {
+		return nil, nil
+	}
+	r := c.replies[0]
+	c.replies = c.replies[1:]
+	if r.stuck {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return r.payloads, r.err
+}
+
+func (c *fakeConn) close() error {
+	return nil
+}
+
+// Recorded netlink payloads after nfgenmsg
+const (
+	// PROTOCOL 7
+	fixtureProtocol = "05000100 07000000"
+	// PROTOCOL 7, SETNAME test, TYPENAME hash:ip, REVISION 4, FAMILY inet
+	fixtureHeader = "05000100 07000000 09000200 74657374 00000000" +
+		"0c000300 68617368 3a697000 05000400 04000000 05000500 02000000"
+	// fixtureHeader with DATA(HASHSIZE 1024, MAXELEM 65536, REFERENCES 0,
+	// MEMSIZE 168, ELEMENTS 1) and ADT(DATA(IP 1.1.1.1))
+	fixtureList = fixtureHeader +
+		"2c000780 08001240 00000400 08001340 00010000 08001940 00000000" +
+		"08001a40 000000a8 08001840 00000001" +
+		"14000880 10000780 0c000180 08000140 01010101"
+	// add test 1.1.1.1 timeout 60 with sequence 3
+	fixtureAdd = "40000000 09060502 03000000 00000000 02000000" +
+		"05000100 07000000 09000200 74657374 00000000" +
+		"18000780 0c000180 08000140 01010101 08000640 0000003c"
+)
+
+func fixture(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(strings.Replace(s, " ", "", -1))
+	require.Nil(t, err)
+	return b
+}
+
+func setupNetlink(t *testing.T, replies ...fakeReply) *fakeConn {
+	c := &fakeConn{replies: append([]fakeReply{
+		{payloads: [][]byte{fixture(t, fixtureProtocol)}},
+	}, replies...)}
+	r, err := newNetlinkBackend(c)
+	require.Nil(t, err)
+	defaultClient.backend = r
+	defaultClient.version = nil
+	return c
+}
+
+func teardownNetlink() {
+	defaultClient.backend = ExecBackend{}
+	defaultClient.version = nil
+}
+
+func Test_Netlink_Protocol(t *testing.T) {
+	t.Run("negotiated", func(t *testing.T) {
+		c := &fakeConn{replies: []fakeReply{{payloads: [][]byte{fixture(t, "05000100 06000000")}}}}
+		r, err := newNetlinkBackend(c)
+		require.Nil(t, err)
+		assert.Equal(t, uint8(6), r.protocol)
+
+		out, err := r.Run(context.Background(), []string{_version}, nil)
+		require.Nil(t, err)
+		assert.Equal(t, 6, getMajorVersion(out))
+	})
+
+	t.Run("not supported", func(t *testing.T) {
+		c := &fakeConn{replies: []fakeReply{{payloads: [][]byte{fixture(t, "05000100 05000000")}}}}
+		_, err := newNetlinkBackend(c)
+		assert.Equal(t, ErrVersionNotSupported, err)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		c := &fakeConn{replies: []fakeReply{{err: nlError(errnoEPERM)}}}
+		_, err := newNetlinkBackend(c)
+		require.Error(t, err)
+		assert.Equal(t,
+			"ipset: can't negotiate netlink protocol: Kernel error received: Operation not permitted",
+			err.Error())
+	})
+}
+
+func Test_Netlink_Add(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		c := setupNetlink(t, fakeReply{payloads: [][]byte{fixture(t, fixtureHeader)}})
+		defer teardownNetlink()
+
+		require.Nil(t, getSet().Add("1.1.1.1", Timeout(60e9)))
+		require.Len(t, c.requests, 3)
+		assert.Equal(t, fixture(t, fixtureAdd), c.requests[2])
+	})
+
+	t.Run("cached set type", func(t *testing.T) {
+		c := setupNetlink(t, fakeReply{payloads: [][]byte{fixture(t, fixtureHeader)}})
+		defer teardownNetlink()
+
+		s := getSet()
+		require.Nil(t, s.Add("1.1.1.1"))
+		require.Nil(t, s.Add("1.1.1.2", Exist(true)))
+		require.Len(t, c.requests, 4)
+		// -exist clears NLM_F_EXCL
+		assert.Equal(t, uint16(nlmFRequest|nlmFAck), nativeEndian.Uint16(c.requests[3][6:8]))
+	})
+
+	t.Run("already added", func(t *testing.T) {
+		setupNetlink(t,
+			fakeReply{payloads: [][]byte{fixture(t, fixtureHeader)}},
+			fakeReply{err: nlError(ipsetErrExist)})
+		defer teardownNetlink()
+
+		err := getSet().Add("1.1.1.1")
+		require.Error(t, err)
+		assert.Equal(t,
+			"ipset: can't add test 1.1.1.1: Element cannot be added to the set: it's already added",
+			err.Error())
+	})
+
+	t.Run("set not exist", func(t *testing.T) {
+		setupNetlink(t, fakeReply{err: nlError(errnoENOENT)})
+		defer teardownNetlink()
+
+		err := getSet().Add("1.1.1.1")
+		require.Error(t, err)
+		assert.Equal(t,
+			"ipset: can't add test 1.1.1.1: The set with the given name does not exist",
+			err.Error())
+	})
+
+	t.Run("hash is full", func(t *testing.T) {
+		setupNetlink(t,
+			fakeReply{payloads: [][]byte{fixture(t, fixtureHeader)}},
+			fakeReply{err: nlError(ipsetErrTypeSpecific)})
+		defer teardownNetlink()
+
+		err := getSet().Add("1.1.1.1")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Hash is full, cannot add more elements")
+	})
+
+	t.Run("invalid entry", func(t *testing.T) {
+		c := setupNetlink(t, fakeReply{payloads: [][]byte{fixture(t, fixtureHeader)}})
+		defer teardownNetlink()
+
+		require.Error(t, getSet().Add("1.1.1.1,80"))
+		assert.Len(t, c.requests, 2)
+	})
+}
+
+func Test_Netlink_Context(t *testing.T) {
+	setupNetlink(t, fakeReply{payloads: [][]byte{fixture(t, fixtureHeader)}}, fakeReply{stuck: true})
+	defer teardownNetlink()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := defaultClient.Backend().Run(ctx, []string{_add, "test", "1.1.1.1"}, nil)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func Test_Netlink_Test(t *testing.T) {
+	t.Run("in set", func(t *testing.T) {
+		setupNetlink(t, fakeReply{payloads: [][]byte{fixture(t, fixtureHeader)}})
+		defer teardownNetlink()
+
+		ok, err := getSet().Test("1.1.1.1")
+		require.Nil(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("not in set", func(t *testing.T) {
+		setupNetlink(t,
+			fakeReply{payloads: [][]byte{fixture(t, fixtureHeader)}},
+			fakeReply{err: nlError(ipsetErrExist)})
+		defer teardownNetlink()
+
+		ok, err := getSet().Test("1.1.1.2")
+		require.Nil(t, err)
+		assert.False(t, ok)
+	})
+}
+
+func Test_Netlink_Create(t *testing.T) {
+	c := setupNetlink(t, fakeReply{payloads: [][]byte{fixture(t, fixtureHeader)}})
+	defer teardownNetlink()
+
+	_, err := New("test", HashIp, Timeout(60e9), Counters(true), HashSize(1024))
+	require.Nil(t, err)
+	require.Len(t, c.requests, 3)
+
+	msg := c.requests[2]
+	assert.Equal(t, uint16(nfnlSubsysIPSet<<8|ipsetCmdCreate), nativeEndian.Uint16(msg[4:6]))
+	assert.Equal(t, uint16(nlmFRequest|nlmFAck|nlmFExcl), nativeEndian.Uint16(msg[6:8]))
+
+	attrs, err := parseAttrs(msg[20:])
+	require.Nil(t, err)
+	require.Len(t, attrs, 6)
+	assert.Equal(t, "test", attrs[1].str())
+	assert.Equal(t, "hash:ip", attrs[2].str())
+	assert.Equal(t, uint8(4), attrs[3].u8())
+	assert.Equal(t, uint8(nfprotoIPv4), attrs[4].u8())
+
+	data, err := parseAttrs(attrs[5].data)
+	require.Nil(t, err)
+	require.Len(t, data, 3)
+	assert.Equal(t, uint32(60), data[0].be32())
+	assert.Equal(t, uint32(1024), data[1].be32())
+	assert.Equal(t, uint32(ipsetFlagWithCounters), data[2].be32())
+}
+
+func Test_Netlink_Create_Features(t *testing.T) {
+	c := setupNetlink(t, fakeReply{payloads: [][]byte{fixture(t, fixtureHeader)}})
+	defer teardownNetlink()
+
+	// protocol 7 makes up v7.0, which is older than the features
+	_, err := New("test", HashIp, Bucketsize(4), Initval(0x5f))
+	require.Nil(t, err)
+
+	attrs, err := parseAttrs(c.requests[len(c.requests)-1][20:])
+	require.Nil(t, err)
+	d := &setData{setType: HashIp}
+	require.Nil(t, parseHeader(d, attrs[len(attrs)-1].data))
+	assert.Equal(t, uint8(4), d.header.bucketsize)
+	assert.Equal(t, uint32(0x5f), d.header.initval)
+}
+
+func Test_Netlink_List(t *testing.T) {
+	setupNetlink(t, fakeReply{payloads: [][]byte{fixture(t, fixtureList)}})
+	defer teardownNetlink()
+
+	info, err := getSet().List()
+	require.Nil(t, err)
+	assert.Equal(t, 4, info.Revision)
+	assert.Equal(t, "family inet hashsize 1024 maxelem 65536", info.Header.String())
+	assert.Equal(t, 168, info.SizeInMemory)
+	assert.Equal(t, []string{"1.1.1.1"}, info.Entries)
+}
+
+func Test_Netlink_Save(t *testing.T) {
+	setupNetlink(t, fakeReply{payloads: [][]byte{fixture(t, fixtureList)}})
+	defer teardownNetlink()
+
+	r, err := getSet().Save()
+	require.Nil(t, err)
+	b := bytes.Buffer{}
+	_, err = b.ReadFrom(r)
+	require.Nil(t, err)
+	assert.Equal(t, strings.TrimPrefix(saveInfo, "\n"), strings.Replace(b.String(), "test", "foo", -1))
+}
+
+func Test_Netlink_Restore(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		c := setupNetlink(t, fakeReply{payloads: [][]byte{fixture(t, fixtureHeader)}})
+		defer teardownNetlink()
+
+		err := getSet().Restore(strings.NewReader("# comment\nadd test 1.1.1.1\nadd test 1.1.1.2\nCOMMIT\n"))
+		require.Nil(t, err)
+		assert.Len(t, c.requests, 4)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		setupNetlink(t, fakeReply{payloads: [][]byte{fixture(t, fixtureHeader)}})
+		defer teardownNetlink()
+
+		err := getSet().Restore(strings.NewReader("add test 1.1.1.1\nadd test 1.1.1.2 foo bar\n"))
+		require.Error(t, err)
+		assert.Equal(t,
+			"ipset: can't restore to test(hash:ip): Error in line 2: Unknown argument: `foo'",
+			err.Error())
+	})
+}
+
+func Test_Netlink_Commands(t *testing.T) {
+	c := setupNetlink(t)
+	defer teardownNetlink()
+
+	require.Nil(t, Flush())
+	require.Nil(t, Destroy("a"))
+	require.Nil(t, Swap("a", "b"))
+	require.Nil(t, getSet().Rename("b"))
+	require.Nil(t, Check())
+
+	cmds := []uint8{ipsetCmdFlush, ipsetCmdDestroy, ipsetCmdSwap, ipsetCmdRename}
+	require.Len(t, c.requests, len(cmds)+1)
+	for i, cmd := range cmds {
+		assert.Equal(t, uint16(nfnlSubsysIPSet<<8)|uint16(cmd), nativeEndian.Uint16(c.requests[i+1][4:6]))
+	}
+}
+
+func Test_Netlink_ReadReplies(t *testing.T) {
+	t.Run("ack", func(t *testing.T) {
+		var payloads [][]byte
+		// data message followed by ack
+		b := fixture(t, "1c000000 0d060000 01000000 00000000 02000000 05000100 07000000"+
+			"24000000 02000000 01000000 00000000 00000000 14000000 0d060500 01000000 00000000")
+		done, err := readReplies(b, 1, &payloads)
+		require.Nil(t, err)
+		assert.True(t, done)
+		require.Len(t, payloads, 1)
+		assert.Equal(t, fixture(t, "05000100 07000000"), payloads[0])
+	})
+
+	t.Run("error", func(t *testing.T) {
+		var payloads [][]byte
+		b := fixture(t, "24000000 02000000 01000000 00000000 feffffff 14000000 0d060500 01000000 00000000")
+		done, err := readReplies(b, 1, &payloads)
+		assert.True(t, done)
+		assert.Equal(t, nlError(errnoENOENT), err)
+	})
+
+	t.Run("dump in progress", func(t *testing.T) {
+		var payloads [][]byte
+		b := fixture(t, "1c000000 07060200 01000000 00000000 02000000 05000100 07000000")
+		done, err := readReplies(b, 1, &payloads)
+		require.Nil(t, err)
+		assert.False(t, done)
+		assert.Len(t, payloads, 1)
+
+		done, err = readReplies(fixture(t, "14000000 03000200 01000000 00000000 00000000"), 1, &payloads)
+		require.Nil(t, err)
+		assert.True(t, done)
+	})
+}
+
+func Test_Netlink_Attrs(t *testing.T) {
+	t.Run("bucketsize and initval", func(t *testing.T) {
+		var data nlAttrs
+		require.Nil(t, putCreateArgs(&data, HashIp, &createArgs{bucketsize: 4, initval: 0x5f}))
+
+		attrs, err := parseAttrs(data.b)
+		require.Nil(t, err)
+		require.Len(t, attrs, 2)
+		assert.Equal(t, uint8(4), attrs[0].u8())
+		assert.Equal(t, uint32(0x5f), attrs[1].be32())
+
+		d := &setData{setType: HashIp}
+		require.Nil(t, parseHeader(d, data.b))
+		assert.Equal(t, "family inet bucketsize 4 initval 0x0000005f", formatHeader(HashIp, &d.header))
+	})
+
+	t.Run("wildcard", func(t *testing.T) {
+		el, err := parseElement(HashNetIface, Inet, "10.0.0.0/8,eth")
+		require.Nil(t, err)
+		var data nlAttrs
+		putElement(&data, HashNetIface, el, &entryArgs{wildcard: true})
+
+		m, err := parseMember(&setData{setType: HashNetIface}, data.b)
+		require.Nil(t, err)
+		assert.Equal(t, "10.0.0.0/8,eth", m.elem)
+		assert.True(t, m.ext.wildcard)
+	})
+}
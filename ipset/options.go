@@ -12,6 +12,7 @@ type options struct {
 	exist           bool
 	resolve         bool
 	timeout         time.Duration
+	hasTimeout      bool
 	counters        bool
 	countersPackets uint
 	countersBytes   uint
@@ -31,6 +32,21 @@ type options struct {
 	netmask         byte
 	markmask        uint32
 	listSize        uint
+	strict          bool
+	bucketsize      uint8
+	initval         uint32
+	wildcard        bool
+	sorted          bool
+	output          string
+	file            string
+	terse           bool
+	prefix          string
+	sourceName      string
+	createMode      string
+	compress        string
+	reqChecksum     bool
+	tolerance       time.Duration
+	hasTolerance    bool
 }
 
 func (o *options) apply(opts ...Option) *options {
@@ -52,6 +68,7 @@ func acquireOptions() *options {
 
 func releaseOptions(o *options) {
 	o.timeout = 0
+	o.hasTimeout = false
 	o.exist = false
 	o.resolve = false
 	o.counters = false
@@ -73,6 +90,21 @@ func releaseOptions(o *options) {
 	o.listSize = 0
 	o.ipRange = ""
 	o.portRange = ""
+	o.strict = false
+	o.bucketsize = 0
+	o.initval = 0
+	o.wildcard = false
+	o.sorted = false
+	o.output = ""
+	o.file = ""
+	o.terse = false
+	o.prefix = ""
+	o.sourceName = ""
+	o.createMode = ""
+	o.compress = ""
+	o.reqChecksum = false
+	o.tolerance = 0
+	o.hasTolerance = false
 	optionsPool.Put(o)
 }
 
@@ -109,6 +141,14 @@ func Timeout(timeout time.Duration) Option {
 	}
 }
 
+// withTimeout is like Timeout but a set is created with timeout
+// support even if timeout is zero.
+func withTimeout(timeout time.Duration) Option {
+	return func(opt *options) {
+		opt.timeout, opt.hasTimeout = timeout, true
+	}
+}
+
 // Exist option ignores errors when exactly the same set is to
 // be created or already added entry is added or missing
 // entry is deleted.
@@ -380,3 +420,165 @@ func PortRange(portRange string) Option {
 		opt.portRange = portRange
 	}
 }
+
+// Strict option makes a command fail before it's executed if any
+// option is not valid for the action or the set type, or its value
+// is out of range, instead of leaving the option out. Extensions of
+// add, e.g. CommentContent, are checked against the create options
+// if the set is created by New or opened by Open. Warnings of
+// Validate don't fail the command.
+func Strict(strict bool) Option {
+	return func(opt *options) {
+		opt.strict = strict
+	}
+}
+
+// Bucketsize option is valid for the create command of all hash
+// type sets. It specifies the maximal number of elements which
+// can be stored in a hash bucket, the value must be between 2-12
+// and odd values are rounded up to the next even one. It requires
+// ipset v7.11. Example:
+//
+//      ipset create test hash:ip bucketsize 2
+func Bucketsize(bucketsize uint8) Option {
+	return func(opt *options) {
+		opt.bucketsize = bucketsize
+	}
+}
+
+// Initval option is valid for the create command of all hash type
+// sets. It sets the initial value of the hash function, which is
+// random by default, so that sets are restored identically. It
+// requires ipset v7.11. Example:
+//
+//      ipset create test hash:ip initval 0x5f263b39
+func Initval(initval uint32) Option {
+	return func(opt *options) {
+		opt.initval = initval
+	}
+}
+
+// Wildcard option is valid for the add command of hash:net,iface
+// sets. The interface of the entry matches all the interfaces
+// which have it as a prefix. It requires ipset v7.4. Example:
+//
+//      ipset add test 192.168.0.0/16,eth wildcard
+func Wildcard(wildcard bool) Option {
+	return func(opt *options) {
+		opt.wildcard = wildcard
+	}
+}
+
+// Sorted option is valid for the list and save command, entries
+// are listed sorted.
+func Sorted(sorted bool) Option {
+	return func(opt *options) {
+		opt.sorted = sorted
+	}
+}
+
+// Output modes
+const (
+	OutputPlain = "plain"
+	OutputSave  = "save"
+	OutputXML   = "xml"
+)
+
+// Output option is valid for the list and save command. It selects
+// the format of the output, which is one of plain, save and xml.
+// List only parses the plain output, other formats are meant for
+// ListToFile.
+func Output(output string) Option {
+	return func(opt *options) {
+		opt.output = output
+	}
+}
+
+// File option is valid for the save command. The output is
+// written to the file by ipset itself instead of being returned.
+// It requires ipset v6.24.
+func File(file string) Option {
+	return func(opt *options) {
+		opt.file = file
+	}
+}
+
+// Terse option is valid for the list command of a set or all the
+// sets, only the headers are listed without entries.
+func Terse(terse bool) Option {
+	return func(opt *options) {
+		opt.terse = terse
+	}
+}
+
+// Prefix option is valid for SaveAll and RestoreAll, only the sets
+// whose names start with the prefix are saved or restored.
+func Prefix(prefix string) Option {
+	return func(opt *options) {
+		opt.prefix = prefix
+	}
+}
+
+// SourceName option is valid for Import. It's the name of the set
+// in the save output, whose lines are renamed to the target set.
+// It's the set of the first line if it's empty.
+func SourceName(name string) Option {
+	return func(opt *options) {
+		opt.sourceName = name
+	}
+}
+
+// Create modes
+const (
+	CreateKeep    = "keep"
+	CreateSkip    = "skip"
+	CreateReplace = "replace"
+)
+
+// CreateMode option is valid for Import. It tells what to do with
+// the create line of the save output, which is one of keep, skip
+// and replace. The renamed create line is restored if it's keep or
+// empty, so the set must not exist unless the Exist option is set.
+// It's dropped if it's skip, so the set must exist. The set is
+// replaced atomically with the one created by the line if it's
+// replace, or created if it doesn't exist.
+func CreateMode(mode string) Option {
+	return func(opt *options) {
+		opt.createMode = mode
+	}
+}
+
+// Compressions
+const (
+	CompressGzip = "gzip"
+)
+
+// Compress option is valid for SaveToFile, ListToFile and
+// SaveAllToFile. The file is compressed by gzip, which is also used
+// if the file name ends with ".gz". Compressed files are recognized
+// by RestoreFromFile and RestoreAllFromFile.
+func Compress(compress string) Option {
+	return func(opt *options) {
+		opt.compress = compress
+	}
+}
+
+// TimeoutTolerance option is valid for Reconcile and ReconcilePlan.
+// Timeouts are ignored unless it's given, then entries are updated
+// if the desired timeout differs from the remaining time by more
+// than tolerance.
+func TimeoutTolerance(tolerance time.Duration) Option {
+	return func(opt *options) {
+		opt.tolerance, opt.hasTolerance = tolerance, true
+	}
+}
+
+// RequireChecksum option is valid for RestoreFromFileWith and
+// RestoreAllFromFile. Files without the SHA-256 trailer, e.g. the
+// ones written by ipset save or truncated plain files, are refused.
+// Files with the trailer are verified either way.
+func RequireChecksum(required bool) Option {
+	return func(opt *options) {
+		opt.reqChecksum = required
+	}
+}
@@ -0,0 +1,200 @@
+package ipset
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// ReconcileReport holds the changes which make the entries of a set
+// the desired ones.
+type ReconcileReport struct {
+	// Add are the desired entries missing in the set.
+	Add []*Entry
+	// Del are the entries of the set which are not desired.
+	Del []*Entry
+	// Update are the desired entries whose extensions differ from
+	// the ones in the set, e.g. timeout, comment or counters.
+	Update []*Entry
+	// Unchanged is the number of entries left as they are.
+	Unchanged int
+
+	// Applied is set if the changes are carried out.
+	Applied bool
+	// Results hold the results of the changes in the order of Del,
+	// Add and Update once applied.
+	Results []EntryResult
+}
+
+func (s set) Reconcile(desired []Entry, options ...Option) (*ReconcileReport, error) {
+	return s.ReconcileContext(context.Background(), desired, options...)
+}
+
+func (s set) ReconcileContext(ctx context.Context, desired []Entry, options ...Option) (*ReconcileReport, error) {
+	return s.reconcile(ctx, desired, false, options...)
+}
+
+func (s set) ReconcilePlan(desired []Entry, options ...Option) (*ReconcileReport, error) {
+	return s.ReconcilePlanContext(context.Background(), desired, options...)
+}
+
+func (s set) ReconcilePlanContext(ctx context.Context, desired []Entry, options ...Option) (*ReconcileReport, error) {
+	return s.reconcile(ctx, desired, true, options...)
+}
+
+func (s set) reconcile(ctx context.Context, desired []Entry, plan bool, options ...Option) (*ReconcileReport, error) {
+	o := acquireOptions().apply(options...)
+	tolerance, hasTolerance := o.tolerance, o.hasTolerance
+	releaseOptions(o)
+
+	info, err := s.ListContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	family := info.Header.Family
+
+	var (
+		current = make(map[string]*Entry, len(info.Members))
+		wanted  = make(map[string]*Entry, len(desired))
+		keys    []string
+	)
+	for _, e := range info.Members {
+		key, err := entryKey(info.SetType, family, e)
+		if err != nil {
+			return nil, fmt.Errorf("ipset: can't reconcile %s: %s", s.name, err)
+		}
+		current[key] = e
+	}
+	for i := range desired {
+		e := desired[i]
+		key, err := entryKey(info.SetType, family, &e)
+		if err != nil {
+			return nil, fmt.Errorf("ipset: can't reconcile %s: %s", s.name, err)
+		}
+		e.Elem = key
+		if _, ok := wanted[key]; !ok {
+			keys = append(keys, key)
+		}
+		// the last one wins
+		wanted[key] = &e
+	}
+
+	r := &ReconcileReport{}
+	for _, e := range info.Members {
+		key, _ := entryKey(info.SetType, family, e)
+		if _, ok := wanted[key]; !ok {
+			r.Del = append(r.Del, e)
+		}
+	}
+	for _, key := range keys {
+		want := wanted[key]
+		cur, ok := current[key]
+		switch {
+		case !ok:
+			r.Add = append(r.Add, want)
+		case extChanged(cur, want),
+			hasTolerance && timeoutChanged(cur, want, tolerance):
+			r.Update = append(r.Update, want)
+		default:
+			r.Unchanged++
+		}
+	}
+	if plan {
+		return r, nil
+	}
+
+	var lines []string
+	for _, e := range r.Del {
+		key, _ := entryKey(info.SetType, family, e)
+		line, err := joinLine([]string{_del, s.name, key})
+		if err != nil {
+			return r, fmt.Errorf("ipset: can't reconcile %s: %w", s.name, err)
+		}
+		lines = append(lines, line)
+		r.Results = append(r.Results, EntryResult{Entry: key})
+	}
+	for _, e := range append(r.Add[:len(r.Add):len(r.Add)], r.Update...) {
+		line, err := joinLine(append([]string{_add, s.name}, e.words()...))
+		if err != nil {
+			return r, fmt.Errorf("ipset: can't reconcile %s: %w", s.name, err)
+		}
+		lines = append(lines, line)
+		r.Results = append(r.Results, EntryResult{Entry: e.Elem})
+	}
+	// updates re-add the entries with the exist flag
+	err = s.batch(ctx, "reconcile", r.Results, true, func(i int) string {
+		return lines[i]
+	})
+	r.Applied = true
+	return r, err
+}
+
+// entryKey returns the element of the entry as listed by ipset. The
+// element is built from the dimensions if Elem is empty.
+func entryKey(setType SetType, family NetFamily, e *Entry) (string, error) {
+	elem := e.Elem
+	if elem == "" {
+		el := &element{
+			ip: e.IP, ipTo: e.IPTo, cidr: e.CIDR,
+			port: e.Port, portTo: e.PortTo, proto: protoTCP,
+			ip2: e.IP2, ip2To: e.IP2To, cidr2: e.CIDR2,
+			mac: e.MAC, iface: e.Iface, physdev: e.Physdev,
+			mark: e.Mark, name: e.Set,
+		}
+		if e.Proto != "" {
+			var err error
+			if el.proto, err = parseProto(e.Proto); err != nil {
+				return "", err
+			}
+		}
+		elem = el.format(setType)
+	} else if !isLiteral(setType, elem) {
+		return elem, nil
+	}
+
+	el, err := parseElement(setType, family, elem)
+	if err != nil {
+		return "", err
+	}
+	el.ip = maskIP(el.ip, el.ipTo, el.cidr)
+	el.ip2 = maskIP(el.ip2, el.ip2To, el.cidr2)
+	return el.format(setType), nil
+}
+
+func maskIP(ip, to net.IP, cidr uint8) net.IP {
+	if ip == nil || to != nil || cidr == 0 {
+		return ip
+	}
+	return ip.Mask(net.CIDRMask(int(cidr), len(ip)*8))
+}
+
+// extChanged reports whether the extensions of the desired entry
+// but timeout differ from the current one.
+func extChanged(cur, want *Entry) bool {
+	mask := func(m uint32) uint32 {
+		if m == 0 {
+			return 0xffffffff
+		}
+		return m
+	}
+	return want.Comment != cur.Comment ||
+		(want.Packets != 0 || want.Bytes != 0) && (want.Packets != cur.Packets || want.Bytes != cur.Bytes) ||
+		want.Skbmark != cur.Skbmark || mask(want.Skbmask) != mask(cur.Skbmask) ||
+		want.Skbprio != cur.Skbprio || want.Skbqueue != cur.Skbqueue ||
+		want.Nomatch != cur.Nomatch || want.Wildcard != cur.Wildcard
+}
+
+// timeoutChanged reports whether the timeout of the desired entry
+// differs from the remaining time of the current one by more than
+// tolerance.
+func timeoutChanged(cur, want *Entry, tolerance time.Duration) bool {
+	if !want.HasTimeout {
+		return false
+	}
+	d := want.Timeout - cur.Timeout
+	if d < 0 {
+		d = -d
+	}
+	return d > tolerance
+}
@@ -0,0 +1,174 @@
+package ipset
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Reconcile(t *testing.T) {
+	t.Parallel()
+
+	e := NewEmulator()
+	now := time.Now()
+	e.SetClock(func() time.Time { return now })
+	c := NewClient(e)
+	s, err := asSet(c.New("foo", HashNet, Timeout(time.Hour), Comment(true)))
+	require.Nil(t, err)
+	require.Nil(t, s.Add("10.0.0.0/8", CommentContent("a")))
+	require.Nil(t, s.Add("192.168.0.0/16"))
+	require.Nil(t, s.Add("1.1.1.1", Timeout(time.Minute)))
+
+	desired := []Entry{
+		{Elem: "10.1.2.3/8", Comment: "b"},
+		{IP: net.ParseIP("172.16.0.0").To4(), CIDR: 12},
+		{Elem: "1.1.1.1", Timeout: time.Hour, HasTimeout: true},
+		{Elem: "1.1.1.1/32", Timeout: 30 * time.Minute, HasTimeout: true},
+	}
+
+	// timeouts are ignored by default
+	r, err := s.ReconcilePlan(desired)
+	require.Nil(t, err)
+	require.Len(t, r.Update, 1)
+	assert.Equal(t, "10.0.0.0/8", r.Update[0].Elem)
+	assert.Equal(t, 1, r.Unchanged)
+
+	// or compared within the tolerance
+	r, err = s.ReconcilePlan(desired, TimeoutTolerance(time.Hour))
+	require.Nil(t, err)
+	require.Len(t, r.Update, 1)
+	assert.Equal(t, 1, r.Unchanged)
+
+	r, err = s.ReconcilePlan(desired, TimeoutTolerance(time.Second))
+	require.Nil(t, err)
+	assert.False(t, r.Applied)
+	require.Len(t, r.Del, 1)
+	assert.Equal(t, "192.168.0.0/16", r.Del[0].Elem)
+	require.Len(t, r.Add, 1)
+	assert.Equal(t, "172.16.0.0/12", r.Add[0].Elem)
+	require.Len(t, r.Update, 2)
+	assert.Equal(t, "10.0.0.0/8", r.Update[0].Elem)
+	assert.Equal(t, "1.1.1.1", r.Update[1].Elem)
+	assert.Equal(t, 30*time.Minute, r.Update[1].Timeout)
+	assert.Equal(t, 0, r.Unchanged)
+
+	info, err := s.List()
+	require.Nil(t, err)
+	assert.Len(t, info.Members, 3)
+
+	r, err = s.Reconcile(desired, TimeoutTolerance(time.Second))
+	require.Nil(t, err)
+	assert.True(t, r.Applied)
+	require.Len(t, r.Results, 4)
+	assert.Equal(t, "192.168.0.0/16", r.Results[0].Entry)
+	for _, res := range r.Results {
+		assert.Nil(t, res.Err)
+	}
+
+	info, err = s.List()
+	require.Nil(t, err)
+	require.Len(t, info.Members, 3)
+	assert.Equal(t, "10.0.0.0/8", info.Members[0].Elem)
+	assert.Equal(t, "b", info.Members[0].Comment)
+	assert.Equal(t, "1.1.1.1", info.Members[1].Elem)
+	assert.Equal(t, 30*time.Minute, info.Members[1].Timeout)
+	assert.Equal(t, "172.16.0.0/12", info.Members[2].Elem)
+
+	r, err = s.Reconcile(desired, TimeoutTolerance(0))
+	require.Nil(t, err)
+	assert.Len(t, r.Del, 0)
+	assert.Len(t, r.Add, 0)
+	assert.Len(t, r.Update, 0)
+	assert.Equal(t, 3, r.Unchanged)
+	assert.Len(t, r.Results, 0)
+
+	// an empty desired state deletes everything
+	r, err = s.Reconcile(nil)
+	require.Nil(t, err)
+	assert.Len(t, r.Del, 3)
+	info, err = s.List()
+	require.Nil(t, err)
+	assert.Len(t, info.Members, 0)
+}
+
+func Test_Reconcile_Error(t *testing.T) {
+	t.Parallel()
+
+	e := NewEmulator()
+	c := NewClient(e)
+	s, err := asSet(c.New("foo", HashIpPort))
+	require.Nil(t, err)
+	require.Nil(t, s.Add("1.1.1.1,80"))
+
+	r, err := s.ReconcilePlan([]Entry{
+		{IP: net.ParseIP("1.1.1.1").To4(), Port: 80},
+		{IP: net.ParseIP("1.1.1.2").To4(), Proto: "udp", Port: 53},
+	})
+	require.Nil(t, err)
+	assert.Equal(t, 1, r.Unchanged)
+	require.Len(t, r.Add, 1)
+	assert.Equal(t, "1.1.1.2,udp:53", r.Add[0].Elem)
+
+	_, err = s.Reconcile([]Entry{{Elem: "1.1.1.1"}})
+	require.Error(t, err)
+	_, err = s.Reconcile([]Entry{{}})
+	require.Error(t, err)
+
+	// failed lines are reported
+	r, err = s.Reconcile([]Entry{{Elem: "1.1.1.3,80", Comment: "x"}, {Elem: "1.1.1.4,80"}})
+	require.Error(t, err)
+	assert.True(t, r.Applied)
+	require.Len(t, r.Results, 3)
+	assert.Nil(t, r.Results[0].Err)
+	var ierr *Error
+	require.True(t, errors.As(r.Results[1].Err, &ierr))
+	assert.Equal(t, _add, ierr.Action)
+	assert.Nil(t, r.Results[2].Err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s.ReconcileContext(ctx, nil)
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	// plans don't restore
+	ctx, cancel = context.WithCancel(context.Background())
+	c = NewClient(BackendFunc(func(ctx context.Context, args []string, stdin io.Reader) ([]byte, error) {
+		if args[0] == _restore {
+			cancel()
+		}
+		return e.Run(ctx, args, stdin)
+	}))
+	s = &set{name: "foo", setType: HashIpPort, client: c}
+	_, err = s.ReconcilePlanContext(ctx, nil)
+	assert.Nil(t, err)
+	r, err = s.ReconcileContext(ctx, nil)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, errors.Is(r.Results[0].Err, context.Canceled))
+}
+
+func Test_Reconcile_Injection(t *testing.T) {
+	t.Parallel()
+
+	s, err := asSet(NewClient(NewEmulator()).New("foo", HashIp, Comment(true)))
+	require.Nil(t, err)
+	require.Nil(t, s.Add("1.1.1.1"))
+
+	r, err := s.Reconcile([]Entry{{Elem: "1.1.1.2", Comment: "x\ndestroy foo"}})
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrInvalidEntry))
+	assert.False(t, r.Applied)
+
+	// comments with spaces are quoted
+	_, err = s.Reconcile([]Entry{{Elem: "1.1.1.2", Comment: "a b"}})
+	require.Nil(t, err)
+	info, err := s.List()
+	require.Nil(t, err)
+	require.Len(t, info.Members, 1)
+	assert.Equal(t, "a b", info.Members[0].Comment)
+}
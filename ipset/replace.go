@@ -0,0 +1,205 @@
+package ipset
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxNameLen is the max length of set names, IPSET_MAXNAMELEN
+// includes the trailing zero.
+const maxNameLen = 31
+
+func (s set) Replace(entries []string, options ...Option) error {
+	return s.ReplaceContext(context.Background(), entries, options...)
+}
+
+func (s set) ReplaceContext(ctx context.Context, entries []string, options ...Option) error {
+	return s.replace(ctx, func(tmp *set) error {
+		_, err := tmp.AddManyContext(ctx, entries, options...)
+		return err
+	})
+}
+
+func (s set) ReplaceFrom(r io.Reader, exist ...bool) error {
+	return s.ReplaceFromContext(context.Background(), r, exist...)
+}
+
+func (s set) ReplaceFromContext(ctx context.Context, r io.Reader, exist ...bool) error {
+	return s.replace(ctx, func(tmp *set) error {
+		lines := newRenameReader(r, s.name, tmp.name, false)
+		if err := tmp.RestoreContext(ctx, lines, exist...); err != nil {
+			return err
+		}
+		return lines.err
+	})
+}
+
+func (s set) RestoreAtomic(r io.Reader, exist ...bool) error {
+	return s.RestoreAtomicContext(context.Background(), r, exist...)
+}
+
+func (s set) RestoreAtomicContext(ctx context.Context, r io.Reader, exist ...bool) error {
+	err := s.swapIn(ctx, func(tmp *set) error {
+		// the entries of the set are kept, the lines may update them
+		saved, err := s.SaveContext(ctx)
+		if err != nil {
+			return err
+		}
+		if err = tmp.restore(ctx, newRenameReader(saved, s.name, tmp.name, false)); err != nil {
+			return err
+		}
+
+		lines := newRenameReader(r, s.name, tmp.name, true)
+		if err = tmp.restore(ctx, lines, exist...); err != nil {
+			return err
+		}
+		return lines.err
+	})
+	if err != nil {
+		return fmt.Errorf("ipset: can't restore to %s(%s): %w", s.name, s.setType, err)
+	}
+	return nil
+}
+
+// replace replaces the set with the temporary set loaded by load,
+// see swapIn.
+func (s set) replace(ctx context.Context, load func(tmp *set) error) error {
+	if err := s.swapIn(ctx, load); err != nil {
+		return fmt.Errorf("ipset: can't replace %s: %w", s.name, err)
+	}
+	return nil
+}
+
+// swapIn creates a temporary set with the type and header of the
+// set, loads it with load and swaps it with the set, then the old
+// contents are destroyed. The temporary set is destroyed if any
+// step fails, so the set is never seen half loaded.
+func (s set) swapIn(ctx context.Context, load func(tmp *set) error) (err error) {
+	header := s.header
+	if header == nil {
+		var opened IPSet
+		if opened, err = s.client.OpenContext(ctx, s.name); err != nil {
+			return
+		}
+		header = opened.(*set).header
+	}
+
+	var name string
+	if name, err = tempName(s.name); err != nil {
+		return
+	}
+	var created IPSet
+	if created, err = s.client.NewContext(ctx, name, s.setType, header.Options()...); err != nil {
+		return
+	}
+	tmp := created.(*set)
+	defer func() {
+		// cleanup is not bound to ctx which may be done already
+		if e := s.client.destroy(context.Background(), tmp.name); e != nil && err == nil {
+			err = e
+		}
+	}()
+
+	if err = load(tmp); err != nil {
+		return
+	}
+	return s.client.SwapContext(ctx, tmp.name, s.name)
+}
+
+// tempName returns a random name derived from the name of a set.
+func tempName(name string) (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	suffix := "-" + hex.EncodeToString(b)
+	if len(name)+len(suffix) > maxNameLen {
+		name = name[:maxNameLen-len(suffix)]
+	}
+	return name + suffix, nil
+}
+
+// renameLine renames the set of an add line of save output from one
+// to another, create lines and blank lines are dropped. Other lines
+// and lines of other sets are refused.
+func renameLine(line, from, to string) (string, error) {
+	return rewriteLine(line, from, to, false)
+}
+
+// rewriteLine is like renameLine but create lines are renamed too if
+// create is set.
+func rewriteLine(line, from, to string, create bool) (string, error) {
+	words, err := splitLine(line)
+	if err != nil {
+		return "", err
+	}
+	if len(words) == 0 || strings.HasPrefix(words[0], "#") || words[0] == "COMMIT" {
+		return "", nil
+	}
+	if len(words) < 2 || words[1] != from {
+		return "", fmt.Errorf("line %q is not for set %s", line, from)
+	}
+	switch words[0] {
+	case _create, "-N":
+		if !create {
+			return "", nil
+		}
+		words[1] = to
+		return joinLine(words)
+	case _add, "-A":
+		words[1] = to
+		return joinLine(words)
+	}
+	return "", fmt.Errorf("line %q is not an add line", line)
+}
+
+// renameReader reads the lines of save output renamed by renameLine.
+type renameReader struct {
+	lineReader
+	// err is the first error of reading or renaming, the lines
+	// after it are not read.
+	err error
+}
+
+// newRenameReader renames the lines of r from one set to another.
+// Dropped lines are read as blank lines if blank is set, so that the
+// line numbers of restore errors match r.
+func newRenameReader(r io.Reader, from, to string, blank bool) *renameReader {
+	return newRewriteReader(r, from, to, false, blank)
+}
+
+// newRewriteReader is like newRenameReader but create lines are
+// renamed too if create is set. If from is empty, it's the set of
+// the first line.
+func newRewriteReader(r io.Reader, from, to string, create, blank bool) *renameReader {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 4096), 1<<20)
+	rr := &renameReader{}
+	rr.next = func(int) (string, bool) {
+		for rr.err == nil && sc.Scan() {
+			if from == "" {
+				if words, _ := splitLine(sc.Text()); len(words) > 1 && !strings.HasPrefix(words[0], "#") {
+					from = words[1]
+				}
+			}
+			line, err := rewriteLine(sc.Text(), from, to, create)
+			if err != nil {
+				rr.err = err
+				break
+			}
+			if line != "" || blank {
+				return line, true
+			}
+		}
+		if rr.err == nil {
+			rr.err = sc.Err()
+		}
+		return "", false
+	}
+	return rr
+}
@@ -0,0 +1,184 @@
+package ipset
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Set_Replace(t *testing.T) {
+	t.Parallel()
+
+	c := NewClient(NewEmulator())
+	s, err := asSet(c.New("foo", HashNet, Timeout(time.Hour), Comment(true)))
+	require.Nil(t, err)
+	require.Nil(t, s.Add("10.0.0.0/8"))
+
+	require.Nil(t, s.Replace([]string{"192.168.0.0/16", "172.16.0.0/12"}, CommentContent("new")))
+
+	info, err := s.List()
+	require.Nil(t, err)
+	assert.Equal(t, time.Hour, info.Header.Timeout)
+	assert.True(t, info.Header.Comment)
+	require.Len(t, info.Members, 2)
+	assert.Equal(t, "192.168.0.0/16", info.Members[0].Elem)
+	assert.Equal(t, "new", info.Members[0].Comment)
+	assert.Equal(t, time.Hour, info.Members[0].Timeout)
+
+	names, err := c.Names()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"foo"}, names)
+
+	// the set is kept if any entry fails
+	err = s.Replace([]string{"10.0.0.0/8", "x"})
+	require.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "ipset: can't replace foo: "))
+	info, err = s.List()
+	require.Nil(t, err)
+	assert.Len(t, info.Members, 2)
+	names, err = c.Names()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"foo"}, names)
+
+	// opened sets reuse the header
+	opened, err := c.Open("foo")
+	require.Nil(t, err)
+	require.Nil(t, opened.(Replacer).Replace(nil))
+	info, err = s.List()
+	require.Nil(t, err)
+	assert.Len(t, info.Members, 0)
+
+	err = (&set{name: "bar", setType: HashIp, client: c}).Replace(nil)
+	assert.True(t, errors.Is(err, ErrSetNotFound))
+}
+
+func Test_Set_ReplaceFrom(t *testing.T) {
+	t.Parallel()
+
+	c := NewClient(NewEmulator())
+	s, err := asSet(c.New("foo", HashIp))
+	require.Nil(t, err)
+	require.Nil(t, s.Add("1.1.1.1"))
+
+	saved, err := s.Save()
+	require.Nil(t, err)
+	b, err := ioutil.ReadAll(saved)
+	require.Nil(t, err)
+
+	require.Nil(t, s.Add("1.1.1.2"))
+	require.Nil(t, s.ReplaceFrom(bytes.NewReader(b)))
+	info, err := s.List()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"1.1.1.1"}, info.Entries)
+
+	err = s.ReplaceFrom(strings.NewReader("add foo 1.1.1.3\nadd bar 1.1.1.4\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `line "add bar 1.1.1.4" is not for set foo`)
+
+	err = s.ReplaceFrom(strings.NewReader("add foo 1.1.1.3\ndel foo 1.1.1.1\n"))
+	require.Error(t, err)
+
+	err = s.ReplaceFrom(strings.NewReader("add foo 1.1.1.3\nadd foo 1.1.1.3\n"))
+	require.Error(t, err)
+	require.Nil(t, s.ReplaceFrom(strings.NewReader("add foo 1.1.1.3\nadd foo 1.1.1.3\n"), true))
+
+	info, err = s.List()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"1.1.1.3"}, info.Entries)
+	names, err := c.Names()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"foo"}, names)
+}
+
+func Test_Set_Replace_Cleanup(t *testing.T) {
+	t.Parallel()
+
+	e := NewEmulator()
+	c := NewClient(BackendFunc(func(ctx context.Context, args []string, stdin io.Reader) ([]byte, error) {
+		if args[0] == _swap {
+			return []byte("fake error"), errors.New("exit status 1")
+		}
+		return e.Run(ctx, args, stdin)
+	}))
+	s, err := asSet(c.New("foo", HashIp))
+	require.Nil(t, err)
+	require.Nil(t, s.Add("1.1.1.1"))
+
+	err = s.Replace([]string{"1.1.1.2"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "fake error")
+
+	names, err := c.Names()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"foo"}, names)
+
+	// the temporary set is destroyed even if ctx is done
+	ctx, cancel := context.WithCancel(context.Background())
+	c = NewClient(BackendFunc(func(ctx context.Context, args []string, stdin io.Reader) ([]byte, error) {
+		if args[0] == _restore {
+			cancel()
+		}
+		return e.Run(ctx, args, stdin)
+	}))
+	err = (&set{name: "foo", setType: HashIp, client: c}).ReplaceContext(ctx, []string{"1.1.1.2"})
+	assert.True(t, errors.Is(err, context.Canceled))
+	names, err = NewClient(e).Names()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"foo"}, names)
+}
+
+func Test_TempName(t *testing.T) {
+	t.Parallel()
+
+	name, err := tempName("foo")
+	require.Nil(t, err)
+	assert.Len(t, name, 12)
+	assert.True(t, strings.HasPrefix(name, "foo-"))
+
+	name, err = tempName(strings.Repeat("a", 31))
+	require.Nil(t, err)
+	assert.Len(t, name, maxNameLen)
+}
+
+func Test_Set_RestoreAtomic(t *testing.T) {
+	t.Parallel()
+
+	c := NewClient(NewEmulator())
+	s, err := asSet(c.New("foo", HashIp, Comment(true)))
+	require.Nil(t, err)
+	require.Nil(t, s.Add("1.1.1.1", CommentContent("kept")))
+
+	// nothing is restored if any line fails
+	err = s.RestoreAtomic(strings.NewReader("create foo hash:ip comment\nadd foo 2.2.2.2\nadd foo x\n"))
+	require.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "ipset: can't restore to foo(hash:ip): "))
+	var e *Error
+	require.True(t, errors.As(err, &e))
+	assert.Equal(t, 3, e.Line)
+	info, err := s.List()
+	require.Nil(t, err)
+	require.Len(t, info.Members, 1)
+	assert.Equal(t, "1.1.1.1", info.Members[0].Elem)
+	names, err := c.Names()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"foo"}, names)
+
+	err = s.RestoreAtomic(strings.NewReader("add bar 2.2.2.2\n"))
+	assert.Equal(t, "ipset: can't restore to foo(hash:ip): line \"add bar 2.2.2.2\" is not for set foo", err.Error())
+
+	require.Nil(t, s.RestoreAtomic(strings.NewReader("add foo 2.2.2.2\nadd foo 1.1.1.1 comment \"new\"\n"), true))
+	info, err = s.List()
+	require.Nil(t, err)
+	require.Len(t, info.Members, 2)
+	assert.Equal(t, "1.1.1.1", info.Members[0].Elem)
+	assert.Equal(t, "new", info.Members[0].Comment)
+	assert.Equal(t, "2.2.2.2", info.Members[1].Elem)
+}
@@ -0,0 +1,147 @@
+package ipset
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// RestoreResult is the result of RestoreReport.
+type RestoreResult struct {
+	// Applied is the number of lines restored.
+	Applied int
+	// Rejected are the failed lines in order.
+	Rejected []RejectedLine
+}
+
+// RejectedLine is a line of the restore input which failed.
+type RejectedLine struct {
+	// Line is the number of the line in the input.
+	Line int
+	Text string
+	// Err is an *Error whose Line is Line too. It wraps
+	// ErrInvalidEntry if the line is refused before it's restored,
+	// otherwise the error recognized from the output of ipset, e.g.
+	// ErrEntryExists.
+	Err error
+}
+
+// restoreLine is a line of the restore input with its number.
+type restoreLine struct {
+	n    int
+	text string
+}
+
+func (s set) RestoreReport(r io.Reader, exist ...bool) (*RestoreResult, error) {
+	return s.RestoreReportContext(context.Background(), r, exist...)
+}
+
+func (s set) RestoreReportContext(ctx context.Context, r io.Reader, exist ...bool) (*RestoreResult, error) {
+	res := &RestoreResult{}
+	lines, err := s.checkLines(r, res)
+	if err == nil {
+		err = s.apply(ctx, lines, len(exist) > 0 && exist[0], res)
+	}
+	if err != nil {
+		return res, fmt.Errorf("ipset: can't restore to %s(%s): %w", s.name, s.setType, err)
+	}
+	return res, nil
+}
+
+// checkLines reads the lines of r and parses them like ParseCommands,
+// the lines which can't be parsed or are not for the set are
+// rejected.
+func (s set) checkLines(r io.Reader, res *RestoreResult) ([]restoreLine, error) {
+	cr := &CommandReader{sets: map[string]*Command{
+		s.name: {Action: _create, Set: s.name, Type: s.setType},
+	}}
+	if s.header != nil {
+		cr.sets[s.name].Header = *s.header
+	}
+
+	var lines []restoreLine
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 4096), 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || text[0] == '#' || text == "COMMIT" {
+			continue
+		}
+		cr.line = n
+		c, err := cr.parse(text)
+		if err == nil && (c.Set != s.name || c.To != "") {
+			err = fmt.Errorf("line %q is not for set %s", text, s.name)
+		}
+		if err != nil {
+			res.reject(n, text, &Error{Action: _restore, Set: s.name, Output: err.Error(), Line: n, Err: ErrInvalidEntry})
+			continue
+		}
+		lines = append(lines, restoreLine{n: n, text: text})
+	}
+	return lines, sc.Err()
+}
+
+// apply restores the lines through one process. The ipset utility
+// stops at the first failed line, which is rejected, then the rest
+// lines are fed to another process. If no line is reported, the
+// lines are bisected until the failed ones are found. The lines
+// before the failed one may be applied already, so the first half
+// is restored with -exist. The rest keeps exist, an applied line in
+// it fails again and the rest is bisected in turn.
+func (s set) apply(ctx context.Context, lines []restoreLine, exist bool, res *RestoreResult) error {
+	for len(lines) > 0 {
+		args := []string{_restore}
+		if exist {
+			args = append(args, _exist)
+		}
+		feed := lines
+		out, err := s.client.Backend().Run(ctx, args, &lineReader{
+			next: func(i int) (string, bool) {
+				if i >= len(feed) {
+					return "", false
+				}
+				return feed[i].text, true
+			},
+		})
+		if err == nil {
+			res.Applied += len(lines)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if n, msg := parseLineError(out); n >= 1 && n <= len(lines) {
+			res.Applied += n - 1
+			e := newError(_restore, s.name, "", msg)
+			e.Line = lines[n-1].n
+			res.reject(e.Line, lines[n-1].text, e)
+			lines = lines[n:]
+			continue
+		}
+
+		e := newError(_restore, s.name, "", string(out))
+		if errors.Is(e, ErrPermission) || errors.Is(e, ErrKernelModuleMissing) {
+			// no line can be restored
+			return e
+		}
+		if len(lines) == 1 {
+			e.Line = lines[0].n
+			res.reject(e.Line, lines[0].text, e)
+			return nil
+		}
+		half := len(lines) / 2
+		if err = s.apply(ctx, lines[:half], true, res); err != nil {
+			return err
+		}
+		lines = lines[half:]
+	}
+	return nil
+}
+
+func (res *RestoreResult) reject(n int, text string, err error) {
+	res.Rejected = append(res.Rejected, RejectedLine{Line: n, Text: text, Err: err})
+}
@@ -0,0 +1,119 @@
+package ipset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Set_RestoreReport(t *testing.T) {
+	t.Parallel()
+
+	c := NewClient(NewEmulator())
+	s, err := asSet(c.New("foo", HashIp))
+	require.Nil(t, err)
+	require.Nil(t, s.Add("1.1.1.1"))
+
+	res, err := s.RestoreReport(strings.NewReader(`# feed
+add foo 2.2.2.2
+add foo 1.1.1.1,80
+add foo 1.1.1.1
+
+add foo 3.3.3.3 timeout x
+add bar 4.4.4.4
+add foo 5.5.5.5
+`))
+	require.Nil(t, err)
+	assert.Equal(t, 2, res.Applied)
+	require.Len(t, res.Rejected, 4)
+
+	rejected := func(i int) (int, string, *Error) {
+		var e *Error
+		require.True(t, errors.As(res.Rejected[i].Err, &e))
+		assert.Equal(t, res.Rejected[i].Line, e.Line)
+		return res.Rejected[i].Line, res.Rejected[i].Text, e
+	}
+	n, text, e := rejected(0)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, "add foo 1.1.1.1,80", text)
+	assert.True(t, errors.Is(e, ErrInvalidEntry))
+	n, _, e = rejected(1)
+	assert.Equal(t, 6, n)
+	assert.True(t, errors.Is(e, ErrInvalidEntry))
+	// lines of other sets are refused
+	n, text, e = rejected(2)
+	assert.Equal(t, 7, n)
+	assert.Equal(t, "add bar 4.4.4.4", text)
+	assert.True(t, errors.Is(e, ErrInvalidEntry))
+	assert.Equal(t, `line "add bar 4.4.4.4" is not for set foo`, e.Error())
+	n, _, e = rejected(3)
+	assert.Equal(t, 4, n)
+	assert.True(t, errors.Is(e, ErrEntryExists))
+
+	info, err := s.List()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"1.1.1.1", "2.2.2.2", "5.5.5.5"}, info.Entries)
+
+	res, err = s.RestoreReport(strings.NewReader("add foo 1.1.1.1\nadd foo 6.6.6.6\n"), true)
+	require.Nil(t, err)
+	assert.Equal(t, 2, res.Applied)
+	assert.Len(t, res.Rejected, 0)
+}
+
+func Test_Set_RestoreReport_Bisect(t *testing.T) {
+	t.Parallel()
+
+	var (
+		runs int
+		// 8.0.0.1 is in the set already
+		applied = map[string]bool{"add foo 8.0.0.1": true}
+	)
+	// the backend doesn't report the failed lines, and the lines
+	// before the failed one are applied
+	c := NewClient(BackendFunc(func(_ context.Context, args []string, stdin io.Reader) ([]byte, error) {
+		runs++
+		exist := len(args) > 1 && args[1] == _exist
+		b, _ := ioutil.ReadAll(stdin)
+		for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+			switch {
+			case strings.Contains(line, "6.6.6.6"):
+				return []byte("Hash is full, cannot add more elements"), errors.New("exit status 1")
+			case applied[line] && !exist:
+				return []byte("Element cannot be added to the set: it's already added"), errors.New("exit status 1")
+			}
+			applied[line] = true
+		}
+		return nil, nil
+	}))
+	s := &set{name: "foo", setType: HashIp, client: c}
+
+	var lines []string
+	for i := 1; i <= 8; i++ {
+		lines = append(lines, fmt.Sprintf("add foo %d.0.0.1", i))
+	}
+	lines[5] = "add foo 6.6.6.6"
+	res, err := s.RestoreReport(strings.NewReader(strings.Join(lines, "\n")))
+	require.Nil(t, err)
+	assert.Equal(t, 6, res.Applied)
+	require.Len(t, res.Rejected, 2)
+	assert.Equal(t, 6, res.Rejected[0].Line)
+	assert.True(t, errors.Is(res.Rejected[0].Err, ErrSetFull))
+	// only the retried lines which may be applied get -exist
+	assert.Equal(t, 8, res.Rejected[1].Line)
+	assert.True(t, errors.Is(res.Rejected[1].Err, ErrEntryExists))
+	assert.Equal(t, 9, runs)
+
+	c = NewClient(&recorder{out: "ipset v7.1: Kernel error received: Operation not permitted", err: errors.New("exit status 1")})
+	s = &set{name: "foo", setType: HashIp, client: c}
+	res, err = s.RestoreReport(strings.NewReader(strings.Join(lines, "\n")))
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrPermission))
+	assert.Equal(t, 0, res.Applied)
+}
@@ -0,0 +1,458 @@
+package ipset
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// request is an ipset command line parsed by the backends which
+// carry out commands by themselves instead of forking the ipset
+// utility.
+type request struct {
+	action string
+	name   string
+	// arg is the set type for create, the entry for add, del and
+	// test and the second set name for rename and swap.
+	arg     string
+	exist   bool
+	resolve bool
+	terse   bool
+	names   bool
+	sorted  bool
+	// output is the output mode of list and save, and file is the
+	// file of -file option.
+	output string
+	file   string
+	create createArgs
+	entry  entryArgs
+}
+
+// createArgs holds the options of create command, which are
+// also the header of a set.
+type createArgs struct {
+	family     NetFamily
+	ipRange    string
+	hashSize   uint32
+	maxElem    uint32
+	netmask    uint8
+	markmask   uint32
+	size       uint32
+	bucketsize uint8
+	initval    uint32
+	timeout    uint32
+	hasTimeout bool
+	counters   bool
+	comment    bool
+	skbinfo    bool
+	forceadd   bool
+}
+
+// entryArgs holds the options of add, del and test command, which
+// are also the extensions of an entry.
+type entryArgs struct {
+	timeout    uint32
+	hasTimeout bool
+	packets    uint64
+	bytes      uint64
+	comment    string
+	skbmark    uint32
+	skbmask    uint32
+	skbprio    uint32
+	skbqueue   uint16
+	nomatch    bool
+	wildcard   bool
+	before     string
+	after      string
+}
+
+// dropFlags drops the global options of a command line, e.g. -exist
+// before the command, and the values of -output and -file.
+func dropFlags(words []string) []string {
+	rest := make([]string, 0, len(words))
+	for i := 0; i < len(words); i++ {
+		if i > 0 && words[i-1] == _comment {
+			rest = append(rest, words[i])
+			continue
+		}
+		switch words[i] {
+		case _exist, "-!", _resolve, "-r", _terse, "-t", _names, "-n",
+			_sorted, "-s", "-quiet", "-q":
+		case _output, "-o", _file, "-f":
+			i++
+		default:
+			rest = append(rest, words[i])
+		}
+	}
+	return rest
+}
+
+// parseRequest parses the arguments of an ipset command line.
+func parseRequest(args []string) (*request, error) {
+	r := &request{}
+	rest := make([]string, 0, len(args))
+	// value is set if the next argument is the value of an option
+	var value *string
+	for i, arg := range args {
+		if value != nil {
+			*value, value = arg, nil
+			continue
+		}
+		if i > 0 && args[i-1] == _comment {
+			rest = append(rest, arg)
+			continue
+		}
+		switch arg {
+		case _exist, "-!":
+			r.exist = true
+		case _resolve, "-r":
+			r.resolve = true
+		case _terse, "-t":
+			r.terse = true
+		case _names, "-n":
+			r.names = true
+		case _sorted, "-s":
+			r.sorted = true
+		case _output, "-o":
+			value = &r.output
+		case _file, "-f":
+			value = &r.file
+		case "-quiet", "-q":
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	if value != nil {
+		return nil, fmt.Errorf("Syntax error: missing value of %s", args[len(args)-1])
+	}
+	switch r.output {
+	case "", OutputPlain, OutputSave, OutputXML:
+	default:
+		return nil, fmt.Errorf("Syntax error: unknown output mode '%s'", r.output)
+	}
+
+	if len(rest) == 0 {
+		return nil, fmt.Errorf("Syntax error: no command specified")
+	}
+	r.action = rest[0]
+	rest = rest[1:]
+
+	var err error
+	switch r.action {
+	case _create:
+		if len(rest) < 2 {
+			return nil, fmt.Errorf("Syntax error: missing mandatory arguments of %s", r.action)
+		}
+		r.name, r.arg = rest[0], rest[1]
+		err = r.create.parse(rest[2:])
+	case _add, _del, _test:
+		if len(rest) < 2 {
+			return nil, fmt.Errorf("Syntax error: missing mandatory arguments of %s", r.action)
+		}
+		r.name, r.arg = rest[0], rest[1]
+		err = r.entry.parse(rest[2:])
+	case _rename, _swap:
+		if len(rest) != 2 {
+			return nil, fmt.Errorf("Syntax error: %s requires two set names", r.action)
+		}
+		r.name, r.arg = rest[0], rest[1]
+	case _destroy, _flush, _list, _save:
+		if len(rest) > 1 {
+			return nil, fmt.Errorf("Unknown argument: `%s'", rest[1])
+		}
+		if len(rest) == 1 {
+			r.name = rest[0]
+		}
+	case _restore, _version:
+		if len(rest) > 0 {
+			return nil, fmt.Errorf("Unknown argument: `%s'", rest[0])
+		}
+	default:
+		return nil, fmt.Errorf("Unknown command: `%s'", r.action)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (a *createArgs) parse(args []string) (err error) {
+	for i := 0; i < len(args); i++ {
+		key := args[i]
+		switch key {
+		case _counters:
+			a.counters = true
+			continue
+		case _comment:
+			a.comment = true
+			continue
+		case _skbinfo:
+			a.skbinfo = true
+			continue
+		case _forceadd:
+			a.forceadd = true
+			continue
+		}
+
+		if i+1 == len(args) {
+			return fmt.Errorf("Syntax error: missing value of %s", key)
+		}
+		i++
+		value := args[i]
+		switch key {
+		case _family:
+			if value != string(Inet) && value != string(Inet6) {
+				return fmt.Errorf("Syntax error: unknown family %s", value)
+			}
+			a.family = NetFamily(value)
+		case _range:
+			a.ipRange = value
+		case _hashsize:
+			a.hashSize, err = parseUint32(key, value)
+		case _maxelem:
+			a.maxElem, err = parseUint32(key, value)
+		case _netmask:
+			var n uint32
+			if n, err = parseUint32(key, value); err == nil {
+				a.netmask = uint8(n)
+			}
+		case _markmask:
+			a.markmask, err = parseUint32(key, value)
+		case _size:
+			a.size, err = parseUint32(key, value)
+		case _bucketsize:
+			var n uint32
+			if n, err = parseUint32(key, value); err == nil {
+				a.bucketsize = uint8(n)
+			}
+		case _initval:
+			a.initval, err = parseUint32(key, value)
+		case _timeout:
+			a.timeout, err = parseUint32(key, value)
+			a.hasTimeout = true
+		default:
+			return fmt.Errorf("Unknown argument: `%s'", key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *entryArgs) parse(args []string) (err error) {
+	for i := 0; i < len(args); i++ {
+		key := args[i]
+		if key == _nomatch {
+			a.nomatch = true
+			continue
+		}
+		if key == _wildcard {
+			a.wildcard = true
+			continue
+		}
+
+		if i+1 == len(args) {
+			return fmt.Errorf("Syntax error: missing value of %s", key)
+		}
+		i++
+		value := args[i]
+		switch key {
+		case _timeout:
+			a.timeout, err = parseUint32(key, value)
+			a.hasTimeout = true
+		case _packets:
+			a.packets, err = strconv.ParseUint(value, 10, 64)
+		case _bytes:
+			a.bytes, err = strconv.ParseUint(value, 10, 64)
+		case _comment:
+			a.comment = value
+		case _skbmark:
+			a.skbmark, a.skbmask, err = parseSkbmark(value)
+		case _skbprio:
+			a.skbprio, err = parseSkbprio(value)
+		case _skbqueue:
+			var n uint64
+			if n, err = strconv.ParseUint(value, 10, 16); err == nil {
+				a.skbqueue = uint16(n)
+			}
+		case _before:
+			a.before = value
+		case _after:
+			a.after = value
+		default:
+			return fmt.Errorf("Unknown argument: `%s'", key)
+		}
+		if err != nil {
+			return fmt.Errorf("Syntax error: invalid value %s of %s", value, key)
+		}
+	}
+	return nil
+}
+
+func parseUint32(key, value string) (uint32, error) {
+	n, err := strconv.ParseUint(value, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Syntax error: invalid value %s of %s", value, key)
+	}
+	return uint32(n), nil
+}
+
+// parseSkbmark parses MARK or MARK/MASK, the mask is 0xffffffff
+// if it's left out.
+func parseSkbmark(s string) (mark, mask uint32, err error) {
+	mask = 0xffffffff
+	if i := strings.IndexByte(s, '/'); i != -1 {
+		var n uint64
+		if n, err = strconv.ParseUint(s[i+1:], 0, 32); err != nil {
+			return
+		}
+		mask = uint32(n)
+		s = s[:i]
+	}
+	var n uint64
+	n, err = strconv.ParseUint(s, 0, 32)
+	mark = uint32(n)
+	return
+}
+
+// parseSkbprio parses MAJOR:MINOR, where numbers are hex without
+// 0x prefix.
+func parseSkbprio(s string) (uint32, error) {
+	i := strings.IndexByte(s, ':')
+	if i == -1 {
+		return 0, fmt.Errorf("invalid skbprio %s", s)
+	}
+	major, err := strconv.ParseUint(s[:i], 16, 16)
+	if err != nil {
+		return 0, err
+	}
+	minor, err := strconv.ParseUint(s[i+1:], 16, 16)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(major<<16 | minor), nil
+}
+
+// splitLine splits a line of restore input into words, double
+// quoted words may contain spaces.
+func splitLine(line string) ([]string, error) {
+	var (
+		words  []string
+		b      strings.Builder
+		quoted bool
+		inWord bool
+	)
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inWord = true
+		case !quoted && (r == ' ' || r == '\t' || r == '\r' || r == '\n'):
+			if inWord {
+				words = append(words, b.String())
+				b.Reset()
+				inWord = false
+			}
+		default:
+			b.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("Syntax error: missing close quote in line %q", line)
+	}
+	if inWord {
+		words = append(words, b.String())
+	}
+	return words, nil
+}
+
+// joinLine joins words into a line of restore input, words with
+// spaces are double quoted. Words with line breaks or double quotes
+// are refused with ErrInvalidEntry, they would end the line or the
+// quoting and inject other commands.
+func joinLine(words []string) (string, error) {
+	var b strings.Builder
+	for i, w := range words {
+		if strings.ContainsAny(w, "\n\r\"") {
+			return "", fmt.Errorf("%w: %q has a line break or double quote", ErrInvalidEntry, w)
+		}
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		if w == "" || strings.ContainsAny(w, " \t") {
+			b.WriteString("\"" + w + "\"")
+		} else {
+			b.WriteString(w)
+		}
+	}
+	return b.String(), nil
+}
+
+// restoreLines reads commands line by line from stdin and carries
+// them out with do, it stops at the first failed line like the
+// ipset utility, or once ctx is done.
+func restoreLines(ctx context.Context, stdin io.Reader, exist bool, do func(r *request) error) error {
+	if stdin == nil {
+		return nil
+	}
+	s := bufio.NewScanner(stdin)
+	s.Buffer(make([]byte, 0, 4096), 1<<20)
+	for n := 1; s.Scan(); n++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' || line == "COMMIT" {
+			continue
+		}
+		words, err := splitLine(line)
+		if err == nil {
+			var r *request
+			if r, err = parseRequest(words); err == nil {
+				r.exist = r.exist || exist
+				if r.action == _restore {
+					err = fmt.Errorf("Restore command is not supported in restore mode")
+				} else {
+					err = do(r)
+				}
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("Error in line %d: %s", n, err)
+		}
+	}
+	return s.Err()
+}
+
+// withFile carries out the request with the file of -file option,
+// which is the input of restore or the output of the others.
+func withFile(req *request, stdin io.Reader, out io.Writer, do func(stdin io.Reader, out io.Writer) error) (err error) {
+	if req.file == "" {
+		return do(stdin, out)
+	}
+	if req.action == _restore {
+		f, err := os.Open(req.file)
+		if err != nil {
+			return fmt.Errorf("Cannot open %s for reading: %s", req.file, err)
+		}
+		defer func() { _ = f.Close() }()
+		return do(f, out)
+	}
+
+	f, err := os.OpenFile(req.file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("Cannot open %s for writing: %s", req.file, err)
+	}
+	defer func() {
+		if e := f.Close(); err == nil {
+			err = e
+		}
+	}()
+	return do(stdin, f)
+}
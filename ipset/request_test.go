@@ -0,0 +1,66 @@
+package ipset
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Request_Parse(t *testing.T) {
+	t.Parallel()
+
+	t.Run("create", func(t *testing.T) {
+		r, err := parseRequest([]string{_create, "foo", "hash:ip", "timeout", "0",
+			"counters", "comment", "family", "inet6", "hashsize", "64", "-exist"})
+		require.Nil(t, err)
+		assert.Equal(t, "foo", r.name)
+		assert.Equal(t, "hash:ip", r.arg)
+		assert.True(t, r.exist)
+		assert.True(t, r.create.hasTimeout)
+		assert.True(t, r.create.counters)
+		assert.True(t, r.create.comment)
+		assert.Equal(t, Inet6, r.create.family)
+		assert.Equal(t, uint32(64), r.create.hashSize)
+	})
+
+	t.Run("add", func(t *testing.T) {
+		r, err := parseRequest([]string{_add, "foo", "1.1.1.1", "comment", "-exist",
+			"skbmark", "0x10/0xff", "skbprio", "1:10", "nomatch"})
+		require.Nil(t, err)
+		assert.False(t, r.exist)
+		assert.Equal(t, "-exist", r.entry.comment)
+		assert.Equal(t, uint32(0x10), r.entry.skbmark)
+		assert.Equal(t, uint32(0xff), r.entry.skbmask)
+		assert.Equal(t, uint32(0x10010), r.entry.skbprio)
+		assert.True(t, r.entry.nomatch)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for _, args := range [][]string{
+			nil,
+			{"unknown"},
+			{_create, "foo"},
+			{_create, "foo", "hash:ip", "family", "ipx"},
+			{_create, "foo", "hash:ip", "hashsize"},
+			{_add, "foo"},
+			{_add, "foo", "1.1.1.1", "timeout", "x"},
+			{_swap, "foo"},
+			{_list, "foo", "bar"},
+		} {
+			_, err := parseRequest(args)
+			assert.Error(t, err, args)
+		}
+	})
+}
+
+func Test_Request_SplitLine(t *testing.T) {
+	t.Parallel()
+
+	words, err := splitLine(`add foo 1.1.1.1 comment "a b  c" timeout 10`)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"add", "foo", "1.1.1.1", "comment", "a b  c", "timeout", "10"}, words)
+
+	_, err = splitLine(`add foo 1.1.1.1 comment "a b`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,249 @@
+package ipset
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// SaveAll dumps all the sets in a format that RestoreAll can read.
+// Entries of list:set sets are put last, so that the member sets
+// exist when they are added. Other lines are kept as ipset prints
+// them. The Sorted and Prefix options are supported.
+func (c *Client) SaveAll(options ...Option) (io.Reader, error) {
+	return c.SaveAllContext(context.Background(), options...)
+}
+
+// SaveAllContext is like SaveAll but the command is given up once
+// ctx is done.
+func (c *Client) SaveAllContext(ctx context.Context, options ...Option) (io.Reader, error) {
+	b := &bytes.Buffer{}
+	if err := c.SaveAllToContext(ctx, b, options...); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// SaveAllTo is like SaveAll but the dump is written to w while it's
+// produced by backends which are Streamers, so it's never held in
+// memory at once.
+func (c *Client) SaveAllTo(w io.Writer, options ...Option) error {
+	return c.SaveAllToContext(context.Background(), w, options...)
+}
+
+// SaveAllToContext is like SaveAllTo but the command is given up
+// once ctx is done.
+func (c *Client) SaveAllToContext(ctx context.Context, w io.Writer, options ...Option) error {
+	o := acquireOptions().apply(options...)
+	args := []string{_save}
+	if o.sorted {
+		args = append(args, _sorted)
+	}
+	f := newDumpFilter(o.prefix)
+	releaseOptions(o)
+
+	var ferr error
+	out, err := c.stream(ctx, args, func(r io.Reader) error {
+		bw := bufio.NewWriter(w)
+		write := func(lines []string) {
+			for _, line := range lines {
+				if ferr == nil {
+					_, ferr = bw.WriteString(line + "\n")
+				}
+			}
+		}
+		s := bufio.NewScanner(r)
+		s.Buffer(make([]byte, 0, 4096), 1<<20)
+		for s.Scan() && ferr == nil {
+			write(f.filter(s.Text()))
+		}
+		if ferr == nil {
+			ferr = s.Err()
+		}
+		write(f.flush())
+		if ferr == nil {
+			ferr = bw.Flush()
+		}
+		return ferr
+	})
+	switch {
+	case err == nil:
+		return nil
+	case ferr != nil && err == ferr:
+		return fmt.Errorf("ipset: can't save all sets: %w", err)
+	}
+	return fmt.Errorf("ipset: can't save all sets: %w", failure(ctx, _save, "", "", out))
+}
+
+// SaveAllToFile is like SaveAll but the dump is written to the file.
+func (c *Client) SaveAllToFile(filename string, options ...Option) error {
+	return c.SaveAllToFileContext(context.Background(), filename, options...)
+}
+
+// SaveAllToFileContext is like SaveAllToFile but the command is given
+// up once ctx is done.
+func (c *Client) SaveAllToFileContext(ctx context.Context, filename string, options ...Option) error {
+	o := acquireOptions().apply(options...)
+	compress := o.compress
+	releaseOptions(o)
+
+	return writeFile(filename, compress, true, func(w io.Writer) error {
+		return c.SaveAllToContext(ctx, w, options...)
+	})
+}
+
+// RestoreAll restores the sets dumped by SaveAll or ipset save in
+// one restore command, the input is streamed to it. Entries of
+// list:set sets are held back until their member sets may have been
+// created, i.e. until a command other than create and add or the
+// end. Other lines are passed as they are. The Exist and Prefix
+// options are supported, lines of sets without the prefix are
+// skipped.
+func (c *Client) RestoreAll(r io.Reader, options ...Option) error {
+	return c.RestoreAllContext(context.Background(), r, options...)
+}
+
+// RestoreAllContext is like RestoreAll but the command is given up
+// once ctx is done.
+func (c *Client) RestoreAllContext(ctx context.Context, r io.Reader, options ...Option) error {
+	o := acquireOptions().apply(options...)
+	args := []string{_restore}
+	if o.exist {
+		args = append(args, _exist)
+	}
+	f := newDumpFilter(o.prefix)
+	releaseOptions(o)
+
+	var (
+		s       = bufio.NewScanner(r)
+		pending []string
+		done    bool
+		serr    error
+	)
+	s.Buffer(make([]byte, 0, 4096), 1<<20)
+	lines := &lineReader{
+		next: func(int) (string, bool) {
+			for len(pending) == 0 {
+				if done {
+					return "", false
+				}
+				if s.Scan() {
+					pending = f.filter(s.Text())
+					continue
+				}
+				if serr = s.Err(); serr != nil {
+					return "", false
+				}
+				pending, done = f.flush(), true
+			}
+			line := pending[0]
+			pending = pending[1:]
+			return line, true
+		},
+	}
+	out, err := c.Backend().Run(ctx, args, lines)
+	if serr != nil {
+		return fmt.Errorf("ipset: can't restore all sets: %w", serr)
+	}
+	if err != nil {
+		return fmt.Errorf("ipset: can't restore all sets: %w", failure(ctx, _restore, "", "", out))
+	}
+	return nil
+}
+
+// RestoreAllFromFile is like RestoreAll but the dump is read from the
+// file. The checksum written by SaveAllToFile is verified if the file
+// has one, the RequireChecksum option refuses files without it.
+func (c *Client) RestoreAllFromFile(filename string, options ...Option) error {
+	return c.RestoreAllFromFileContext(context.Background(), filename, options...)
+}
+
+// RestoreAllFromFileContext is like RestoreAllFromFile but the command
+// is given up once ctx is done.
+func (c *Client) RestoreAllFromFileContext(ctx context.Context, filename string, options ...Option) (err error) {
+	o := acquireOptions().apply(options...)
+	required := o.reqChecksum
+	releaseOptions(o)
+
+	f, err := openFile(filename)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if e := f.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+	// the file is read through and verified before anything is
+	// restored, since restore applies the lines while reading them
+	if err = f.verify(required); err != nil {
+		return fmt.Errorf("ipset: can't restore all sets: %w", err)
+	}
+	return c.RestoreAllContext(ctx, f, options...)
+}
+
+// dumpFilter passes the lines of a dump through as they are, only
+// the command and set names are looked at. Lines of sets without
+// the prefix are dropped, and adds of list:set sets are held back
+// until another command than create and add comes, or the end.
+type dumpFilter struct {
+	prefix string
+	// lists are the names of list:set sets created so far
+	lists map[string]bool
+	held  []string
+}
+
+func newDumpFilter(prefix string) *dumpFilter {
+	return &dumpFilter{prefix: prefix, lists: make(map[string]bool)}
+}
+
+// filter returns the lines to pass for the line.
+func (f *dumpFilter) filter(line string) []string {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return nil
+	}
+	// restore lines may start with flags, e.g. -exist
+	words := append(dropFlags(strings.Fields(line)), "", "", "")
+	action, name, arg := words[0], words[1], words[2]
+
+	if f.prefix != "" {
+		switch {
+		case action == "COMMIT":
+		case !strings.HasPrefix(name, f.prefix):
+			return nil
+		case (action == _rename || action == _swap) && !strings.HasPrefix(arg, f.prefix):
+			return nil
+		}
+	}
+
+	switch action {
+	case _create:
+		if SetType(arg) == ListSet {
+			f.lists[name] = true
+		}
+		return []string{line}
+	case _add:
+		if f.lists[name] {
+			f.held = append(f.held, line)
+			return nil
+		}
+		return []string{line}
+	case _rename:
+		f.lists[name], f.lists[arg] = false, f.lists[name]
+	case _swap:
+		f.lists[name], f.lists[arg] = f.lists[arg], f.lists[name]
+	}
+	// the held adds go first, the command may depend on them
+	return append(f.flush(), line)
+}
+
+// flush returns the held lines.
+func (f *dumpFilter) flush() []string {
+	held := f.held
+	f.held = nil
+	return held
+}
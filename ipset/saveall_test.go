@@ -0,0 +1,135 @@
+package ipset
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Client_SaveAll(t *testing.T) {
+	t.Parallel()
+
+	c := NewClient(NewEmulator())
+	l, err := c.New("a-list", ListSet)
+	require.Nil(t, err)
+	ip, err := c.New("a-ip", HashIp)
+	require.Nil(t, err)
+	require.Nil(t, ip.Add("1.1.1.1"))
+	require.Nil(t, l.Add("a-ip"))
+	other, err := c.New("b-net", HashNet)
+	require.Nil(t, err)
+	require.Nil(t, other.Add("10.0.0.0/8"))
+
+	r, err := c.SaveAll()
+	require.Nil(t, err)
+	b, err := ioutil.ReadAll(r)
+	require.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	// only the list:set entry is moved
+	assert.Equal(t, []string{
+		"create a-list list:set size 8",
+		"create a-ip hash:ip family inet hashsize 1024 maxelem 65536",
+		"add a-ip 1.1.1.1",
+		"create b-net hash:net family inet hashsize 1024 maxelem 65536",
+		"add b-net 10.0.0.0/8",
+		"add a-list a-ip",
+	}, lines)
+
+	// the dump is restored in order
+	c2 := NewClient(NewEmulator())
+	require.Nil(t, c2.RestoreAll(strings.NewReader(string(b))))
+	infos, err := c2.ListAll()
+	require.Nil(t, err)
+	require.Len(t, infos, 3)
+	assert.Equal(t, []string{"a-ip"}, infos[0].Entries)
+
+	// ipset save output is ordered too
+	require.Nil(t, NewClient(NewEmulator()).RestoreAll(strings.NewReader(
+		"create l list:set size 8\nadd l s\ncreate s hash:ip\n")))
+
+	r, err = c.SaveAll(Prefix("a-"))
+	require.Nil(t, err)
+	b, err = ioutil.ReadAll(r)
+	require.Nil(t, err)
+	assert.NotContains(t, string(b), "b-net")
+
+	// sets without the prefix are left alone
+	c3 := NewClient(NewEmulator())
+	_, err = c3.New("b-x", HashIp)
+	require.Nil(t, err)
+	require.Nil(t, c3.RestoreAll(strings.NewReader(
+		"create a-x hash:ip\ncreate b-y hash:ip\nflush b-x\ndestroy\n"), Prefix("a-")))
+	names, err := c3.Names()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"b-x", "a-x"}, names)
+
+	err = c3.RestoreAll(strings.NewReader("create a-x hash:ip\n"))
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrSetExists))
+	require.Nil(t, c3.RestoreAll(strings.NewReader("create a-x hash:ip\n"), Exist(true)))
+
+	// unknown lines are passed to ipset as they are
+	err = c3.RestoreAll(strings.NewReader("create a-y hash:ip\ncreate a-z hash:ip bitmask 255.255.255.0\n"))
+	assert.Equal(t, "ipset: can't restore all sets: Error in line 2: Unknown argument: `bitmask'", err.Error())
+
+	// held entries go before other commands
+	c4 := NewClient(NewEmulator())
+	require.Nil(t, c4.RestoreAll(strings.NewReader(
+		"create l list:set size 8\nadd l s\ncreate s hash:ip\ndel l s\n")))
+	info, err := c4.ListAll()
+	require.Nil(t, err)
+	assert.Len(t, info[0].Entries, 0)
+
+	// leading flags are skipped to find the command and set
+	c5 := NewClient(NewEmulator())
+	require.Nil(t, c5.RestoreAll(strings.NewReader(
+		"create a-l list:set size 8\n-exist add a-l a-s\ncreate a-s hash:ip\n-! add a-s 1.1.1.1\n"), Prefix("a-")))
+	info, err = c5.ListAll()
+	require.Nil(t, err)
+	require.Len(t, info, 2)
+	assert.Equal(t, []string{"a-s"}, info[0].Entries)
+	assert.Equal(t, []string{"1.1.1.1"}, info[1].Entries)
+}
+
+func Test_Client_SaveAllToFile(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "ipset")
+	require.Nil(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	filename := filepath.Join(dir, "sets")
+
+	c := NewClient(NewEmulator())
+	s, err := c.New("foo", HashIp)
+	require.Nil(t, err)
+	require.Nil(t, s.Add("1.1.1.1"))
+	require.Nil(t, c.SaveAllToFile(filename, Sorted(true)))
+
+	var b strings.Builder
+	require.Nil(t, c.SaveAllTo(&b))
+	assert.Equal(t, "create foo hash:ip family inet hashsize 1024 maxelem 65536\nadd foo 1.1.1.1\n", b.String())
+
+	c2 := NewClient(NewEmulator())
+	require.Nil(t, c2.RestoreAllFromFile(filename))
+	info, err := c2.ListAll()
+	require.Nil(t, err)
+	require.Len(t, info, 1)
+	assert.Equal(t, "foo", info[0].Name)
+
+	assert.Error(t, c2.RestoreAllFromFile(filepath.Join(dir, "missing")))
+
+	// ipset save output has no checksum
+	plain := filepath.Join(dir, "plain")
+	require.Nil(t, ioutil.WriteFile(plain, []byte("create bar hash:ip\n"), 0600))
+	assert.True(t, errors.Is(c2.RestoreAllFromFile(plain, RequireChecksum(true)), ErrChecksum))
+	require.Nil(t, c2.RestoreAllFromFile(plain))
+	names, err := c2.Names()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"foo", "bar"}, names)
+}
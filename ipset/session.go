@@ -0,0 +1,338 @@
+package ipset
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sync"
+)
+
+// ErrSessionClosed is returned by a closed session.
+var ErrSessionClosed = errors.New("ipset: session is closed")
+
+// compiler assert
+var _ Backend = (*Session)(nil)
+
+// Session is a Backend which keeps one interactive ipset process,
+// i.e. `ipset -`, and writes commands to it line by line instead of
+// forking the utility for every command. Commands from many
+// goroutines are pipelined, and every response or error is matched
+// to its command. The process is restarted by the next command if
+// it dies, the commands in flight fail then.
+//
+// Responses are told apart by the prompt "ipset> ", so the utility
+// must flush it although its output is a pipe. Builds which buffer
+// the output never respond, so commands should be given a ctx with
+// a deadline: once a ctx is done, the command is abandoned, and the
+// process is killed if no other command waits for it, then the next
+// command restarts it. Use ExecBackend or NetlinkBackend if the prompt
+// doesn't come.
+//
+// Words with line breaks or double quotes are refused with
+// ErrInvalidEntry, they would inject commands into the process.
+//
+// Commands with stdin, e.g. restore, are run by a forked utility.
+type Session struct {
+	path string
+
+	mu     sync.Mutex // guards proc and closed
+	proc   *sessionProc
+	closed bool
+}
+
+// NewSession creates a session with the ipset utility of path, the
+// one found by Check is used if it's empty. The process is started
+// by the first command.
+func NewSession(path string) *Session {
+	return &Session{path: path}
+}
+
+type sessionReply struct {
+	out []byte
+	err error
+}
+
+// sessionProc is a running interactive process.
+type sessionProc struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	done  chan struct{}
+	// wake tells the writer that lines are queued
+	wake chan struct{}
+
+	mu      sync.Mutex // guards the fields below
+	pending []chan sessionReply
+	// abandoned are the pending replies nobody waits for
+	abandoned map[chan sessionReply]bool
+	// queue are the lines not written yet in the order of pending
+	queue   []string
+	closing bool
+	// err is set once the process exits
+	err error
+}
+
+// Run writes the command to the interactive process and waits for
+// its response.
+func (s *Session) Run(ctx context.Context, args []string, stdin io.Reader) ([]byte, error) {
+	if stdin != nil {
+		return ExecBackend{Path: s.path}.Run(ctx, args, stdin)
+	}
+	if err := ctx.Err(); err != nil {
+		return []byte(err.Error()), err
+	}
+
+	line, err := joinLine(args)
+	if err != nil {
+		return []byte(err.Error()), err
+	}
+	reply := make(chan sessionReply, 1)
+	p, err := s.send(line, reply)
+	if err != nil {
+		return []byte(err.Error()), err
+	}
+
+	select {
+	case r := <-reply:
+		return r.out, r.err
+	case <-ctx.Done():
+	}
+	select {
+	case r := <-reply:
+		return r.out, r.err
+	default:
+		// the process may be stuck, it's restarted by the next
+		// command once nobody else waits for it
+		if p.abandon(reply) {
+			p.kill()
+		}
+		return []byte(ctx.Err().Error()), ctx.Err()
+	}
+}
+
+// send queues reply and the line, which is written by the writer of
+// the process, so a stuck process never blocks the callers. The
+// process is started if it's not running.
+func (s *Session) send(line string, reply chan sessionReply) (*sessionProc, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrSessionClosed
+	}
+
+	p := s.proc
+	if p != nil {
+		p.mu.Lock()
+		if p.err != nil {
+			p.mu.Unlock()
+			p = nil
+		}
+	}
+	if p == nil {
+		var err error
+		if p, err = s.start(); err != nil {
+			return nil, err
+		}
+		s.proc = p
+		p.mu.Lock()
+	}
+	p.pending = append(p.pending, reply)
+	p.queue = append(p.queue, line)
+	p.mu.Unlock()
+	p.signal()
+	return p, nil
+}
+
+func (s *Session) start() (*sessionProc, error) {
+	path := s.path
+	if path == "" {
+		path = utilityPath()
+	}
+
+	// stdout and stderr share one pipe to keep their order
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("ipset: can't start session: %s", err)
+	}
+	c := execCommand(context.Background(), path, "-")
+	c.Stdout, c.Stderr = w, w
+	stdin, err := c.StdinPipe()
+	if err == nil {
+		err = c.Start()
+	}
+	_ = w.Close()
+	if err != nil {
+		_ = r.Close()
+		return nil, fmt.Errorf("ipset: can't start session: %s", err)
+	}
+
+	p := &sessionProc{cmd: c, stdin: stdin, done: make(chan struct{}), wake: make(chan struct{}, 1)}
+	go p.read(r)
+	go p.write()
+	return p, nil
+}
+
+// Close ends the input of the process and waits for it to exit.
+func (s *Session) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	p := s.proc
+	s.proc = nil
+	s.mu.Unlock()
+
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	p.closing = true
+	p.mu.Unlock()
+	p.signal()
+	<-p.done
+	return nil
+}
+
+func (p *sessionProc) signal() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// write writes the queued lines until the process exits, the input
+// is closed once the session is closed and the lines are written.
+// A failed write is reported to the commands once the process
+// exits.
+func (p *sessionProc) write() {
+	for {
+		select {
+		case <-p.wake:
+		case <-p.done:
+			return
+		}
+		p.mu.Lock()
+		lines, closing := p.queue, p.closing
+		p.queue = nil
+		p.mu.Unlock()
+
+		for _, line := range lines {
+			if _, err := io.WriteString(p.stdin, line+"\n"); err != nil {
+				p.kill()
+				return
+			}
+		}
+		if closing {
+			_ = p.stdin.Close()
+			return
+		}
+	}
+}
+
+// kill kills the process, the commands in flight fail once it
+// exits. The process is taken as exited at once, so that the next
+// command doesn't wait for it.
+func (p *sessionProc) kill() {
+	p.mu.Lock()
+	if p.err == nil {
+		p.err = errSessionKilled
+	}
+	p.mu.Unlock()
+	_ = p.cmd.Process.Kill()
+}
+
+var errSessionKilled = errors.New("ipset: session process is killed")
+
+// abandon marks the reply as nobody waits for it, the response is
+// still read to keep the order. It reports whether every pending
+// reply is abandoned, false if the reply is already handed.
+func (p *sessionProc) abandon(reply chan sessionReply) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, r := range p.pending {
+		if r == reply {
+			if p.abandoned == nil {
+				p.abandoned = make(map[chan sessionReply]bool)
+			}
+			p.abandoned[reply] = true
+			return len(p.abandoned) == len(p.pending)
+		}
+	}
+	return false
+}
+
+var sessionPrompt = []byte("ipset> ")
+
+// read reads the responses, which are ended by the prompt, and
+// hands them to the pending commands in order.
+func (p *sessionProc) read(r io.ReadCloser) {
+	defer close(p.done)
+
+	br := bufio.NewReader(r)
+	// the prompt is printed once the process starts
+	_, err := readResponse(br)
+	for err == nil {
+		var out []byte
+		if out, err = readResponse(br); err != nil {
+			break
+		}
+		p.mu.Lock()
+		if len(p.pending) > 0 {
+			reply := p.pending[0]
+			p.pending = p.pending[1:]
+			delete(p.abandoned, reply)
+			reply <- newSessionReply(out)
+		}
+		p.mu.Unlock()
+	}
+	_ = r.Close()
+
+	err = p.cmd.Wait()
+	if err == nil {
+		err = errors.New("exit status 0")
+	}
+	err = fmt.Errorf("ipset: session process exited: %s", err)
+
+	p.mu.Lock()
+	p.err = err
+	for _, reply := range p.pending {
+		reply <- sessionReply{out: []byte(err.Error()), err: err}
+	}
+	p.pending, p.abandoned = nil, nil
+	p.mu.Unlock()
+}
+
+// readResponse reads the output until the next prompt.
+func readResponse(br *bufio.Reader) ([]byte, error) {
+	var out []byte
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+		if bytes.HasSuffix(out, sessionPrompt) {
+			return out[:len(out)-len(sessionPrompt)], nil
+		}
+	}
+}
+
+var (
+	// sessionError matches the error messages, which are prefixed
+	// with the program name and version, e.g. "ipset v7.1: ".
+	sessionError = regexp.MustCompile(`(?m)^ipset v[^\s,:]*: `)
+	sessionNot   = []byte(" is NOT in set ")
+)
+
+func newSessionReply(out []byte) sessionReply {
+	if sessionError.Match(out) || bytes.Contains(out, sessionNot) {
+		out = bytes.TrimSpace(out)
+		return sessionReply{out: out, err: errors.New(string(out))}
+	}
+	return sessionReply{out: out}
+}
@@ -0,0 +1,192 @@
+package ipset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Session(t *testing.T) {
+	setupCmd()
+	defer teardownCmd()
+
+	ss := NewSession("ipset")
+	defer func() { assert.Nil(t, ss.Close()) }()
+	c := NewClient(ss)
+
+	s, err := c.New("foo", HashIp)
+	require.Nil(t, err)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- s.Add(fmt.Sprintf("10.0.0.%d", i))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.Nil(t, err)
+	}
+
+	ok, err := s.Test("10.0.0.99")
+	require.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = s.Test("10.0.1.1")
+	require.Nil(t, err)
+	assert.False(t, ok)
+
+	err = s.Add("10.0.0.1")
+	require.Error(t, err)
+	assert.Equal(t,
+		"ipset: can't add foo 10.0.0.1: ipset v7.1: Element cannot be added to the set: it's already added",
+		err.Error())
+
+	info, err := s.List()
+	require.Nil(t, err)
+	assert.Equal(t, 100, info.NumEntries)
+
+	names, err := c.Names()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"foo"}, names)
+}
+
+func Test_Session_Restart(t *testing.T) {
+	setupCmd()
+	defer teardownCmd()
+
+	ss := NewSession("ipset")
+	_, err := ss.Run(context.Background(), []string{_create, "foo", string(HashIp)}, nil)
+	require.Nil(t, err)
+
+	out, err := ss.Run(context.Background(), []string{"die"}, nil)
+	require.Error(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "ipset: session process exited: "))
+
+	// the new process knows nothing about foo
+	_, err = ss.Run(context.Background(), []string{_create, "foo", string(HashIp)}, nil)
+	require.Nil(t, err)
+
+	require.Nil(t, ss.Close())
+	_, err = ss.Run(context.Background(), []string{_list}, nil)
+	assert.Equal(t, ErrSessionClosed, err)
+}
+
+func Test_Session_Context(t *testing.T) {
+	setupCmd()
+	defer teardownCmd()
+
+	ss := NewSession("ipset")
+	defer func() { assert.Nil(t, ss.Close()) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := ss.Run(ctx, []string{"sleep"}, nil)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// the late response of sleep is dropped
+	out, err := ss.Run(context.Background(), []string{_create, "foo", string(HashIp)}, nil)
+	require.Nil(t, err)
+	assert.Equal(t, "", string(out))
+
+	_, err = ss.Run(ctx, []string{_list}, nil)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func Test_Session_ContextOthers(t *testing.T) {
+	setupCmd()
+	defer teardownCmd()
+
+	ss := NewSession("ipset")
+	defer func() { assert.Nil(t, ss.Close()) }()
+
+	errs := make(chan error, 1)
+	go func() {
+		out, err := ss.Run(context.Background(), []string{"sleep"}, nil)
+		if strings.HasPrefix(string(out), "ipset: session process") {
+			errs <- err
+		}
+		close(errs)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := ss.Run(ctx, []string{_create, "foo", string(HashIp)}, nil)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// sleep is still answered by the same process
+	assert.Nil(t, <-errs)
+	_, err = ss.Run(context.Background(), []string{_list, "foo"}, nil)
+	assert.Nil(t, err)
+}
+
+func Test_Session_Injection(t *testing.T) {
+	ss := NewSession("ipset")
+	defer func() { assert.Nil(t, ss.Close()) }()
+
+	_, err := ss.Run(context.Background(), []string{_add, "foo", "1.1.1.1\ndestroy"}, nil)
+	assert.True(t, errors.Is(err, ErrInvalidEntry))
+	assert.Nil(t, ss.proc)
+}
+
+func Test_Session_NoPrompt(t *testing.T) {
+	setupCmd()
+	defer teardownCmd()
+
+	ss := NewSession("ipset")
+	defer func() { assert.Nil(t, ss.Close()) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := ss.Run(ctx, []string{"mute"}, nil)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// the muted process is replaced
+	start := time.Now()
+	_, err = ss.Run(context.Background(), []string{_create, "foo", string(HashIp)}, nil)
+	require.Nil(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func Test_Session_Stdin(t *testing.T) {
+	setupCmd(flag)
+	defer teardownCmd()
+
+	ss := NewSession("ipset")
+	defer func() { assert.Nil(t, ss.Close()) }()
+
+	out, err := ss.Run(context.Background(), []string{_restore}, strings.NewReader("add foo 1.1.1.1\n"))
+	require.Error(t, err)
+	assert.Equal(t, "fake error", string(out))
+}
+
+func Test_NewSessionReply(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		out    string
+		failed bool
+	}{
+		{"", false},
+		{"1.1.1.1 is in set foo.\n", false},
+		{"ipset v7.1, protocol version: 7\n", false},
+		{"ipset v7.1: The set with the given name does not exist\n", true},
+		{"1.1.1.1 is NOT in set foo.\n", true},
+	}
+
+	for _, tc := range tt {
+		r := newSessionReply([]byte(tc.out))
+		assert.Equal(t, tc.failed, r.err != nil, tc.out)
+	}
+}
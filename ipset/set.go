@@ -3,22 +3,31 @@ package ipset
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
-	"sync"
 )
 
 // compiler assert
-var _ IPSet = (*set)(nil)
+var (
+	_ IPSet         = (*set)(nil)
+	_ IPSetContext  = (*set)(nil)
+	_ EntryStreamer = (*set)(nil)
+	_ Batcher       = (*set)(nil)
+	_ Replacer      = (*set)(nil)
+	_ Restorer      = (*set)(nil)
+	_ Reconciler    = (*set)(nil)
+)
 
 type set struct {
 	name    string
 	setType SetType
+	// header is known if the set is created or opened
+	header *Header
+	client *Client
 }
 
 // Info holds ipset list contents
@@ -26,16 +35,30 @@ type Info struct {
 	Name string
 	SetType
 	Revision     int
-	Header       string
+	Header       Header
 	SizeInMemory int
 	References   int
-	Entries      []string
+	// NumEntries is the number of entries reported by the kernel.
+	NumEntries int
+	Entries    []string
+	// Members are the parsed Entries.
+	Members []*Entry
 }
 
 func (s set) List(options ...Option) (*Info, error) {
+	return s.ListContext(context.Background(), options...)
+}
+
+func (s set) ListContext(ctx context.Context, options ...Option) (*Info, error) {
 	c := getCmd(_list, s.name, s.setType)
 	defer putCmd(c)
-	if err := c.exec(options...); err != nil {
+	o := acquireOptions().apply(options...)
+	output := o.output
+	releaseOptions(o)
+	if output != "" && output != OutputPlain {
+		return nil, c.fail(invalidOption("%s output can't be parsed", output))
+	}
+	if err := c.exec(ctx, s.client, options...); err != nil {
 		return nil, err
 	}
 
@@ -44,41 +67,165 @@ func (s set) List(options ...Option) (*Info, error) {
 		return nil, err
 	}
 	info.Name = s.name
-	info.SetType = s.setType
-	return info, err
+	if info.SetType == "" {
+		info.SetType = s.setType
+	}
+	if err = info.parseMembers(); err != nil {
+		return nil, err
+	}
+	return info, nil
 }
 
+func (s set) ForEachEntry(fn func(*Entry) error, options ...Option) error {
+	return s.ForEachEntryContext(context.Background(), fn, options...)
+}
+
+func (s set) ForEachEntryContext(ctx context.Context, fn func(*Entry) error, options ...Option) error {
+	c := getCmd(_list, s.name, s.setType)
+	defer putCmd(c)
+
+	var ferr error
+	err := c.stream(ctx, s.client, func(r io.Reader) error {
+		return scanInfos(r, func(info *Info, line string) error {
+			if strings.TrimSpace(line) == "" {
+				return nil
+			}
+			setType := info.SetType
+			if setType == "" {
+				setType = s.setType
+			}
+			e, err := parseEntry(setType, info.Header.Family, line)
+			if err != nil {
+				return fmt.Errorf("ipset: can't parse entry %s of %s: %s", line, s.name, err)
+			}
+			ferr = fn(e)
+			return ferr
+		}, func(*Info) error { return nil })
+	}, options...)
+	if ferr != nil && errors.Is(ferr, ErrStop) {
+		return nil
+	}
+	return err
+}
+
+// parseInfo parses the list output of a set.
 func parseInfo(out []byte) (info *Info, err error) {
-	info = &Info{}
-	s := bufio.NewScanner(bytes.NewReader(out))
+	err = readInfos(bytes.NewReader(out), func(i *Info) error {
+		if info == nil {
+			info = i
+		}
+		return nil
+	})
+	if err == nil && info == nil {
+		info = &Info{}
+	}
+	return
+}
+
+// readInfos parses the list output of sets line by line and calls
+// fn with every set once its members are read, so the output of
+// many sets is never held at once. Members are left unparsed.
+func readInfos(r io.Reader, fn func(*Info) error) error {
+	return scanInfos(r, func(info *Info, line string) error {
+		info.Entries = append(info.Entries, line)
+		return nil
+	}, fn)
+}
+
+// scanInfos is like readInfos but every member line is passed to
+// member once it's read instead of being kept in Entries.
+func scanInfos(r io.Reader, member func(info *Info, line string) error, fn func(*Info) error) (err error) {
+	var (
+		s       = bufio.NewScanner(r)
+		info    *Info
+		members bool
+	)
+	s.Buffer(make([]byte, 0, 4096), 1<<20)
+	emit := func() error {
+		if info == nil {
+			return nil
+		}
+		i := info
+		info, members = nil, false
+		return fn(i)
+	}
 
 	for s.Scan() {
 		t := s.Text()
+		if members {
+			// sets are separated by an empty line
+			if t != "" {
+				if err = member(info, t); err != nil {
+					return err
+				}
+				continue
+			}
+			if err = emit(); err != nil {
+				return err
+			}
+			continue
+		}
+		if strings.HasPrefix(t, "Name:") {
+			if err = emit(); err != nil {
+				return err
+			}
+		}
+		if t == "" {
+			continue
+		}
+		if info == nil {
+			info = &Info{}
+		}
+
 		switch {
+		case strings.HasPrefix(t, "Name:"):
+			info.Name = strings.TrimSpace(t[5:])
+		case strings.HasPrefix(t, "Type:"):
+			info.SetType = SetType(strings.TrimSpace(t[5:]))
 		case strings.HasPrefix(t, "Rev"):
 			if info.Revision, err = getNumber(t); err != nil {
-				return nil, err
+				return err
 			}
 		case strings.HasPrefix(t, "H"):
-			info.Header = t[8:]
+			if info.Header, err = parseHeaderLine(t[7:]); err != nil {
+				return fmt.Errorf("ipset: can't parse header %s: %s", t[7:], err)
+			}
 		case strings.HasPrefix(t, "S"):
 			if info.SizeInMemory, err = getNumber(t); err != nil {
-				return nil, err
+				return err
 			}
 		case strings.HasPrefix(t, "Ref"):
 			if info.References, err = getNumber(t); err != nil {
-				return nil, err
+				return err
+			}
+		case strings.HasPrefix(t, "Num"):
+			if info.NumEntries, err = getNumber(t); err != nil {
+				return err
 			}
 		case strings.HasPrefix(t, "M"):
-			goto Entries
+			members = true
 		}
 	}
-Entries:
-	for s.Scan() {
-		info.Entries = append(info.Entries, s.Text())
+	if err = s.Err(); err != nil {
+		return err
 	}
+	return emit()
+}
 
-	return
+// parseMembers parses Entries into Members by the type of the set.
+func (info *Info) parseMembers() error {
+	info.Members = make([]*Entry, 0, len(info.Entries))
+	for _, line := range info.Entries {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		e, err := parseEntry(info.SetType, info.Header.Family, line)
+		if err != nil {
+			return fmt.Errorf("ipset: can't parse entry %s of %s: %s", line, info.Name, err)
+		}
+		info.Members = append(info.Members, e)
+	}
+	return nil
 }
 
 func getNumber(t string) (n int, err error) {
@@ -89,7 +236,11 @@ func getNumber(t string) (n int, err error) {
 }
 
 func (s set) ListToFile(filename string, options ...Option) error {
-	return s.doToFile(_list, filename, options...)
+	return s.ListToFileContext(context.Background(), filename, options...)
+}
+
+func (s set) ListToFileContext(ctx context.Context, filename string, options ...Option) error {
+	return s.doToFile(ctx, _list, filename, options...)
 }
 
 func (s set) Name() string {
@@ -97,170 +248,181 @@ func (s set) Name() string {
 }
 
 func (s set) Rename(newName string) error {
-	return s.do(_rename, newName)
+	return s.RenameContext(context.Background(), newName)
+}
+
+func (s set) RenameContext(ctx context.Context, newName string) error {
+	return s.do(ctx, _rename, newName)
 }
 
 func (s set) Add(entry string, options ...Option) error {
-	return s.do(_add, entry, options...)
+	return s.AddContext(context.Background(), entry, options...)
+}
+
+func (s set) AddContext(ctx context.Context, entry string, options ...Option) error {
+	return s.do(ctx, _add, entry, options...)
 }
 
 func (s set) Del(entry string, options ...Option) error {
-	return s.do(_del, entry, options...)
+	return s.DelContext(context.Background(), entry, options...)
+}
+
+func (s set) DelContext(ctx context.Context, entry string, options ...Option) error {
+	return s.do(ctx, _del, entry, options...)
 }
 
 var notFlag = []byte("NOT")
 
 func (s set) Test(entry string) (bool, error) {
-	out, err := execCommand(ipsetPath, _test, s.name, entry).
-		CombinedOutput()
+	return s.TestContext(context.Background(), entry)
+}
+
+func (s set) TestContext(ctx context.Context, entry string) (bool, error) {
+	out, err := s.client.Backend().Run(ctx, []string{_test, s.name, entry}, nil)
 
 	if err != nil {
-		if bytes.Contains(out, notFlag) {
+		if ctx.Err() == nil && bytes.Contains(out, notFlag) {
 			return false, nil
 		}
-		return false, fmt.Errorf("ipset: can't test %s %s: %s", s.name, entry, out)
+		return false, fmt.Errorf("ipset: can't test %s %s: %w", s.name, entry, failure(ctx, _test, s.name, entry, out))
 	}
 
 	return true, nil
 }
 
 func (s set) Flush() error {
-	return flush(s.name)
+	return s.FlushContext(context.Background())
+}
+
+func (s set) FlushContext(ctx context.Context) error {
+	return s.client.flush(ctx, s.name)
 }
 
 func (s set) Destroy() error {
-	return destroy(s.name)
+	return s.DestroyContext(context.Background())
+}
+
+func (s set) DestroyContext(ctx context.Context) error {
+	return s.client.destroy(ctx, s.name)
 }
 
-func (s set) do(action, entry string, options ...Option) error {
+func (s set) do(ctx context.Context, action, entry string, options ...Option) error {
 	c := getCmd(action, s.name, s.setType, entry)
 	defer putCmd(c)
+	c.header = s.header
 
-	if err := c.exec(options...); err != nil {
+	if err := c.exec(ctx, s.client, options...); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (s set) Save(options ...Option) (io.Reader, error) {
+	return s.SaveContext(context.Background(), options...)
+}
+
+func (s set) SaveContext(ctx context.Context, options ...Option) (io.Reader, error) {
 	c := getCmd(_save, s.name, s.setType)
 	defer putCmd(c)
-	if err := c.exec(options...); err != nil {
+	if err := c.exec(ctx, s.client, options...); err != nil {
 		return nil, err
 	}
 
 	return bytes.NewReader(c.out), nil
 }
 
-func (s set) SaveToFile(filename string, options ...Option) error {
-	return s.doToFile(_save, filename, options...)
+func (s set) SaveTo(w io.Writer, options ...Option) error {
+	return s.SaveToContext(context.Background(), w, options...)
 }
 
-func (s set) doToFile(action, filename string, options ...Option) error {
-	c := getCmd(action, s.name, s.setType)
+func (s set) SaveToContext(ctx context.Context, w io.Writer, options ...Option) error {
+	c := getCmd(_save, s.name, s.setType)
 	defer putCmd(c)
-	if err := c.exec(options...); err != nil {
+	return c.stream(ctx, s.client, func(r io.Reader) error {
+		_, err := io.Copy(w, r)
 		return err
-	}
+	}, options...)
+}
 
-	return ioutil.WriteFile(filename, c.out, 0600)
+func (s set) SaveToFile(filename string, options ...Option) error {
+	return s.SaveToFileContext(context.Background(), filename, options...)
 }
 
-var maxRestoreSize = 1 << 16
+func (s set) SaveToFileContext(ctx context.Context, filename string, options ...Option) error {
+	return s.doToFile(ctx, _save, filename, options...)
+}
 
-func (s set) Restore(r io.Reader, exist ...bool) (err error) {
-	defer func() {
-		if err != nil {
-			err = fmt.Errorf("ipset: can't restore to %s(%s): %s", s.name, s.setType, err)
-		}
-	}()
+func (s set) doToFile(ctx context.Context, action, filename string, options ...Option) error {
+	c := getCmd(action, s.name, s.setType)
+	defer putCmd(c)
+	o := acquireOptions().apply(options...)
+	compress := o.compress
+	releaseOptions(o)
+
+	// save output gets a checksum which RestoreFromFile verifies
+	return writeFile(filename, compress, action == _save, func(w io.Writer) error {
+		return c.stream(ctx, s.client, func(r io.Reader) error {
+			_, err := io.Copy(w, r)
+			return err
+		}, options...)
+	})
+}
 
-	var (
-		br = acquireReader(r)
-		b  = &bytes.Buffer{}
-		bb []byte
-	)
-	defer releaseReader(br)
+func (s set) Restore(r io.Reader, exist ...bool) error {
+	return s.RestoreContext(context.Background(), r, exist...)
+}
 
-	for {
-		bb, err = br.ReadBytes('\n')
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return
-		}
-		if b.Len()+len(bb) > maxRestoreSize {
-			if err = s.restore(b.Bytes(), exist...); err != nil {
-				return
-			}
-			b.Reset()
-		}
-		if _, err = b.Write(bb); err != nil {
-			return
-		}
+func (s set) RestoreContext(ctx context.Context, r io.Reader, exist ...bool) error {
+	if err := s.restore(ctx, r, exist...); err != nil {
+		return fmt.Errorf("ipset: can't restore to %s(%s): %w", s.name, s.setType, err)
 	}
-	return s.restore(b.Bytes(), exist...)
+	return nil
 }
 
-// restore data to ipset and length of b should
-// be less than 64K because of the size limit of
-// pipe.
-func (s set) restore(b []byte, exist ...bool) (err error) {
+// restore feeds r to one restore command, which reads it while it's
+// running, so the input is never held in memory at once. The Line
+// of the returned Error is the failing line of r.
+func (s set) restore(ctx context.Context, r io.Reader, exist ...bool) error {
 	args := []string{_restore}
 	if len(exist) > 0 && exist[0] {
 		args = append(args, _exist)
 	}
-	c := execCommand(ipsetPath, args...)
-
-	var pipe io.WriteCloser
-	pipe, err = c.StdinPipe()
-	if err != nil {
-		return err
-	}
-
-	if _, err = pipe.Write(b); err != nil {
-		return pipe.Close()
+	if out, err := s.client.Backend().Run(ctx, args, r); err != nil {
+		return failure(ctx, _restore, s.name, "", out)
 	}
+	return nil
+}
 
-	if err = pipe.Close(); err != nil {
-		return
-	}
+func (s set) RestoreFromFile(filename string, exist ...bool) error {
+	return s.RestoreFromFileContext(context.Background(), filename, exist...)
+}
 
-	var out []byte
-	if out, err = c.CombinedOutput(); err != nil {
-		return fmt.Errorf("%s", out)
-	}
+func (s set) RestoreFromFileContext(ctx context.Context, filename string, exist ...bool) error {
+	return s.RestoreFromFileWithContext(ctx, filename, Exist(len(exist) > 0 && exist[0]))
+}
 
-	return
+func (s set) RestoreFromFileWith(filename string, options ...Option) error {
+	return s.RestoreFromFileWithContext(context.Background(), filename, options...)
 }
 
-func (s set) RestoreFromFile(filename string, exist ...bool) (err error) {
-	var f *os.File
-	f, err = os.Open(filepath.Clean(filename))
+func (s set) RestoreFromFileWithContext(ctx context.Context, filename string, options ...Option) (err error) {
+	o := acquireOptions().apply(options...)
+	exist, required := o.exist, o.reqChecksum
+	releaseOptions(o)
+
+	f, err := openFile(filename)
 	if err != nil {
 		return
 	}
 	defer func() {
-		if e := f.Close(); e != nil {
+		if e := f.Close(); e != nil && err == nil {
 			err = e
 		}
 	}()
-	return s.Restore(f, exist...)
-}
-
-var readerPool sync.Pool
-
-func acquireReader(r io.Reader) *bufio.Reader {
-	v := readerPool.Get()
-	if v == nil {
-		return bufio.NewReader(r)
+	// the file is read through and verified before anything is
+	// restored, since restore applies the lines while reading them
+	if err = f.verify(required); err != nil {
+		return fmt.Errorf("ipset: can't restore to %s(%s): %w", s.name, s.setType, err)
 	}
-	br := v.(*bufio.Reader)
-	br.Reset(r)
-	return br
-}
-
-func releaseReader(br *bufio.Reader) {
-	readerPool.Put(br)
+	return s.RestoreContext(ctx, f, exist)
 }
@@ -1,11 +1,17 @@
 package ipset
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -23,9 +29,14 @@ func Test_Set_List(t *testing.T) {
 		assert.Equal(t, s.name, info.Name)
 		assert.Equal(t, s.setType, info.SetType)
 		assert.Equal(t, 4, info.Revision)
-		assert.Equal(t, "family inet hashsize 1024 maxelem 65536", info.Header)
+		assert.Equal(t, "family inet hashsize 1024 maxelem 65536", info.Header.String())
+		assert.Equal(t, Inet, info.Header.Family)
+		assert.Equal(t, uint(65536), info.Header.MaxElem)
 		assert.Equal(t, 0, info.References)
+		assert.Equal(t, 1, info.NumEntries)
 		assert.Equal(t, "1.1.1.1", info.Entries[0])
+		require.Len(t, info.Members, 1)
+		assert.Equal(t, "1.1.1.1", info.Members[0].IP.String())
 	})
 
 	t.Run("error", func(t *testing.T) {
@@ -43,6 +54,75 @@ func Test_Set_List(t *testing.T) {
 	})
 }
 
+func Test_Set_ForEachEntry(t *testing.T) {
+	t.Run("exec", func(t *testing.T) {
+		setupCmd()
+		defer teardownCmd()
+		s := getSet()
+
+		var elems []string
+		require.Nil(t, s.ForEachEntry(func(e *Entry) error {
+			elems = append(elems, e.Elem)
+			return nil
+		}))
+		assert.Equal(t, []string{"1.1.1.1"}, elems)
+
+		// the utility is not read to the end
+		require.Nil(t, s.ForEachEntry(func(e *Entry) error {
+			return ErrStop
+		}))
+	})
+
+	t.Run("error", func(t *testing.T) {
+		setupCmd(flag)
+		defer teardownCmd()
+		s := getSet()
+
+		err := s.ForEachEntry(func(e *Entry) error { return nil })
+		require.Error(t, err)
+		assert.Equal(t, fmt.Sprintf("ipset: can't %s %s: fake error", _list, s.name), err.Error())
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		c := NewClient(NewEmulator())
+		s, err := asSet(c.New("foo", HashNet))
+		require.Nil(t, err)
+		for _, n := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"} {
+			require.Nil(t, s.Add(n))
+		}
+
+		var cidrs []uint8
+		require.Nil(t, s.ForEachEntry(func(e *Entry) error {
+			cidrs = append(cidrs, e.CIDR)
+			if len(cidrs) == 2 {
+				return ErrStop
+			}
+			return nil
+		}, Sorted(true)))
+		assert.Equal(t, []uint8{8, 12}, cidrs)
+
+		fail := errors.New("fail")
+		err = s.ForEachEntry(func(e *Entry) error { return fail })
+		assert.Equal(t, fail, err)
+	})
+}
+
+func Test_ReadInfos(t *testing.T) {
+	var names []string
+	err := readInfos(strings.NewReader(listAllInfo), func(info *Info) error {
+		names = append(names, info.Name)
+		return nil
+	})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"foo", "bar"}, names)
+
+	stop := errors.New("stop")
+	err = readInfos(strings.NewReader(listAllInfo), func(info *Info) error {
+		return stop
+	})
+	assert.Equal(t, stop, err)
+}
+
 func Test_Set_ListToFile(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
 		setupCmd()
@@ -60,6 +140,22 @@ func Test_Set_ListToFile(t *testing.T) {
 		assert.Contains(t, string(b), "one.one.one.one")
 	})
 
+	t.Run("output", func(t *testing.T) {
+		r := &recorder{}
+		s := &set{name: "foo", setType: HashIp, client: NewClient(r)}
+
+		filename := "list_output.test"
+		defer removeFile(t, filename)
+
+		require.Nil(t, s.ListToFile(filename, Output(OutputXML)))
+		assert.Equal(t, []string{"list foo -output xml"}, r.lines)
+
+		// only the plain output is parsed
+		_, err := s.List(Output(OutputXML))
+		assert.True(t, errors.Is(err, ErrInvalidOption))
+		assert.Len(t, r.lines, 1)
+	})
+
 	t.Run("error", func(t *testing.T) {
 		setupCmd(flag)
 		defer teardownCmd()
@@ -272,6 +368,38 @@ func Test_Set_Save(t *testing.T) {
 	})
 }
 
+func Test_Set_SaveTo(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		setupCmd()
+		defer teardownCmd()
+		s := getSet()
+
+		var b bytes.Buffer
+		require.Nil(t, s.SaveTo(&b))
+		assert.Equal(t, saveInfo, b.String())
+	})
+
+	t.Run("error", func(t *testing.T) {
+		setupCmd(flag)
+		defer teardownCmd()
+		s := getSet()
+
+		err := s.SaveTo(ioutil.Discard)
+		require.Error(t, err)
+		assert.Equal(t, fmt.Sprintf("ipset: can't %s %s: fake error", _save, s.name), err.Error())
+	})
+
+	t.Run("writer error", func(t *testing.T) {
+		c := NewClient(NewEmulator())
+		s, err := asSet(c.New("foo", HashIp))
+		require.Nil(t, err)
+
+		r, w := io.Pipe()
+		require.Nil(t, r.Close())
+		assert.Equal(t, io.ErrClosedPipe, s.SaveTo(w))
+	})
+}
+
 func Test_Set_SaveToFile(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
 		setupCmd()
@@ -306,7 +434,6 @@ func Test_Set_SaveToFile(t *testing.T) {
 
 func Test_Set_Restore(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
-		maxRestoreSize = 10
 		setupCmd()
 		defer teardownCmd()
 		s := getSet()
@@ -328,11 +455,40 @@ func Test_Set_Restore(t *testing.T) {
 			err.Error())
 
 	})
+
+	t.Run("one process", func(t *testing.T) {
+		var runs, lines int
+		c := NewClient(BackendFunc(func(_ context.Context, args []string, stdin io.Reader) ([]byte, error) {
+			runs++
+			sc := bufio.NewScanner(stdin)
+			for sc.Scan() {
+				lines++
+			}
+			return nil, sc.Err()
+		}))
+		s := &set{name: "foo", setType: HashIp, client: c}
+
+		entries := &lineReader{next: func(i int) (string, bool) {
+			return "add foo 10.0.0.1", i < 100000
+		}}
+		require.Nil(t, s.Restore(entries))
+		assert.Equal(t, 1, runs)
+		assert.Equal(t, 100000, lines)
+	})
+
+	t.Run("failing line", func(t *testing.T) {
+		s, err := NewClient(NewEmulator()).New("foo", HashIp)
+		require.Nil(t, err)
+
+		err = s.Restore(strings.NewReader("add foo 1.1.1.1\n\nadd foo x\nadd foo 1.1.1.2\n"))
+		var e *Error
+		require.True(t, errors.As(err, &e))
+		assert.Equal(t, 3, e.Line)
+	})
 }
 
 func Test_Set_RestoreFromFile(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
-		maxRestoreSize = 10
 		setupCmd()
 		defer teardownCmd()
 		s := getSet()
@@ -345,6 +501,30 @@ func Test_Set_RestoreFromFile(t *testing.T) {
 		require.Nil(t, err)
 	})
 
+	t.Run("checksum", func(t *testing.T) {
+		setupCmd()
+		defer teardownCmd()
+		s := getSet()
+
+		filename := "restore.test"
+		require.NoError(t, writeFile(filename, "", true, func(w io.Writer) error {
+			_, err := io.WriteString(w, "1.1.1.1\n")
+			return err
+		}))
+		defer removeFile(t, filename)
+		require.Nil(t, s.RestoreFromFileWith(filename, Exist(true), RequireChecksum(true)))
+
+		content, err := ioutil.ReadFile(filename)
+		require.Nil(t, err)
+		require.NoError(t, ioutil.WriteFile(filename, bytes.Replace(content, []byte("1.1.1.1"), []byte("1.1.1.2"), 1), 0600))
+		assert.True(t, errors.Is(s.RestoreFromFile(filename), ErrChecksum))
+
+		// plain files are refused only if the checksum is required
+		require.NoError(t, ioutil.WriteFile(filename, []byte("1.1.1.1\n"), 0600))
+		err = s.RestoreFromFileWith(filename, RequireChecksum(true))
+		assert.True(t, errors.Is(err, ErrChecksum))
+	})
+
 	t.Run("no file error", func(t *testing.T) {
 		setupCmd(flag)
 		defer teardownCmd()
@@ -376,8 +556,17 @@ func Test_Set_RestoreFromFile(t *testing.T) {
 	})
 }
 
+// asSet returns the set behind s, which implements the optional
+// interfaces besides IPSet.
+func asSet(s IPSet, err error) (*set, error) {
+	if err != nil {
+		return nil, err
+	}
+	return s.(*set), nil
+}
+
 func getSet(setType ...SetType) set {
-	s := set{"test", HashIp}
+	s := set{name: "test", setType: HashIp, client: defaultClient}
 	if len(setType) > 0 {
 		s.setType = setType[0]
 	}
@@ -387,3 +576,55 @@ func getSet(setType ...SetType) set {
 func removeFile(t assert.TestingT, filename string) {
 	assert.Nil(t, os.Remove(filename))
 }
+
+func Test_Set_Context(t *testing.T) {
+	t.Parallel()
+
+	s, err := asSet(NewClient(NewEmulator()).New("foo", HashIp))
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.Nil(t, s.AddContext(ctx, "1.1.1.1"))
+	ok, err := s.TestContext(ctx, "1.1.1.1")
+	require.Nil(t, err)
+	assert.True(t, ok)
+
+	cancel()
+	err = s.AddContext(ctx, "1.1.1.2")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, "ipset: can't add foo 1.1.1.2: context canceled", err.Error())
+
+	_, err = s.TestContext(ctx, "1.1.1.2")
+	assert.True(t, errors.Is(err, context.Canceled))
+	_, err = s.ListContext(ctx)
+	assert.True(t, errors.Is(err, context.Canceled))
+	err = s.RestoreContext(ctx, strings.NewReader("add foo 1.1.1.3\n"))
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, errors.Is(s.FlushContext(ctx), context.Canceled))
+	assert.True(t, errors.Is(s.DestroyContext(ctx), context.Canceled))
+
+	// failures of ipset don't wrap the context errors
+	err = s.DelContext(context.Background(), "1.1.1.2")
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, context.Canceled))
+
+	info, err := s.List()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"1.1.1.1"}, info.Entries)
+}
+
+func Test_Set_Context_Exec(t *testing.T) {
+	setupHangCmd()
+	defer teardownCmd()
+
+	s := &set{name: "foo", setType: HashIp, client: NewClient(ExecBackend{})}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := s.AddContext(ctx, "1.1.1.1")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.True(t, time.Since(start) < 10*time.Second)
+}
@@ -1,25 +1,33 @@
 package ipset
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
+	"strings"
 	"testing"
+	"time"
 )
 
 var (
 	needError bool
+	needHang  bool
 	flag      = struct{}{}
 )
 
-func fakeExecCommand(command string, args ...string) *exec.Cmd {
+func fakeExecCommand(ctx context.Context, command string, args ...string) *exec.Cmd {
 	cs := []string{"-test.run=TestHelperProcess", "--", command}
 	cs = append(cs, args...)
-	cmd := exec.Command(os.Args[0], cs...)
+	cmd := exec.CommandContext(ctx, os.Args[0], cs...)
 	cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1"}
 	if needError {
 		cmd.Env = append(cmd.Env, "GO_WANT_HELPER_NEED_ERR=1")
 	}
+	if needHang {
+		cmd.Env = append(cmd.Env, "GO_WANT_HELPER_HANG=1")
+	}
 	return cmd
 }
 
@@ -41,11 +49,20 @@ func TestHelperProcess(t *testing.T) {
 		os.Exit(2)
 	}
 
+	if os.Getenv("GO_WANT_HELPER_HANG") == "1" {
+		time.Sleep(time.Minute)
+	}
+
 	if os.Getenv("GO_WANT_HELPER_NEED_ERR") == "1" {
 		_, _ = fmt.Fprintf(os.Stderr, "fake error")
 		os.Exit(1)
 	}
 
+	if len(args) > 1 && args[1] == "-" {
+		interactive()
+		os.Exit(0)
+	}
+
 	if len(args) > 1 {
 		switch args[1] {
 		case _version:
@@ -55,7 +72,11 @@ func TestHelperProcess(t *testing.T) {
 				_, _ = fmt.Fprintf(os.Stdout, validVersion)
 			}
 		case _list:
-			if findOption(args, "-resolve") {
+			if findOption(args, _names) {
+				_, _ = fmt.Fprintf(os.Stdout, listNames)
+			} else if len(args) == 2 || strings.HasPrefix(args[2], "-") {
+				_, _ = fmt.Fprintf(os.Stdout, listAllInfo)
+			} else if findOption(args, "-resolve") {
 				_, _ = fmt.Fprintf(os.Stdout, listInfoResolved)
 			} else {
 				_, _ = fmt.Fprintf(os.Stdout, listInfo)
@@ -77,6 +98,34 @@ func TestHelperProcess(t *testing.T) {
 	os.Exit(0)
 }
 
+// interactive acts as `ipset -` with an emulator, the "die" command
+// makes it exit and the "mute" command makes it print nothing like
+// a utility which buffers its output.
+func interactive() {
+	e := NewEmulator()
+	s := bufio.NewScanner(os.Stdin)
+	_, _ = fmt.Fprint(os.Stdout, "ipset> ")
+	for s.Scan() {
+		words, _ := splitLine(s.Text())
+		if len(words) > 0 && words[0] == "die" {
+			os.Exit(1)
+		}
+		if len(words) > 0 && words[0] == "mute" {
+			os.Stdout, os.Stderr = nil, nil
+			continue
+		}
+		if len(words) > 0 && words[0] == "sleep" {
+			time.Sleep(time.Second)
+		}
+		if out, err := e.Run(context.Background(), words, nil); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "ipset v7.1: %s\n", out)
+		} else {
+			_, _ = os.Stdout.Write(out)
+		}
+		_, _ = fmt.Fprint(os.Stdout, "ipset> ")
+	}
+}
+
 func findOption(args []string, target string) bool {
 	for _, arg := range args {
 		if arg == target {
@@ -94,8 +143,16 @@ func setupCmd(flag ...struct{}) {
 }
 
 func teardownCmd() {
-	execCommand = exec.Command
+	execCommand = exec.CommandContext
 	needError = false
+	needHang = false
+	// the version detected by the fake command is forgotten
+	defaultClient.version = nil
+}
+
+func setupHangCmd() {
+	execCommand = fakeExecCommand
+	needHang = true
 }
 
 func setupLookPath(filename ...string) {
@@ -119,6 +176,7 @@ func setupLookPath(filename ...string) {
 func teardownLookPath() {
 	execLookPath = exec.LookPath
 	ipsetPath = ""
+	defaultClient.version = nil
 }
 
 const (
@@ -144,7 +202,21 @@ References: 0
 Number of entries: 1
 Members:
 one.one.one.one`
-	saveInfo = `
+	listAllInfo = listInfo + `
+
+Name: bar
+Type: hash:net,port
+Revision: 7
+Header: family inet6 hashsize 1024 maxelem 65536 timeout 60
+Size in memory: 1024
+References: 1
+Number of entries: 2
+Members:
+2001:db8::/32,tcp:80 timeout 59
+::1,udp:53 timeout 10
+`
+	listNames = "foo\nbar\n"
+	saveInfo  = `
 create foo hash:ip family inet hashsize 1024 maxelem 65536
 add foo 1.1.1.1
 `
@@ -0,0 +1,175 @@
+package ipset
+
+// Kind is the data type of a dimension of a set type.
+type Kind string
+
+// Kinds of dimensions
+const (
+	KindIp    Kind = _ip
+	KindNet   Kind = _net
+	KindMac   Kind = _mac
+	KindPort  Kind = _port
+	KindIface Kind = _iface
+	KindMark  Kind = _mark
+	KindSet   Kind = _set
+)
+
+// TypeInfo describes what a set type supports.
+type TypeInfo struct {
+	Type SetType
+	// Method is the storage method, one of bitmap, hash and list.
+	Method string
+	// Dimensions are the kinds of the data types in order.
+	Dimensions []Kind
+	// CreateOptions are the options of create, e.g. "hashsize".
+	CreateOptions []string
+	// AddOptions are the options of add, e.g. "comment".
+	AddOptions []string
+	// IPv6 is set if the type can be created with family inet6.
+	IPv6 bool
+	// MaxSize is the maximum number of entries, 0 means the size is
+	// limited by maxelem or size only.
+	MaxSize uint32
+	// MinVersion is the minimum version of ipset supporting the type.
+	MinVersion VersionInfo
+}
+
+// SupportsCreate reports whether option is an option of create.
+func (i TypeInfo) SupportsCreate(option string) bool {
+	return contains(i.CreateOptions, option)
+}
+
+// SupportsAdd reports whether option is an option of add.
+func (i TypeInfo) SupportsAdd(option string) bool {
+	return contains(i.AddOptions, option)
+}
+
+var (
+	extCreateOptions = []string{_timeout, _counters, _comment, _skbinfo}
+	extAddOptions    = []string{_timeout, _packets, _bytes, _comment, _skbmark, _skbprio, _skbqueue}
+	hashOptions      = []string{_family, _hashsize, _maxelem, _forceadd, _bucketsize, _initval}
+)
+
+// types are the set types in the order of the ipset manual.
+var types = []TypeInfo{
+	bitmapType(BitmapIp, ver(6, 0), _range, _netmask),
+	bitmapType(BitmapIpMac, ver(6, 0), _range),
+	bitmapType(BitmapPort, ver(6, 0), _range),
+	hashType(HashIp, ver(6, 0), false, _netmask),
+	{
+		Type:          HashMac,
+		Method:        "hash",
+		Dimensions:    []Kind{KindMac},
+		CreateOptions: join(extCreateOptions, _hashsize, _maxelem, _forceadd, _bucketsize, _initval),
+		AddOptions:    extAddOptions,
+		MinVersion:    ver(6, 23),
+	},
+	hashType(HashIpMac, ver(6, 30), false),
+	hashType(HashNet, ver(6, 0), true),
+	hashType(HashNetNet, ver(6, 24), true),
+	hashType(HashIpPort, ver(6, 0), false),
+	hashType(HashNetPort, ver(6, 0), true),
+	hashType(HashIpPortIp, ver(6, 0), false),
+	hashType(HashIpPortNet, ver(6, 0), true),
+	hashType(HashIpMark, ver(6, 24), false, _markmask),
+	hashType(HashNetPortNet, ver(6, 24), true),
+	withAddOptions(hashType(HashNetIface, ver(6, 0), true), _wildcard),
+	{
+		Type:          ListSet,
+		Method:        "list",
+		Dimensions:    []Kind{KindSet},
+		CreateOptions: join(extCreateOptions, _size),
+		AddOptions:    extAddOptions,
+		MinVersion:    ver(6, 0),
+	},
+}
+
+func bitmapType(t SetType, version VersionInfo, options ...string) TypeInfo {
+	return TypeInfo{
+		Type:          t,
+		Method:        "bitmap",
+		Dimensions:    kinds(t),
+		CreateOptions: join(options, extCreateOptions...),
+		AddOptions:    extAddOptions,
+		MaxSize:       65536,
+		MinVersion:    version,
+	}
+}
+
+func hashType(t SetType, version VersionInfo, nomatch bool, options ...string) TypeInfo {
+	i := TypeInfo{
+		Type:          t,
+		Method:        "hash",
+		Dimensions:    kinds(t),
+		CreateOptions: join(join(hashOptions, options...), extCreateOptions...),
+		AddOptions:    extAddOptions,
+		IPv6:          true,
+		MinVersion:    version,
+	}
+	if nomatch {
+		i.AddOptions = join(extAddOptions, _nomatch)
+	}
+	return i
+}
+
+func withAddOptions(i TypeInfo, options ...string) TypeInfo {
+	i.AddOptions = join(i.AddOptions, options...)
+	return i
+}
+
+// Types returns the information of all the set types.
+func Types() []TypeInfo {
+	return append([]TypeInfo(nil), types...)
+}
+
+// LookupType returns the information of the set type, ok is false if
+// the type is unknown.
+func LookupType(setType SetType) (info TypeInfo, ok bool) {
+	for _, i := range types {
+		if i.Type == setType {
+			return i, true
+		}
+	}
+	return TypeInfo{}, false
+}
+
+// typeInfo returns the information of the set type. Unknown types
+// are guessed by their method.
+func typeInfo(setType SetType) TypeInfo {
+	if i, ok := LookupType(setType); ok {
+		return i
+	}
+	i := TypeInfo{
+		Type:          setType,
+		Method:        method(setType),
+		Dimensions:    kinds(setType),
+		CreateOptions: extCreateOptions,
+		AddOptions:    extAddOptions,
+	}
+	if i.Method == "hash" {
+		i.CreateOptions = join(hashOptions, extCreateOptions...)
+	}
+	return i
+}
+
+func kinds(setType SetType) []Kind {
+	dims := dimensions(setType)
+	ks := make([]Kind, len(dims))
+	for i, dim := range dims {
+		ks[i] = Kind(dim)
+	}
+	return ks
+}
+
+func join(a []string, b ...string) []string {
+	return append(a[:len(a):len(a)], b...)
+}
+
+func contains(a []string, s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
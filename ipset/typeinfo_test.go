@@ -0,0 +1,58 @@
+package ipset
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Types(t *testing.T) {
+	t.Parallel()
+
+	all := Types()
+	require.Len(t, all, len(testSetTypes))
+	for i, setType := range testSetTypes {
+		assert.Equal(t, setType, all[i].Type)
+		assert.Equal(t, method(setType), all[i].Method)
+		assert.Len(t, all[i].Dimensions, len(dimensions(setType)))
+		assert.True(t, all[i].SupportsCreate(_timeout), setType)
+		assert.True(t, all[i].SupportsAdd(_comment), setType)
+	}
+
+	// the registry is not changed by callers
+	all[0].Type = "foo"
+	assert.Equal(t, BitmapIp, Types()[0].Type)
+
+	info, ok := LookupType(HashNetIface)
+	require.True(t, ok)
+	assert.Equal(t, []Kind{KindNet, KindIface}, info.Dimensions)
+	assert.True(t, info.IPv6)
+	assert.True(t, info.SupportsAdd(_nomatch))
+	assert.True(t, info.SupportsCreate(_hashsize))
+	assert.False(t, info.SupportsCreate(_netmask))
+
+	info, ok = LookupType(BitmapPort)
+	require.True(t, ok)
+	assert.Equal(t, []Kind{KindPort}, info.Dimensions)
+	assert.False(t, info.IPv6)
+	assert.Equal(t, uint32(65536), info.MaxSize)
+	assert.True(t, info.SupportsCreate(_range))
+	assert.False(t, info.SupportsAdd(_nomatch))
+
+	info, ok = LookupType(HashMac)
+	require.True(t, ok)
+	assert.False(t, info.SupportsCreate(_family))
+	assert.Equal(t, VersionInfo{Major: 6, Minor: 23}, info.MinVersion)
+
+	info, _ = LookupType(ListSet)
+	assert.Equal(t, []Kind{KindSet}, info.Dimensions)
+	assert.True(t, info.SupportsCreate(_size))
+
+	_, ok = LookupType("hash:foo")
+	assert.False(t, ok)
+	// unknown types are guessed by their method
+	info = typeInfo("hash:foo")
+	assert.True(t, info.SupportsCreate(_hashsize))
+	assert.False(t, typeInfo("bitmap:foo").SupportsCreate(_hashsize))
+}
@@ -0,0 +1,159 @@
+package ipset
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// maxTimeout is the largest possible timeout.
+const maxTimeout = 2147483 * time.Second
+
+// Validate checks the options of an action, e.g. "create" or "add",
+// against the set type like the Strict option does. An error wrapping
+// ErrInvalidOption is returned for the first invalid option, and
+// warnings tell options which are valid but probably not intended,
+// e.g. a hashsize which is not a power of two.
+func Validate(action string, setType SetType, options ...Option) (warnings []string, err error) {
+	c := getCmd(action, "", setType)
+	defer putCmd(c)
+
+	o := acquireOptions().apply(options...)
+	defer releaseOptions(o)
+
+	return c.validate(o)
+}
+
+// validate checks the options like appendArgs, the options which
+// appendArgs leaves out are reported.
+func (c *cmd) validate(o *options) (warnings []string, err error) {
+	var invalid []string
+	check := func(set, ok bool, name string) {
+		if set && !ok {
+			invalid = append(invalid, name)
+		}
+	}
+
+	check(o.timeout > 0 || o.hasTimeout, c.needTimeout(), _timeout)
+	check(o.exist, c.needExist(), _exist)
+	check(o.resolve, c.needResolve(), _resolve)
+	check(o.counters, c.needCounters(), _counters)
+	check(o.countersPackets > 0, c.onlyAdd(), _packets)
+	check(o.countersBytes > 0, c.onlyAdd(), _bytes)
+	check(o.comment, c.onlyCreate(), _comment)
+	check(o.commentContent != "", c.onlyAdd(), _comment)
+	check(o.skbinfo, c.onlyCreate(), _skbinfo)
+	check(o.skbmark != "", c.onlyAdd(), _skbmark)
+	check(o.skbprio != "", c.onlyAdd(), _skbprio)
+	check(o.skbqueue != 0, c.onlyAdd(), _skbqueue)
+	check(o.nomatch, c.needNomatch(), _nomatch)
+	check(o.forceadd, c.needForceadd(), _forceadd)
+	check(o.family != "", c.needFamily(), _family)
+	check(o.hashSize != 0, c.needHash(), _hashsize)
+	check(o.maxElem != 0, c.needHash(), _maxelem)
+	check(o.netmask != 0, c.needNetmask(), _netmask)
+	check(o.markmask != 0, c.needMarkmask(), _markmask)
+	check(o.listSize != 0, c.needListSize(), _size)
+	check(o.ipRange != "", c.needIpRange(), _range)
+	check(o.portRange != "", c.needPortRange(), _range)
+	check(o.bucketsize != 0, c.needHash(), _bucketsize)
+	check(o.initval != 0, c.needHash(), _initval)
+	check(o.wildcard, c.needWildcard(), _wildcard)
+	check(o.sorted, c.needSorted(), _sorted)
+	check(o.output != "", c.needOutput(), _output)
+	check(o.file != "", c.onlySave(), _file)
+	check(o.terse, c.onlyList(), _terse)
+	if len(invalid) == 1 {
+		return nil, invalidOption("%s is not supported by %s of %s", invalid[0], c.action, c.setType)
+	} else if len(invalid) > 1 {
+		return nil, invalidOption("%s are not supported by %s of %s",
+			strings.Join(invalid, ", "), c.action, c.setType)
+	}
+
+	if o.timeout > maxTimeout {
+		return nil, invalidOption("timeout %s is larger than %s", o.timeout, maxTimeout)
+	}
+	if o.family != "" && o.family != Inet && o.family != Inet6 {
+		return nil, invalidOption("unknown family %s", o.family)
+	}
+	if o.bucketsize != 0 && (o.bucketsize < 2 || o.bucketsize > 12) {
+		return nil, invalidOption("bucketsize %d is out of range 2-12", o.bucketsize)
+	}
+	if o.output != "" && o.output != OutputPlain && o.output != OutputSave && o.output != OutputXML {
+		return nil, invalidOption("unknown output mode %s", o.output)
+	}
+	if o.netmask != 0 {
+		bits := byte(32)
+		if o.family == Inet6 {
+			bits = 128
+		}
+		if o.netmask > bits {
+			return nil, invalidOption("netmask %d is out of range 1-%d", o.netmask, bits)
+		}
+	}
+	if info := typeInfo(c.setType); c.action == _create && info.Method == "bitmap" {
+		if c.needIpRange() && o.ipRange == "" || c.needPortRange() && o.portRange == "" {
+			return nil, invalidOption("range is required by %s", c.setType)
+		}
+		if n, ok := rangeSize(o.ipRange, o.netmask); ok && n > uint64(info.MaxSize) {
+			return nil, invalidOption("range %s has more than %d entries", o.ipRange, info.MaxSize)
+		}
+	}
+
+	// extensions of add must be enabled when the set is created
+	if h := c.header; h != nil && c.action == _add {
+		switch {
+		case o.timeout > 0 && !h.HasTimeout:
+			err = invalidOption("timeout can't be used: set was created without timeout support")
+		case (o.countersPackets > 0 || o.countersBytes > 0) && !h.Counters:
+			err = invalidOption("packets and bytes can't be used: set was created without counter support")
+		case o.commentContent != "" && !h.Comment:
+			err = invalidOption("comment can't be used: set was created without comment support")
+		case (o.skbmark != "" || o.skbprio != "" || o.skbqueue != 0) && !h.Skbinfo:
+			err = invalidOption("skbinfo can't be used: set was created without skbinfo support")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if o.hashSize != 0 && o.hashSize&(o.hashSize-1) != 0 {
+		warnings = append(warnings, fmt.Sprintf("hashsize %d is not a power of two, it's rounded up", o.hashSize))
+	}
+	return warnings, nil
+}
+
+// rangeSize returns the number of entries of an IPv4 range or network
+// stored with the netmask.
+func rangeSize(ipRange string, netmask byte) (uint64, bool) {
+	var from, to uint32
+	if _, ipNet, err := net.ParseCIDR(ipRange); err == nil {
+		ones, bits := ipNet.Mask.Size()
+		if bits != 32 {
+			return 0, false
+		}
+		from = ip2uint(ipNet.IP)
+		to = from | (1<<uint(32-ones) - 1)
+	} else if i := strings.IndexByte(ipRange, '-'); i != -1 {
+		a, b := net.ParseIP(ipRange[:i]).To4(), net.ParseIP(ipRange[i+1:]).To4()
+		if a == nil || b == nil {
+			return 0, false
+		}
+		from, to = ip2uint(a), ip2uint(b)
+	} else {
+		return 0, false
+	}
+	if to < from {
+		return 0, false
+	}
+	n := uint64(to-from) + 1
+	if netmask != 0 && netmask < 32 {
+		n >>= 32 - netmask
+	}
+	return n, true
+}
+
+func invalidOption(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidOption, fmt.Sprintf(format, a...))
+}
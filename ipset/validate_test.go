@@ -0,0 +1,108 @@
+package ipset
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Strict(t *testing.T) {
+	t.Parallel()
+
+	r := &recorder{}
+	c := NewClient(r)
+
+	_, err := c.New("foo", HashNet, Netmask(24), Strict(true))
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+	assert.Equal(t, "ipset: can't create foo hash:net: invalid option: netmask is not supported by create of hash:net", err.Error())
+
+	s, err := asSet(c.New("foo", HashIp, Strict(true)))
+	require.Nil(t, err)
+	err = s.Add("1.1.1.1", Nomatch(true), Strict(true))
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+	assert.Equal(t, "ipset: can't add foo 1.1.1.1: invalid option: nomatch is not supported by add of hash:ip", err.Error())
+
+	// extensions must be enabled by create
+	err = s.Add("1.1.1.1", CommentContent("a"), Strict(true))
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+	_, err = s.AddMany([]string{"1.1.1.1"}, Timeout(time.Minute), Strict(true))
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+
+	// nothing is executed
+	assert.Equal(t, []string{"create foo hash:ip"}, r.lines)
+
+	// without strict the options are left out
+	require.Nil(t, s.Add("1.1.1.1", Nomatch(true), CommentContent("a")))
+	assert.Equal(t, "add foo 1.1.1.1 comment a", r.lines[1])
+
+	s, err = asSet(c.New("bar", HashIp, Comment(true)))
+	require.Nil(t, err)
+	assert.Nil(t, s.Add("1.1.1.1", CommentContent("a"), Strict(true)))
+
+	// forceadd of other types than hash is rejected with strict,
+	// and dropped without it
+	_, err = c.New("baz", BitmapPort, PortRange("0-1024"), Forceadd(true), Strict(true))
+	assert.True(t, errors.Is(err, ErrInvalidOption))
+	assert.Equal(t, "ipset: can't create baz bitmap:port: invalid option: forceadd is not supported by create of bitmap:port", err.Error())
+	_, err = c.New("baz", BitmapPort, PortRange("0-1024"), Forceadd(true))
+	assert.Nil(t, err)
+	assert.Equal(t, "create baz bitmap:port range 0-1024", r.lines[len(r.lines)-1])
+	_, err = c.New("baz", HashIp, Forceadd(true))
+	assert.Nil(t, err)
+	assert.Equal(t, "create baz hash:ip forceadd", r.lines[len(r.lines)-1])
+}
+
+func Test_Validate(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		action  string
+		setType SetType
+		options []Option
+		err     string
+	}{
+		{_create, HashIp, []Option{Timeout(time.Hour), Netmask(24), HashSize(1024)}, ""},
+		{_create, HashIp, []Option{Family(Inet6), Netmask(64)}, ""},
+		{_create, BitmapIp, []Option{IpRange("1.1.1.0/24")}, ""},
+		{_add, HashNet, []Option{Nomatch(true), CommentContent("a")}, ""},
+		{_create, HashNet, []Option{Netmask(24), Markmask(1)}, "netmask, markmask are not supported by create of hash:net"},
+		{_add, HashIp, []Option{HashSize(1024)}, "hashsize is not supported by add of hash:ip"},
+		{_create, ListSet, []Option{Forceadd(true)}, "forceadd is not supported by create of list:set"},
+		{_create, HashIp, []Option{Timeout(maxTimeout + time.Second)}, "timeout 596h31m24s is larger than 596h31m23s"},
+		{_create, HashIp, []Option{Family("inet7")}, "unknown family inet7"},
+		{_create, HashIp, []Option{Netmask(33)}, "netmask 33 is out of range 1-32"},
+		{_create, HashIp, []Option{Family(Inet6), Netmask(129)}, "netmask 129 is out of range 1-128"},
+		{_create, BitmapIp, nil, "range is required by bitmap:ip"},
+		{_create, HashIp, []Option{Bucketsize(4), Initval(1)}, ""},
+		{_create, HashIp, []Option{Bucketsize(14)}, "bucketsize 14 is out of range 2-12"},
+		{_create, BitmapIp, []Option{IpRange("1.1.1.0/24"), Bucketsize(4)}, "bucketsize is not supported by create of bitmap:ip"},
+		{_add, HashNet, []Option{Wildcard(true)}, "wildcard is not supported by add of hash:net"},
+		{_save, HashIp, []Option{Output("json")}, "unknown output mode json"},
+		{_list, HashIp, []Option{Output(OutputSave)}, ""},
+		{_add, HashIp, []Option{Output(OutputSave)}, "-output is not supported by add of hash:ip"},
+		{_create, BitmapIp, []Option{IpRange("10.0.0.0/15")}, "range 10.0.0.0/15 has more than 65536 entries"},
+		{_create, BitmapIp, []Option{IpRange("10.0.0.0-10.1.0.0")}, "range 10.0.0.0-10.1.0.0 has more than 65536 entries"},
+		{_create, BitmapIp, []Option{IpRange("10.0.0.0/8"), Netmask(24)}, ""},
+		{_create, BitmapIpMac, []Option{PortRange("1-1024")}, "range is not supported by create of bitmap:ip,mac"},
+		{_create, BitmapPort, []Option{IpRange("1.1.1.0/24")}, "range is not supported by create of bitmap:port"},
+	}
+
+	for _, tc := range tt {
+		_, err := Validate(tc.action, tc.setType, tc.options...)
+		if tc.err == "" {
+			assert.Nil(t, err, tc.setType)
+			continue
+		}
+		require.Error(t, err, tc.err)
+		assert.True(t, errors.Is(err, ErrInvalidOption))
+		assert.Equal(t, "invalid option: "+tc.err, err.Error())
+	}
+
+	warnings, err := Validate(_create, HashIp, HashSize(1000))
+	require.Nil(t, err)
+	assert.Equal(t, []string{"hashsize 1000 is not a power of two, it's rounded up"}, warnings)
+}
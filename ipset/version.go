@@ -0,0 +1,208 @@
+package ipset
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// VersionInfo is the version of the ipset utility and its protocol.
+type VersionInfo struct {
+	Major    int
+	Minor    int
+	Patch    int
+	Protocol int
+}
+
+func ver(major, minor int) VersionInfo {
+	return VersionInfo{Major: major, Minor: minor}
+}
+
+// Less reports whether v is older than o, protocols are ignored.
+func (v VersionInfo) Less(o VersionInfo) bool {
+	if v.Major != o.Major {
+		return v.Major < o.Major
+	}
+	if v.Minor != o.Minor {
+		return v.Minor < o.Minor
+	}
+	return v.Patch < o.Patch
+}
+
+func (v VersionInfo) String() string {
+	s := "v" + strconv.Itoa(v.Major) + "." + strconv.Itoa(v.Minor)
+	if v.Patch != 0 {
+		s += "." + strconv.Itoa(v.Patch)
+	}
+	return s
+}
+
+// Feature is a capability of ipset which is newer than v6.0.
+type Feature string
+
+// Features
+const (
+	FeatureCounters   Feature = _counters
+	FeatureComment    Feature = _comment
+	FeatureForceadd   Feature = _forceadd
+	FeatureSkbinfo    Feature = _skbinfo
+	FeatureFile       Feature = "file"
+	FeatureWildcard   Feature = _wildcard
+	FeatureBucketsize Feature = _bucketsize
+	FeatureInitval    Feature = _initval
+)
+
+// baseVersion is the oldest version supported by the package, its
+// options are used without detecting the version.
+var baseVersion = ver(minMajorVersion, 0)
+
+// features are the versions of ipset which introduced the features
+// in order.
+var features = []struct {
+	feature Feature
+	version VersionInfo
+}{
+	{FeatureCounters, ver(6, 20)},
+	{FeatureComment, ver(6, 21)},
+	{FeatureForceadd, ver(6, 22)},
+	{FeatureSkbinfo, ver(6, 24)},
+	{FeatureFile, ver(6, 24)},
+	{FeatureWildcard, ver(7, 4)},
+	{FeatureBucketsize, ver(7, 11)},
+	{FeatureInitval, ver(7, 11)},
+}
+
+func featureVersion(f Feature) VersionInfo {
+	for _, fv := range features {
+		if fv.feature == f {
+			return fv.version
+		}
+	}
+	return baseVersion
+}
+
+// Supports reports whether the feature is supported by v.
+func (v VersionInfo) Supports(f Feature) bool {
+	return !v.Less(featureVersion(f))
+}
+
+// SupportsType reports whether the set type is supported by v.
+func (v VersionInfo) SupportsType(setType SetType) bool {
+	return !v.Less(typeInfo(setType).MinVersion)
+}
+
+// Features returns the features supported by v.
+func (v VersionInfo) Features() []Feature {
+	var fs []Feature
+	for _, fv := range features {
+		if !v.Less(fv.version) {
+			fs = append(fs, fv.feature)
+		}
+	}
+	return fs
+}
+
+var (
+	versionRegexp  = regexp.MustCompile(`v(\d+)\.(\d+)(?:\.(\d+))?`)
+	protocolRegexp = regexp.MustCompile(`protocol version: (\d+)`)
+)
+
+// ParseVersion parses the output of version command, e.g. "ipset
+// v7.1, protocol version: 7". ok is false if no version is found.
+func ParseVersion(out []byte) (v VersionInfo, ok bool) {
+	m := versionRegexp.FindSubmatch(out)
+	if m == nil {
+		return v, false
+	}
+	v.Major, _ = strconv.Atoi(string(m[1]))
+	v.Minor, _ = strconv.Atoi(string(m[2]))
+	v.Patch, _ = strconv.Atoi(string(m[3]))
+	if m = protocolRegexp.FindSubmatch(out); m != nil {
+		v.Protocol, _ = strconv.Atoi(string(m[1]))
+	}
+	return v, true
+}
+
+// Version returns the version of ipset behind the backend, which is
+// detected once by Version or Check.
+func (c *Client) Version() (VersionInfo, error) {
+	return c.VersionContext(context.Background())
+}
+
+// VersionContext is like Version but the command is given up once
+// ctx is done.
+func (c *Client) VersionContext(ctx context.Context) (VersionInfo, error) {
+	v, ok, err := c.detectVersion(ctx)
+	if err != nil {
+		return v, fmt.Errorf("ipset: can't detect version: %w", err)
+	}
+	if !ok {
+		return v, fmt.Errorf("ipset: can't detect version: %w", ErrVersionUnknown)
+	}
+	return v, nil
+}
+
+// detectVersion returns the detected version, ok is false if the
+// version is unknown.
+func (c *Client) detectVersion(ctx context.Context) (v VersionInfo, ok bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.version != nil {
+		return *c.version, c.version.Major != 0, nil
+	}
+
+	out, err := c.Backend().Run(ctx, []string{_version}, nil)
+	if err != nil && ctx.Err() != nil {
+		return v, false, ctx.Err()
+	}
+	c.setVersion(out)
+	return *c.version, c.version.Major != 0, nil
+}
+
+// setVersion keeps the version in the output of version command, an
+// unknown version is kept as zero so that it's not detected again.
+func (c *Client) setVersion(out []byte) {
+	v, _ := ParseVersion(out)
+	c.version = &v
+}
+
+// require checks that the features are supported by the detected
+// version. Features are allowed if the version is unknown, or by
+// NetlinkBackend, whose version is made up of its protocol, then the
+// kernel rejects what it doesn't support.
+func (c *Client) require(ctx context.Context, fs ...Feature) error {
+	return c.requireVersion(ctx, "", fs...)
+}
+
+// requireVersion is like require but the set type is checked too if
+// it's not empty.
+func (c *Client) requireVersion(ctx context.Context, setType SetType, fs ...Feature) error {
+	need := setType != "" && baseVersion.Less(typeInfo(setType).MinVersion)
+	for _, f := range fs {
+		if baseVersion.Less(featureVersion(f)) {
+			need = true
+		}
+	}
+	if !need {
+		return nil
+	}
+	if _, ok := c.Backend().(*NetlinkBackend); ok {
+		return nil
+	}
+
+	v, ok, err := c.detectVersion(ctx)
+	if err != nil || !ok {
+		return err
+	}
+	if setType != "" && !v.SupportsType(setType) {
+		return fmt.Errorf("%w: %s requires ipset %s, found %s",
+			ErrUnsupported, setType, typeInfo(setType).MinVersion, v)
+	}
+	for _, f := range fs {
+		if !v.Supports(f) {
+			return fmt.Errorf("%w: %s requires ipset %s, found %s", ErrUnsupported, f, featureVersion(f), v)
+		}
+	}
+	return nil
+}
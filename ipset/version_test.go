@@ -0,0 +1,134 @@
+package ipset
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseVersion(t *testing.T) {
+	t.Parallel()
+
+	tt := []struct {
+		out string
+		v   VersionInfo
+		ok  bool
+	}{
+		{"ipset v7.1, protocol version: 7\n", VersionInfo{7, 1, 0, 7}, true},
+		{"ipset v6.20.1, protocol version: 6\n", VersionInfo{6, 20, 1, 6}, true},
+		{"ipset v7.1 (emulator), protocol version: 7\n", VersionInfo{7, 1, 0, 7}, true},
+		{"ipset v7.0 (netlink)", VersionInfo{7, 0, 0, 0}, true},
+		{"fake", VersionInfo{}, false},
+	}
+
+	for _, tc := range tt {
+		v, ok := ParseVersion([]byte(tc.out))
+		assert.Equal(t, tc.ok, ok, tc.out)
+		assert.Equal(t, tc.v, v, tc.out)
+	}
+	assert.True(t, ver(7, 4).Less(ver(7, 11)))
+	assert.True(t, VersionInfo{6, 20, 0, 6}.Less(VersionInfo{6, 20, 1, 6}))
+	assert.False(t, ver(8, 0).Less(ver(7, 11)))
+	assert.Equal(t, "v7.11", ver(7, 11).String())
+	assert.Equal(t, "v6.20.1", VersionInfo{6, 20, 1, 6}.String())
+}
+
+func Test_VersionInfo_Features(t *testing.T) {
+	t.Parallel()
+
+	v := ver(6, 22)
+	assert.Equal(t, []Feature{FeatureCounters, FeatureComment, FeatureForceadd}, v.Features())
+	assert.True(t, v.Supports(FeatureComment))
+	assert.False(t, v.Supports(FeatureSkbinfo))
+	assert.False(t, v.SupportsType(HashMac))
+	assert.True(t, v.SupportsType(HashNet))
+	assert.True(t, ver(7, 11).Supports(FeatureBucketsize))
+	assert.Len(t, ver(7, 11).Features(), len(features))
+}
+
+func Test_Client_Require(t *testing.T) {
+	t.Parallel()
+
+	r := &recorder{out: "ipset v7.1, protocol version: 7\n"}
+	c := NewClient(r)
+	_, err := c.New("foo", HashIp, Bucketsize(4))
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrUnsupported))
+	assert.Equal(t, "ipset: can't create foo hash:ip: not supported by ipset: bucketsize requires ipset v7.11, found v7.1", err.Error())
+
+	s, err := c.New("foo", HashNetIface, Family(Inet))
+	require.Nil(t, err)
+	err = s.Add("10.0.0.0/8,eth", Wildcard(true))
+	assert.True(t, errors.Is(err, ErrUnsupported))
+	// options of older versions don't need the version
+	_, err = s.Save(Sorted(true), Output(OutputPlain), File("foo.save"))
+	require.Nil(t, err)
+	// the version is detected once
+	assert.Equal(t, []string{"version", "create foo hash:net,iface family inet",
+		"save foo -sorted -output plain -file foo.save"}, r.lines)
+
+	r = &recorder{out: "ipset v7.19, protocol version: 7\n"}
+	c = NewClient(r)
+	_, err = c.New("foo", HashIp, Bucketsize(4), Initval(1))
+	require.Nil(t, err)
+	assert.Equal(t, "create foo hash:ip bucketsize 4 initval 0x00000001", r.lines[1])
+
+	// options are allowed if the version is unknown
+	r = &recorder{}
+	_, err = NewClient(r).New("foo", HashIp, Bucketsize(4))
+	require.Nil(t, err)
+	assert.Len(t, r.lines, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c = NewClient(BackendFunc(func(ctx context.Context, args []string, stdin io.Reader) ([]byte, error) {
+		cancel()
+		return nil, ctx.Err()
+	}))
+	_, err = c.NewContext(ctx, "foo", HashIp, Initval(1))
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Nil(t, c.version)
+
+	// set types and create options are checked
+	r = &recorder{out: "ipset v6.21, protocol version: 6\n"}
+	c = NewClient(r)
+	_, err = c.New("foo", HashMac)
+	assert.True(t, errors.Is(err, ErrUnsupported))
+	assert.Equal(t, "ipset: can't create foo hash:mac: not supported by ipset: hash:mac requires ipset v6.23, found v6.21", err.Error())
+	_, err = c.New("foo", HashIp, Skbinfo(true))
+	assert.True(t, errors.Is(err, ErrUnsupported))
+	_, err = c.New("foo", HashIp, Comment(true), Counters(true))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"version", "create foo hash:ip counters comment"}, r.lines)
+}
+
+func Test_Client_Version(t *testing.T) {
+	t.Parallel()
+
+	r := &recorder{out: "ipset v7.1, protocol version: 7\n"}
+	c := NewClient(r)
+	v, err := c.Version()
+	require.Nil(t, err)
+	assert.Equal(t, VersionInfo{7, 1, 0, 7}, v)
+	_, err = c.Version()
+	require.Nil(t, err)
+	assert.Len(t, r.lines, 1)
+
+	r = &recorder{out: "ipset v7.1, protocol version: 7\n"}
+	c = NewClient(r)
+	require.Nil(t, c.Check())
+	v, err = c.Version()
+	require.Nil(t, err)
+	assert.Equal(t, 7, v.Major)
+	assert.Len(t, r.lines, 1)
+
+	_, err = NewClient(&recorder{out: "fake"}).Version()
+	assert.True(t, errors.Is(err, ErrVersionUnknown))
+
+	v, err = NewClient(NewEmulator()).Version()
+	require.Nil(t, err)
+	assert.Equal(t, VersionInfo{7, 1, 0, 7}, v)
+}